@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+func TestAuditFileAccumulatesRuns(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	realm.AddGroup("/admins")
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	props := serverProps(s, "test") + "audit.file=" + auditFile + "\n"
+
+	if err := runTest(t, props+"dry.run.only=true\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runTest(t, props+"auto.confirm=true\n"); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	expected := []string{`"action":"create-role","result":"planned"`, `"action":"create-mapping","result":"planned"`,
+		`"action":"create-role","result":"success"`, `"action":"create-mapping","result":"success"`}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %v", len(expected), lines)
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, expected[i]) || !strings.Contains(line, `"role":"admins"`) {
+			t.Errorf("line %d: expected %s, got %s", i, expected[i], line)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// exportBaseline writes the roles currently mapped to the groups of the realm as JSON mapping rules, to
+// adopt the tool on a realm without changing it: with the file as role.mapping.file, the groups keep
+// their current role
+func (r *runner) exportBaseline(realmNames []string) error {
+	if len(realmNames) != 1 {
+		return fmt.Errorf("-%s exports a single realm, configure it with %s", FLAG_EXPORT_BASELINE, PROPS_REALM)
+	}
+	realm := realmNames[0]
+	if err := r.checkMasterRealm(realm); err != nil {
+		return err
+	}
+	realmConfig := r.config
+	realmConfig.Realm = realm
+	groups, err := mapper.New(r.k, realmConfig).ListGroups(r.ctx)
+	if err != nil {
+		return err
+	}
+	rules := map[string]string{}
+	for _, group := range groups {
+		roles := group.RealmRoles
+		if r.config.TargetClient != "" {
+			roles = append([]string{}, group.ClientRoles[r.config.TargetClient]...)
+			sort.Strings(roles)
+		}
+		switch len(roles) {
+		case 0:
+			logger.Warn("Group without role, the next run maps it to a new role", "path", group.Path)
+			continue
+		case 1:
+		default:
+			logger.Warn("Group with several roles, only the first one is exported", "path", group.Path, "roles", roles)
+		}
+		rules[group.Path] = roles[0]
+	}
+	f, err := os.Create(exportBaselineFile)
+	if err != nil {
+		return fmt.Errorf("cannot create baseline file %s: %w", exportBaselineFile, err)
+	}
+	defer f.Close()
+	if err := printJSONReport(f, rules); err != nil {
+		return fmt.Errorf("cannot write baseline file %s: %w", exportBaselineFile, err)
+	}
+	logger.Info("Exported baseline", "file", exportBaselineFile, "realm", realm, "groups", len(rules))
+	return nil
+}
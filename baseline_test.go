@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+func TestExportBaseline(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	realm.AddGroup("/admins", "admins")
+	realm.AddGroup("/eng/backend", "be")
+	realm.AddGroup("/multi", "first", "second")
+	realm.AddGroup("/empty")
+	for _, role := range []string{"admins", "be", "first", "second"} {
+		realm.AddRole(role)
+	}
+	file := filepath.Join(t.TempDir(), "baseline.json")
+	setFlag(t, &exportBaselineFile, file)
+	if err := runTest(t, serverProps(s, "test")); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules map[string]string
+	if err := json.Unmarshal(content, &rules); err != nil {
+		t.Fatal(err)
+	}
+	// The groups without role are left out, only the first role of a group is kept
+	expected := map[string]string{"/admins": "admins", "/eng/backend": "be", "/multi": "first"}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected the baseline %v, got %v", expected, rules)
+	}
+	for _, request := range s.Requests() {
+		if !strings.HasPrefix(request, "GET ") && !strings.HasSuffix(request, "/token") {
+			t.Errorf("expected the realm to be left unchanged, got %s", request)
+		}
+	}
+
+	// With the baseline as mapping rules, the exported groups keep their role
+	exportBaselineFile = ""
+	if err := runTest(t, serverProps(s, "test")+"auto.confirm=true\nrole.mapping.file="+file+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	for groupPath, roles := range map[string]string{"/admins": "admins", "/eng/backend": "be", "/multi": "first,second"} {
+		if actual := strings.Join(realm.Group(groupPath).RealmRoles, ","); actual != roles {
+			t.Errorf("expected group %s to keep the roles %s, got %s", groupPath, roles, actual)
+		}
+	}
+}
+
+func TestExportBaselineOfSeveralRealms(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	s.AddRealm("dev")
+	s.AddRealm("prod")
+	setFlag(t, &exportBaselineFile, filepath.Join(t.TempDir(), "baseline.json"))
+	err := runTest(t, serverProps(s, "dev")+"keycloak.realms=dev,prod\n")
+	if err == nil || !strings.Contains(err.Error(), "exports a single realm") {
+		t.Errorf("expected the export of several realms to be rejected, got %v", err)
+	}
+}
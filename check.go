@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// checkConfiguration logs in and resolves the configured realms, without listing the groups, as a
+// readiness probe of the configuration. The error tells which stage failed
+func (r *runner) checkConfiguration() error {
+	var err error
+	if r.k, err = mapper.Connect(r.ctx, r.config); err != nil {
+		return fmt.Errorf("login check failed: %w", r.explainLogin(err))
+	}
+	realmNames, err := r.listRealms()
+	if err != nil {
+		return fmt.Errorf("realm check failed: %w", err)
+	}
+	for _, realm := range realmNames {
+		realmConfig := r.config
+		realmConfig.Realm = realm
+		if err := r.checkMasterRealm(realm); err != nil {
+			return fmt.Errorf("realm check failed: %w", err)
+		}
+		if err := mapper.New(r.k, realmConfig).Check(r.ctx); err != nil {
+			return fmt.Errorf("realm check failed: %w", err)
+		}
+	}
+	fmt.Fprintf(r.console, "*** Configuration OK: logged in to %v, found realms %v ***\n", r.config.Server, realmNames)
+	return nil
+}
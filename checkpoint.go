@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// checkpointEntry is a line of the checkpoint file: a group whose mappings were all created. The entries
+// of another realm, or of another configuration of the realm, are ignored
+type checkpointEntry struct {
+	Realm   string `json:"realm"`
+	Config  string `json:"config"`
+	GroupID string `json:"groupId"`
+}
+
+// configHash identifies the configuration of a realm, without the credentials
+func configHash(realmConfig mapper.Config) string {
+	sum := sha256.Sum256([]byte(realmConfig.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// resumeCheckpoint skips the groups of the realm completed by a previous run with the same configuration,
+// and records the groups completed by this run
+func (r *runner) resumeCheckpoint(realmConfig *mapper.Config) error {
+	hash := configHash(*realmConfig)
+	processed, err := readCheckpoint(checkpointFile, realmConfig.Realm, hash)
+	if err != nil {
+		return err
+	}
+	if len(processed) > 0 {
+		logger.Info("Resuming from checkpoint", "realm", realmConfig.Realm, "groups", len(processed), "file", checkpointFile)
+	}
+	realm := realmConfig.Realm
+	realmConfig.ProcessedGroups = processed
+	realmConfig.GroupDone = func(groupID string) {
+		if err := json.NewEncoder(r.checkpointOut).Encode(checkpointEntry{Realm: realm, Config: hash, GroupID: groupID}); err != nil {
+			logger.Warn("Cannot write checkpoint", "file", checkpointFile, "group", groupID, "error", err)
+		}
+	}
+	return nil
+}
+
+// readCheckpoint returns the IDs of the groups of the realm completed with the given configuration.
+// A missing file is an empty checkpoint
+func readCheckpoint(fileName, realm, hash string) (map[string]bool, error) {
+	processed := map[string]bool{}
+	f, err := os.Open(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return processed, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read checkpoint %s: %w", fileName, err)
+	}
+	defer f.Close()
+	stale := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry checkpointEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// The last line may be truncated by an interrupted run
+			logger.Warn("Skipping invalid checkpoint line", "file", fileName, "error", err)
+			continue
+		}
+		if entry.Realm != realm {
+			continue
+		}
+		if entry.Config != hash {
+			stale++
+			continue
+		}
+		processed[entry.GroupID] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read checkpoint %s: %w", fileName, err)
+	}
+	if stale > 0 {
+		logger.Warn("Ignoring checkpoint of a different configuration", "realm", realm, "groups", stale)
+	}
+	return processed, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+// newCheckpointServer returns a realm whose mapping of group /users fails, as if the run was interrupted
+func newCheckpointServer(t *testing.T) (*keycloaktest.Server, *keycloaktest.Realm) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	realm.AddGroup("/admins")
+	users := realm.AddGroup("/users")
+	s.Fail = func(method string, path string) int {
+		if method == "POST" && strings.HasSuffix(path, "/groups/"+users.ID+"/role-mappings/realm") {
+			return 500
+		}
+		return 0
+	}
+	return s, realm
+}
+
+func TestCheckpointResume(t *testing.T) {
+	s, realm := newCheckpointServer(t)
+	props := serverProps(s, "test") + "auto.confirm=true\nmax.retries=0\n"
+	file := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	setFlag(t, &checkpointFile, file)
+	if err := runTest(t, props); err == nil {
+		t.Fatal("expected the mapping of /users to fail")
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), realm.Group("/admins").ID) || strings.Contains(string(content), realm.Group("/users").ID) {
+		t.Errorf("expected only /admins in the checkpoint, got %s", content)
+	}
+
+	// The resumed run skips /admins, even though its mapping was removed since
+	realm.Group("/admins").RealmRoles = nil
+	s.Fail = nil
+	if err := runTest(t, props); err != nil {
+		t.Fatal(err)
+	}
+	if len(realm.Group("/admins").RealmRoles) != 0 {
+		t.Errorf("expected /admins to be skipped, got roles %v", realm.Group("/admins").RealmRoles)
+	}
+	if roles := strings.Join(realm.Group("/users").RealmRoles, ","); roles != "users" {
+		t.Errorf("expected /users to be mapped on resume, got %s", roles)
+	}
+	if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the checkpoint to be removed after a successful run, got %v", err)
+	}
+}
+
+func TestCheckpointOfOtherConfig(t *testing.T) {
+	s, realm := newCheckpointServer(t)
+	props := serverProps(s, "test") + "auto.confirm=true\nmax.retries=0\n"
+	setFlag(t, &checkpointFile, filepath.Join(t.TempDir(), "checkpoint.jsonl"))
+	if err := runTest(t, props); err == nil {
+		t.Fatal("expected the mapping of /users to fail")
+	}
+
+	// A change of the configuration invalidates the checkpoint: /admins is processed again
+	realm.Group("/admins").RealmRoles = nil
+	s.Fail = nil
+	if err := runTest(t, props+"role.name.prefix=grp_\n"); err != nil {
+		t.Fatal(err)
+	}
+	if roles := strings.Join(realm.Group("/admins").RealmRoles, ","); roles != "grp_admins" {
+		t.Errorf("expected /admins to be mapped again, got %s", roles)
+	}
+}
+
+func TestReadCheckpoint(t *testing.T) {
+	file := writeTestFile(t, "checkpoint.jsonl", `{"realm":"test","config":"a","groupId":"g1"}
+{"realm":"test","config":"b","groupId":"g2"}
+{"realm":"other","config":"a","groupId":"g3"}
+{"realm":"test","config":"a","groupId":"g4"}
+{"realm":"test","conf`)
+	processed, err := readCheckpoint(file, "test", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processed) != 2 || !processed["g1"] || !processed["g4"] {
+		t.Errorf("expected the groups g1 and g4, got %v", processed)
+	}
+	processed, err = readCheckpoint(t.TempDir()+"/missing.jsonl", "test", "a")
+	if err != nil || len(processed) != 0 {
+		t.Errorf("expected a missing checkpoint to be empty, got %v and %v", processed, err)
+	}
+}
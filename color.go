@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+const ANSI_RESET = "\033[0m"
+const ANSI_BOLD = "\033[1m"
+const ANSI_RED = "\033[31m"
+const ANSI_GREEN = "\033[32m"
+const ANSI_YELLOW = "\033[33m"
+const ANSI_CYAN = "\033[36m"
+
+// noColor disables the colors, like the NO_COLOR environment variable
+var noColor = false
+
+// colorEnabled tells whether the output to f is colored: only on a terminal, unless -no-color or NO_COLOR
+// is set
+func colorEnabled(f *os.File) bool {
+	return !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(f)
+}
+
+// colorize wraps the text with the given color, when the output to f is colored
+func colorize(f *os.File, color string, text string) string {
+	if !colorEnabled(f) {
+		return text
+	}
+	return color + text + ANSI_RESET
+}
+
+// colorWriter colors the lines of the text and diff reports: the additions in green, the removals in red
+// and the missing changes that are not applied in yellow. The text is otherwise unchanged
+type colorWriter struct {
+	w       io.Writer
+	pending []byte
+	// section is the color of the lines of the current section of the text report
+	section string
+}
+
+func (c *colorWriter) Write(p []byte) (int, error) {
+	c.pending = append(c.pending, p...)
+	for {
+		i := bytes.IndexByte(c.pending, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(c.pending[:i])
+		c.pending = c.pending[i+1:]
+		if _, err := io.WriteString(c.w, c.colorLine(line)+"\n"); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *colorWriter) colorLine(line string) string {
+	color := c.section
+	switch {
+	case strings.HasPrefix(line, "+++ "), strings.HasPrefix(line, "--- "):
+		color = ANSI_BOLD
+	case strings.HasPrefix(line, "@@"):
+		color = ANSI_CYAN
+	case strings.HasPrefix(line, "+"):
+		color = ANSI_GREEN
+	case strings.HasPrefix(line, "-"):
+		color = ANSI_RED
+	case strings.HasPrefix(line, "***"):
+		c.section = sectionColor(line)
+		color = ANSI_BOLD
+	}
+	if color == "" || line == "" {
+		return line
+	}
+	return color + line + ANSI_RESET
+}
+
+// sectionColor returns the color of the lines following the header of a section of the text report
+func sectionColor(header string) string {
+	switch {
+	case strings.Contains(header, "removed"), strings.Contains(header, "deleted"):
+		return ANSI_RED
+	case strings.Contains(header, "not be created"), strings.Contains(header, "unexpected"):
+		return ANSI_YELLOW
+	case strings.Contains(header, "Realm"):
+		return ""
+	}
+	return ANSI_GREEN
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// ansiEscape matches the color escape codes
+var ansiEscape = regexp.MustCompile("\033\\[[0-9;]*m")
+
+func TestColorDisabledWithoutTerminal(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+	file, err := os.Create(t.TempDir() + "/report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for _, f := range []*os.File{writer, file} {
+		if colorEnabled(f) {
+			t.Errorf("expected no color on %s", f.Name())
+		}
+		if text := colorize(f, ANSI_GREEN, "created"); text != "created" {
+			t.Errorf("expected no escape code on %s, got %q", f.Name(), text)
+		}
+	}
+}
+
+func TestColorDisabledByFlagOrEnv(t *testing.T) {
+	// Even on a terminal, -no-color and NO_COLOR disable the colors
+	setFlag(t, &noColor, true)
+	if colorEnabled(os.Stdout) {
+		t.Error("expected -no-color to disable the colors")
+	}
+	setFlag(t, &noColor, false)
+	t.Setenv("NO_COLOR", "1")
+	if colorEnabled(os.Stdout) {
+		t.Error("expected NO_COLOR to disable the colors")
+	}
+}
+
+func TestColorWriter(t *testing.T) {
+	text := `*** Realm test ***
+*** The following roles will be created ***
+admins
+*** The following mappings will not be created ***
+/users -> users
+--- a/test
++++ b/test
+@@ groups @@
++/admins admins
+-/old legacy
+`
+	var out bytes.Buffer
+	w := &colorWriter{w: &out}
+	// The text may be written in pieces, not on line boundaries
+	for _, piece := range []string{text[:10], text[10:50], text[50:]} {
+		if _, err := w.Write([]byte(piece)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if plain := ansiEscape.ReplaceAllString(out.String(), ""); plain != text {
+		t.Errorf("expected the same text without the escape codes, got %q", plain)
+	}
+	expected := map[string]string{"admins": ANSI_GREEN, "/users -> users": ANSI_YELLOW, "+/admins admins": ANSI_GREEN,
+		"-/old legacy": ANSI_RED, "@@ groups @@": ANSI_CYAN, "*** Realm test ***": ANSI_BOLD}
+	for line, color := range expected {
+		if !strings.Contains(out.String(), color+line+ANSI_RESET+"\n") {
+			t.Errorf("expected %q in %q", line, color)
+		}
+	}
+}
+
+func TestReportWithoutTerminalHasNoColor(t *testing.T) {
+	s, realm := newPruneServer(t)
+	realm.AddGroup("/users")
+	var out bytes.Buffer
+	setFlag(t, &propsFile, writeTestFile(t, PROPS_FILE_NAME, serverProps(s, "test")+"dry.run.only=true\n"))
+	r := newRunner()
+	r.console = &out
+	r.report = &out
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() == 0 || ansiEscape.MatchString(out.String()) {
+		t.Errorf("expected a plain report, got %q", out.String())
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+	"github.com/magiconair/properties"
+	"gopkg.in/yaml.v3"
+)
+
+// ENV_PREFIX starts the keys of the environment blocks, like env.stage.keycloak.url
+const ENV_PREFIX = "env."
+
+// templateValues are the properties written in the default template
+var templateValues = map[string]string{
+	PROPS_DRYRUN:            "false",
+	PROPS_LOG_LEVEL:         "info",
+	PROPS_URL:               "http://localhost:8080",
+	PROPS_BASE_PATH:         "",
+	PROPS_USER:              "admin",
+	PROPS_PASSWORD:          "password",
+	PROPS_REALM:             "realm",
+	PROPS_ROLE_PREFIX:       "",
+	PROPS_ROLE_SUFFIX:       "",
+	PROPS_TARGET_CLIENT:     "",
+	PROPS_ROLE_NAME_FROM:    mapper.ROLE_NAME_FROM_NAME,
+	PROPS_MANAGED_ATTRIBUTE: mapper.DEFAULT_MANAGED_ATTRIBUTE,
+	PROPS_ROLE_DESCRIPTION:  mapper.DEFAULT_ROLE_DESCRIPTION,
+}
+
+func isYAML(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// loadProps reads the configuration file: a .properties file, or a YAML file whose nested keys are
+// flattened into the same properties, e.g. keycloak: {url: ...} into keycloak.url
+func loadProps(fileName string) (*properties.Properties, error) {
+	if !isYAML(fileName) {
+		return properties.LoadFile(fileName, properties.UTF8)
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	p := properties.NewProperties()
+	if err := flattenYAML(p, "", document); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// flattenYAML sets a property for every leaf of the YAML value, named after its path. Lists of scalars
+// are joined into comma-separated values
+func flattenYAML(p *properties.Properties, key string, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for childKey, child := range v {
+			if key != "" {
+				childKey = key + "." + childKey
+			}
+			if err := flattenYAML(p, childKey, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				return fmt.Errorf("unsupported nested value in list %s", key)
+			}
+			items = append(items, fmt.Sprint(item))
+		}
+		_, _, err := p.Set(key, strings.Join(items, ","))
+		return err
+	case nil:
+		_, _, err := p.Set(key, "")
+		return err
+	default:
+		_, _, err := p.Set(key, fmt.Sprint(v))
+		return err
+	}
+}
+
+// templateProps writes the default template, in YAML when the configuration file is a YAML file
+func templateProps() error {
+	f, err := os.Create(propsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if isYAML(propsFile) {
+		content, err := yaml.Marshal(nestProps(templateValues))
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+	} else if _, err := properties.LoadMap(templateValues).Write(w, properties.UTF8); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// nestProps is the reverse of flattenYAML, e.g. keycloak.url becomes keycloak: {url: ...}
+func nestProps(values map[string]string) map[string]interface{} {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	nested := map[string]interface{}{}
+	for _, key := range keys {
+		node := nested
+		parts := strings.Split(key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node[part].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[part] = child
+			}
+			node = child
+		}
+		node[parts[len(parts)-1]] = values[key]
+	}
+	return nested
+}
+
+// selectEnvironment applies the properties of the named environment block, e.g. env.stage.keycloak.url
+// overrides keycloak.url with -env stage, so that a single file configures all the environments
+func selectEnvironment(p *properties.Properties, name string) error {
+	prefix := ENV_PREFIX + name + "."
+	found := false
+	names := map[string]bool{}
+	for _, key := range p.Keys() {
+		if !strings.HasPrefix(key, ENV_PREFIX) {
+			continue
+		}
+		if envName, _, ok := strings.Cut(strings.TrimPrefix(key, ENV_PREFIX), "."); ok {
+			names[envName] = true
+		}
+		if strings.HasPrefix(key, prefix) {
+			found = true
+			if _, _, err := p.Set(strings.TrimPrefix(key, prefix), p.MustGetString(key)); err != nil {
+				return fmt.Errorf("cannot set %s: %w", key, err)
+			}
+		}
+	}
+	if !found {
+		available := []string{}
+		for envName := range names {
+			available = append(available, envName)
+		}
+		sort.Strings(available)
+		return fmt.Errorf("unknown environment %s in %s, the available environments are %v", name, propsFile, available)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYAMLAndPropertiesConfig(t *testing.T) {
+	props := TEST_PROPS + `role.name.prefix=grp_
+group.include=/apps/*,/admins
+group.exclude=/apps/legacy
+keycloak.realms=dev,staging
+role.attributes.team=platform
+`
+	yaml := `keycloak:
+  url: http://localhost:8080
+  user: admin
+  password: secret
+  realm: test
+  realms: [dev, staging]
+role:
+  name:
+    prefix: grp_
+  attributes:
+    team: platform
+group:
+  include:
+    - /apps/*
+    - /admins
+  exclude: /apps/legacy
+`
+	fromProps, err := loadTestProps(t, "config.properties", props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"config.yaml", "config.yml"} {
+		fromYAML, err := loadTestProps(t, name, yaml)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		fromProps.config.Logger, fromYAML.config.Logger = nil, nil
+		if !reflect.DeepEqual(fromProps.config, fromYAML.config) {
+			t.Errorf("%s: expected config %+v, got %+v", name, fromProps.config, fromYAML.config)
+		}
+		if !reflect.DeepEqual(fromProps.realms, fromYAML.realms) {
+			t.Errorf("%s: expected realms %v, got %v", name, fromProps.realms, fromYAML.realms)
+		}
+	}
+	if strings.Join(fromProps.config.GroupInclude, ",") != "/apps/*,/admins" || fromProps.config.RolePrefix != "grp_" {
+		t.Errorf("unexpected config %+v", fromProps.config)
+	}
+}
+
+func TestInvalidYAMLConfig(t *testing.T) {
+	tests := map[string]string{
+		"keycloak: [unclosed\n":                     "",
+		"group:\n  include:\n    - path: /apps\n":   "unsupported nested value in list group.include",
+		"keycloak:\n  url: http://localhost:8080\n": "keycloak.user",
+	}
+	for content, expected := range tests {
+		_, err := loadTestProps(t, "config.yaml", content)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected error %q, got %v", content, expected, err)
+		}
+	}
+}
+
+func TestSelectEnvironment(t *testing.T) {
+	props := TEST_PROPS + `env.dev.keycloak.url=https://keycloak.dev.example.com
+env.dev.keycloak.realm=dev
+env.prod.keycloak.url=https://keycloak.example.com
+env.prod.keycloak.user=ops
+env.prod.keycloak.realm=prod
+`
+	tests := []struct {
+		env    string
+		server string
+		user   string
+		realm  string
+	}{
+		{"", "http://localhost:8080", "admin", "test"},
+		{"dev", "https://keycloak.dev.example.com", "admin", "dev"},
+		{"prod", "https://keycloak.example.com", "ops", "prod"},
+	}
+	for _, test := range tests {
+		t.Run("env "+test.env, func(t *testing.T) {
+			setFlag(t, &environment, test.env)
+			r, err := loadTestProps(t, PROPS_FILE_NAME, props)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.config.Server != test.server || r.config.User != test.user || strings.Join(r.realms, ",") != test.realm {
+				t.Errorf("unexpected config %+v and realms %v", r.config, r.realms)
+			}
+		})
+	}
+
+	setFlag(t, &environment, "stage")
+	_, err := loadTestProps(t, PROPS_FILE_NAME, props)
+	if err == nil || !strings.Contains(err.Error(), "unknown environment stage") || !strings.Contains(err.Error(), "[dev prod]") {
+		t.Errorf("expected an unknown environment error listing dev and prod, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+// setStdin answers the prompts of the test with the input, as if typed on a terminal
+func setStdin(t *testing.T, input string) {
+	setFlag(t, &stdin, bufio.NewReader(strings.NewReader(input)))
+	setFlag(t, &stdinIsTerminal, func() bool { return true })
+}
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		input     string
+		confirmed bool
+	}{
+		{"yes", CONFIRM_SIMPLE, "Y\n", true},
+		{"windows line ending", CONFIRM_SIMPLE, "Y\r\n", true},
+		{"lower case", CONFIRM_SIMPLE, "yes\n", true},
+		{"no", CONFIRM_SIMPLE, "n\n", false},
+		{"empty line", CONFIRM_SIMPLE, "\n", false},
+		{"closed stdin", CONFIRM_SIMPLE, "", false},
+		{"yes without line ending", CONFIRM_SIMPLE, "Y", true},
+		{"realm name", CONFIRM_TYPE_REALM, "test\r\n", true},
+		{"Y instead of realm name", CONFIRM_TYPE_REALM, "Y\n", false},
+		{"other realm name", CONFIRM_TYPE_REALM, "prod\n", false},
+		{"realm name with other case", CONFIRM_TYPE_REALM, "Test\n", false},
+		{"realm name prefix", CONFIRM_TYPE_REALM, "tes\n", false},
+		{"realm name closed stdin", CONFIRM_TYPE_REALM, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setStdin(t, test.input)
+			r := newRunner()
+			r.console = io.Discard
+			r.confirmMode = test.mode
+			confirmed, err := r.confirm("Apply?", "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if confirmed != test.confirmed {
+				t.Errorf("input %q: expected confirmed %v, got %v", test.input, test.confirmed, confirmed)
+			}
+		})
+	}
+}
+
+func TestConfirmWithoutTerminal(t *testing.T) {
+	setFlag(t, &stdinIsTerminal, func() bool { return false })
+	r := newRunner()
+	if _, err := r.confirm("Apply?", "test"); err == nil || !strings.Contains(err.Error(), "-"+FLAG_YES) {
+		t.Errorf("expected an error suggesting -%s, got %v", FLAG_YES, err)
+	}
+	r.autoConfirm = true
+	if confirmed, err := r.confirm("Apply?", "test"); err != nil || !confirmed {
+		t.Errorf("expected -%s to confirm, got %v and %v", FLAG_YES, confirmed, err)
+	}
+}
+
+func TestConfirmModeProperty(t *testing.T) {
+	tests := []struct {
+		props    string
+		expected string
+		err      string
+	}{
+		{"", CONFIRM_SIMPLE, ""},
+		{"confirm.mode=simple\n", CONFIRM_SIMPLE, ""},
+		{"confirm.mode=type-realm\n", CONFIRM_TYPE_REALM, ""},
+		{"confirm.mode=always\n", "", "invalid confirm.mode always: must be simple or type-realm"},
+	}
+	for _, test := range tests {
+		r, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS+test.props)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error %q, got %v", test.props, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.props, err)
+		} else if r.confirmMode != test.expected {
+			t.Errorf("%q: expected confirm mode %s, got %s", test.props, test.expected, r.confirmMode)
+		}
+	}
+}
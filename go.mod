@@ -0,0 +1,12 @@
+module github.com/dmartinol/keycloak-group2role
+
+go 1.26.0
+
+require (
+	github.com/magiconair/properties v1.18.12
+	golang.org/x/oauth2 v0.37.0
+	golang.org/x/term v0.46.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.48.0 // indirect
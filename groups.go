@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/magiconair/properties"
+)
+
+// globList reads a comma-separated list of glob patterns, rejecting the malformed ones
+func globList(p *properties.Properties, key string) ([]string, error) {
+	patterns := splitList(p.GetString(key, ""))
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s in %s: %w", pattern, key, err)
+		}
+	}
+	return patterns, nil
+}
+
+// readGroupsFile reads the group paths of the -groups-file file, one per line. Empty lines and lines
+// starting with # are ignored
+func readGroupsFile(fileName string) ([]string, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read groups file %s: %w", fileName, err)
+	}
+	paths := []string{}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "/") {
+			return nil, fmt.Errorf("invalid group path %s at line %d of %s: must start with /", line, i+1, fileName)
+		}
+		paths = append(paths, line)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no group path in groups file %s", fileName)
+	}
+	return paths, nil
+}
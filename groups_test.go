@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroupFilterProperties(t *testing.T) {
+	r, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS+"group.include=/eng/*, /ops\ngroup.exclude=/eng/legacy\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(r.config.GroupInclude, "|") != "/eng/*|/ops" || strings.Join(r.config.GroupExclude, "|") != "/eng/legacy" {
+		t.Errorf("unexpected filters %v and %v", r.config.GroupInclude, r.config.GroupExclude)
+	}
+	if _, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS+"group.exclude=/eng/[\n"); err == nil ||
+		!strings.Contains(err.Error(), "invalid pattern /eng/[ in group.exclude") {
+		t.Errorf("expected the malformed pattern to be rejected, got %v", err)
+	}
+}
+
+func TestReadGroupsFile(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected string
+		err      string
+	}{
+		{"/apps/web\n# comment\n\n  /ops  \r\n", "/apps/web|/ops", ""},
+		{"/apps/web\nops\n", "", "invalid group path ops at line 2"},
+		{"# only comments\n\n", "", "no group path in groups file"},
+	}
+	for _, test := range tests {
+		paths, err := readGroupsFile(writeTestFile(t, "groups.txt", test.content))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error %q, got %v", test.content, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.content, err)
+		} else if strings.Join(paths, "|") != test.expected {
+			t.Errorf("%q: expected %s, got %v", test.content, test.expected, paths)
+		}
+	}
+	if _, err := readGroupsFile(t.TempDir() + "/missing.txt"); err == nil || !strings.Contains(err.Error(), "cannot read groups file") {
+		t.Errorf("expected the missing file to be reported, got %v", err)
+	}
+}
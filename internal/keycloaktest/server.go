@@ -0,0 +1,894 @@
+// Package keycloaktest provides an in-memory Keycloak for the tests: it serves the token endpoint and the
+// admin API calls of the mapper, over a real HTTP server
+package keycloaktest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zemirco/keycloak"
+)
+
+// The credentials accepted by a new server
+const ADMIN_USER = "admin"
+const ADMIN_PASSWORD = "admin-password"
+const SERVICE_CLIENT = "group2role"
+const SERVICE_SECRET = "client-secret"
+const PUBLIC_CLIENT = "admin-cli"
+
+// Server is an in-memory Keycloak with the master realm. The requests are served one at a time, the realms
+// can be set up and inspected between them
+type Server struct {
+	*httptest.Server
+	// BasePath is the context path of the server, like /auth for the legacy distributions
+	BasePath string
+	// EmbedSubGroups embeds the sub-groups in the groups, like Keycloak 22 and older
+	EmbedSubGroups bool
+	// ChildrenStatus, when set, answers the sub-groups API, like 405 for Keycloak 22 and older
+	ChildrenStatus int
+	// Users are the passwords of the users by name, Clients the secrets of the service accounts by client ID
+	Users   map[string]string
+	Clients map[string]string
+	// PublicClients are the client IDs accepted by the password grant
+	PublicClients map[string]bool
+	// Fail returns the status answering the request instead of the server, 0 to serve it. The path is
+	// relative to the base path, like admin/realms/test/roles
+	Fail func(method string, path string) int
+
+	lock     sync.Mutex
+	realms   map[string]*Realm
+	requests []string
+	bodies   []string
+	tokens   map[string]bool
+	issued   int
+	nextID   int
+}
+
+// Realm is a realm of the server
+type Realm struct {
+	Name string
+	ID   string
+	// DefaultRoles are the composites of the default role of the realm, granted to all the users
+	DefaultRoles []string
+	// DefaultGroups are the paths of the default groups
+	DefaultGroups []string
+	// Permissions are the admin roles of the logged in user in the realm, all of them by default
+	Permissions []string
+
+	server        *Server
+	groups        []*Group
+	roles         *roleContainer
+	clients       []*Client
+	organizations []*Organization
+}
+
+// Group is a group of a realm, with the roles mapped to it
+type Group struct {
+	ID         string
+	Name       string
+	Path       string
+	Attributes map[string][]string
+	RealmRoles []string
+	// ClientRoles are the roles of the clients mapped to the group, by client ID
+	ClientRoles map[string][]string
+	// Members are the IDs of the users of the group
+	Members  []string
+	Children []*Group
+}
+
+// Client is a client of a realm, with its roles
+type Client struct {
+	ID       string
+	ClientID string
+	server   *Server
+	roles    *roleContainer
+}
+
+// Organization is an organization of a realm
+type Organization struct {
+	ID      string
+	Name    string
+	Alias   string
+	Members []string
+	// Groups are the paths of the groups of the organization
+	Groups []string
+}
+
+// roleContainer holds the roles of a realm or of a client, clientID is empty for the realm
+type roleContainer struct {
+	id         string
+	clientID   string
+	roles      map[string]*keycloak.Role
+	composites map[string][]string
+}
+
+// groupRepresentation is the group returned by the API. The roles are null in the brief representation,
+// an empty ID or name is omitted like in a malformed group
+type groupRepresentation struct {
+	ID          string                 `json:"id,omitempty"`
+	Name        string                 `json:"name,omitempty"`
+	Path        string                 `json:"path"`
+	Attributes  map[string][]string    `json:"attributes,omitempty"`
+	RealmRoles  []string               `json:"realmRoles"`
+	ClientRoles map[string][]string    `json:"clientRoles"`
+	SubGroups   []*groupRepresentation `json:"subGroups,omitempty"`
+}
+
+// NewServer starts a server, closed at the end of the test
+func NewServer(t *testing.T) *Server {
+	s := &Server{Users: map[string]string{ADMIN_USER: ADMIN_PASSWORD}, Clients: map[string]string{SERVICE_CLIENT: SERVICE_SECRET},
+		PublicClients: map[string]bool{PUBLIC_CLIENT: true}, realms: map[string]*Realm{}, tokens: map[string]bool{}}
+	s.addRealm("master")
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// BaseURL is the URL of the server including its base path
+func (s *Server) BaseURL() string {
+	return s.URL + s.BasePath
+}
+
+// AddRealm creates an empty realm
+func (s *Server) AddRealm(name string) *Realm {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.addRealm(name)
+}
+
+func (s *Server) addRealm(name string) *Realm {
+	r := &Realm{Name: name, ID: s.newID("realm"), server: s,
+		Permissions: []string{"manage-realm", "manage-clients", "manage-users"}}
+	r.roles = &roleContainer{id: r.ID, roles: map[string]*keycloak.Role{}, composites: map[string][]string{}}
+	s.realms[name] = r
+	return r
+}
+
+// Realm returns the realm with the given name, nil if it does not exist
+func (s *Server) Realm(name string) *Realm {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.realms[name]
+}
+
+// RealmNames returns the names of the realms, sorted
+func (s *Server) RealmNames() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := []string{}
+	for name := range s.realms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RevokeTokens rejects the tokens issued so far, like expired sessions
+func (s *Server) RevokeTokens() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokens = map[string]bool{}
+}
+
+// TokensIssued counts the successful logins
+func (s *Server) TokensIssued() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.issued
+}
+
+// Requests returns the requests served so far, like "GET admin/realms/test/groups", without the query
+func (s *Server) Requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// Count counts the requests with the given method whose path matches the pattern of path.Match. A pattern not
+// starting with admin/ or realms/ is relative to the realm, like groups/*/role-mappings/realm for realm test
+func (s *Server) Count(method string, realm string, pattern string) int {
+	if !strings.HasPrefix(pattern, "admin/") && !strings.HasPrefix(pattern, "realms/") {
+		pattern = "admin/realms/" + realm + "/" + pattern
+	}
+	count := 0
+	for _, request := range s.Requests() {
+		requestMethod, requestPath, _ := strings.Cut(request, " ")
+		if matched, _ := path.Match(pattern, requestPath); matched && requestMethod == method {
+			count++
+		}
+	}
+	return count
+}
+
+// Bodies returns the bodies of the requests counted by Count, in the order they were served
+func (s *Server) Bodies(method string, realm string, pattern string) []string {
+	if !strings.HasPrefix(pattern, "admin/") && !strings.HasPrefix(pattern, "realms/") {
+		pattern = "admin/realms/" + realm + "/" + pattern
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	bodies := []string{}
+	for i, request := range s.requests {
+		requestMethod, requestPath, _ := strings.Cut(request, " ")
+		if matched, _ := path.Match(pattern, requestPath); matched && requestMethod == method {
+			bodies = append(bodies, s.bodies[i])
+		}
+	}
+	return bodies
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-%d", prefix, s.nextID)
+}
+
+// AddGroup creates the group with the given path, like /parent/child, and its missing parents. The group
+// is mapped to the given realm roles
+func (r *Realm) AddGroup(groupPath string, realmRoles ...string) *Group {
+	r.server.lock.Lock()
+	defer r.server.lock.Unlock()
+	var group *Group
+	parentPath := ""
+	siblings := &r.groups
+	for _, name := range strings.Split(strings.Trim(groupPath, "/"), "/") {
+		group = nil
+		for _, g := range *siblings {
+			if g.Name == name {
+				group = g
+			}
+		}
+		if group == nil {
+			group = r.newGroup(name, parentPath, siblings)
+		}
+		parentPath = group.Path
+		siblings = &group.Children
+	}
+	group.RealmRoles = append(group.RealmRoles, realmRoles...)
+	return group
+}
+
+// newGroup appends a new group to the siblings, whose parent has the given path
+func (r *Realm) newGroup(name string, parentPath string, siblings *[]*Group) *Group {
+	group := &Group{ID: r.server.newID("group"), Name: name, Path: parentPath + "/" + name, RealmRoles: []string{},
+		ClientRoles: map[string][]string{}}
+	*siblings = append(*siblings, group)
+	return group
+}
+
+// Group returns the group with the given path, nil if it does not exist
+func (r *Realm) Group(groupPath string) *Group {
+	r.server.lock.Lock()
+	defer r.server.lock.Unlock()
+	return r.groupByPath(groupPath)
+}
+
+func (r *Realm) groupByPath(groupPath string) *Group {
+	var found *Group
+	r.walkGroups(func(g *Group) {
+		if g.Path == groupPath {
+			found = g
+		}
+	})
+	return found
+}
+
+func (r *Realm) groupByID(id string) *Group {
+	var found *Group
+	r.walkGroups(func(g *Group) {
+		if g.ID == id {
+			found = g
+		}
+	})
+	return found
+}
+
+func (r *Realm) walkGroups(visit func(g *Group)) {
+	var walk func(groups []*Group)
+	walk = func(groups []*Group) {
+		for _, g := range groups {
+			visit(g)
+			walk(g.Children)
+		}
+	}
+	walk(r.groups)
+}
+
+// AddRole creates a realm role
+func (r *Realm) AddRole(name string) *keycloak.Role {
+	r.server.lock.Lock()
+	defer r.server.lock.Unlock()
+	return r.roles.add(r.server, &keycloak.Role{Name: &name})
+}
+
+// Role returns the realm role with the given name, nil if it does not exist
+func (r *Realm) Role(name string) *keycloak.Role {
+	r.server.lock.Lock()
+	defer r.server.lock.Unlock()
+	return r.roles.roles[name]
+}
+
+// RoleNames returns the names of the realm roles, sorted
+func (r *Realm) RoleNames() []string {
+	r.server.lock.Lock()
+	defer r.server.lock.Unlock()
+	return r.roles.names()
+}
+
+// Composites returns the names of the composites of the realm role
+func (r *Realm) Composites(name string) []string {
+	r.server.lock.Lock()
+	defer r.server.lock.Unlock()
+	return append([]string{}, r.roles.composites[name]...)
+}
+
+// AddClient creates a client
+func (r *Realm) AddClient(clientID string) *Client {
+	r.server.lock.Lock()
+	defer r.server.lock.Unlock()
+	c := &Client{ID: r.server.newID("client"), ClientID: clientID, server: r.server}
+	c.roles = &roleContainer{id: c.ID, clientID: clientID, roles: map[string]*keycloak.Role{}, composites: map[string][]string{}}
+	r.clients = append(r.clients, c)
+	return c
+}
+
+// AddRole creates a role of the client
+func (c *Client) AddRole(name string) *keycloak.Role {
+	c.server.lock.Lock()
+	defer c.server.lock.Unlock()
+	return c.roles.add(c.server, &keycloak.Role{Name: &name})
+}
+
+// Role returns the role of the client with the given name, nil if it does not exist
+func (c *Client) Role(name string) *keycloak.Role {
+	c.server.lock.Lock()
+	defer c.server.lock.Unlock()
+	return c.roles.roles[name]
+}
+
+// AddOrganization creates an organization with the given members
+func (r *Realm) AddOrganization(name string, alias string, members ...string) *Organization {
+	r.server.lock.Lock()
+	defer r.server.lock.Unlock()
+	o := &Organization{ID: r.server.newID("organization"), Name: name, Alias: alias, Members: members}
+	r.organizations = append(r.organizations, o)
+	return o
+}
+
+// add stores the role with a new ID
+func (c *roleContainer) add(s *Server, role *keycloak.Role) *keycloak.Role {
+	id := s.newID("role")
+	role.ID = &id
+	role.ContainerID = &c.id
+	clientRole := c.clientID != ""
+	role.ClientRole = &clientRole
+	if role.Attributes == nil {
+		role.Attributes = map[string][]string{}
+	}
+	c.roles[*role.Name] = role
+	return role
+}
+
+// mapped returns the roles of the container mapped to the group
+func (c *roleContainer) mapped(g *Group) *[]string {
+	if c.clientID == "" {
+		return &g.RealmRoles
+	}
+	roles := g.ClientRoles[c.clientID]
+	return &roles
+}
+
+func (c *roleContainer) names() []string {
+	names := []string{}
+	for name := range c.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *roleContainer) list() []*keycloak.Role {
+	roles := []*keycloak.Role{}
+	for _, name := range c.names() {
+		roles = append(roles, c.roles[name])
+	}
+	return roles
+}
+
+// response is the status and the JSON body of an answer
+type response struct {
+	status int
+	body   interface{}
+}
+
+func ok(body interface{}) response {
+	return response{status: http.StatusOK, body: body}
+}
+
+func notFound(what string) response {
+	return response{status: http.StatusNotFound, body: map[string]string{"error": what + " not found"}}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	relative := strings.TrimPrefix(req.URL.Path, s.BasePath+"/")
+	s.requests = append(s.requests, req.Method+" "+relative)
+	body, _ := io.ReadAll(req.Body)
+	s.bodies = append(s.bodies, string(body))
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	res := s.route(req, relative)
+	if res.status != http.StatusOK && res.status != http.StatusCreated && res.status != http.StatusNoContent && res.body == nil {
+		res.body = map[string]string{"errorMessage": http.StatusText(res.status)}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(res.status)
+	if res.body != nil {
+		json.NewEncoder(w).Encode(res.body)
+	}
+}
+
+func (s *Server) route(req *http.Request, relative string) response {
+	if !strings.HasPrefix(req.URL.Path, s.BasePath+"/") {
+		return response{status: http.StatusNotFound}
+	}
+	if s.Fail != nil {
+		if status := s.Fail(req.Method, relative); status != 0 {
+			return response{status: status, body: map[string]string{"errorMessage": "injected failure"}}
+		}
+	}
+	segments := strings.Split(strings.Trim(relative, "/"), "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segments[i] = unescaped
+		}
+	}
+	if len(segments) == 5 && segments[0] == "realms" && segments[2] == "protocol" && req.Method == http.MethodPost {
+		return s.token(req, segments[1])
+	}
+	if len(segments) < 2 || segments[0] != "admin" {
+		return response{status: http.StatusNotFound}
+	}
+	if !s.tokens[strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")] {
+		return response{status: http.StatusUnauthorized, body: map[string]string{"error": "HTTP 401 Unauthorized"}}
+	}
+	if len(segments) == 4 && segments[2] == "console" && segments[3] == "whoami" {
+		access := map[string][]string{}
+		for name, realm := range s.realms {
+			access[name] = realm.Permissions
+		}
+		return ok(map[string]interface{}{"userId": "user-admin", "realm_access": access})
+	}
+	if segments[1] != "realms" {
+		return response{status: http.StatusNotFound}
+	}
+	if len(segments) == 2 {
+		switch req.Method {
+		case http.MethodGet:
+			realms := []*keycloak.Realm{}
+			for _, name := range sortedKeys(s.realms) {
+				realms = append(realms, s.realms[name].realmRepresentation())
+			}
+			return ok(realms)
+		case http.MethodPost:
+			var created keycloak.Realm
+			if err := json.NewDecoder(req.Body).Decode(&created); err != nil || created.Realm == nil {
+				return response{status: http.StatusBadRequest}
+			}
+			if s.realms[*created.Realm] != nil {
+				return response{status: http.StatusConflict}
+			}
+			s.addRealm(*created.Realm)
+			return response{status: http.StatusCreated}
+		}
+		return response{status: http.StatusMethodNotAllowed}
+	}
+	realm := s.realms[segments[2]]
+	if realm == nil {
+		return notFound("Realm")
+	}
+	if len(segments) == 3 {
+		switch req.Method {
+		case http.MethodGet:
+			defaultRoleID := realm.ID + "-default-roles"
+			defaultRoleName := "default-roles-" + realm.Name
+			return ok(map[string]interface{}{"id": realm.ID, "realm": realm.Name, "enabled": true,
+				"defaultRole": &keycloak.Role{ID: &defaultRoleID, Name: &defaultRoleName}})
+		case http.MethodDelete:
+			delete(s.realms, realm.Name)
+			return response{status: http.StatusNoContent}
+		}
+		return response{status: http.StatusMethodNotAllowed}
+	}
+	return realm.route(req, segments[3:])
+}
+
+// token answers the password and client_credentials grants of the users and the service accounts
+func (s *Server) token(req *http.Request, realm string) response {
+	if s.realms[realm] == nil {
+		return response{status: http.StatusNotFound, body: map[string]string{"error": "Realm does not exist"}}
+	}
+	req.ParseForm()
+	switch req.PostForm.Get("grant_type") {
+	case "password":
+		clientID, _, found := req.BasicAuth()
+		if !found {
+			clientID = req.PostForm.Get("client_id")
+		}
+		if !s.PublicClients[clientID] {
+			return response{status: http.StatusUnauthorized, body: map[string]string{"error": "unauthorized_client",
+				"error_description": "Invalid client or Invalid client credentials"}}
+		}
+		password, found := s.Users[req.PostForm.Get("username")]
+		if !found || password != req.PostForm.Get("password") {
+			return response{status: http.StatusUnauthorized, body: map[string]string{"error": "invalid_grant",
+				"error_description": "Invalid user credentials"}}
+		}
+	case "client_credentials":
+		clientID, secret, found := req.BasicAuth()
+		if !found {
+			clientID, secret = req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+		}
+		if expected, found := s.Clients[clientID]; !found || expected != secret {
+			return response{status: http.StatusUnauthorized, body: map[string]string{"error": "unauthorized_client",
+				"error_description": "Invalid client or Invalid client credentials"}}
+		}
+	default:
+		return response{status: http.StatusBadRequest, body: map[string]string{"error": "unsupported_grant_type"}}
+	}
+	s.issued++
+	token := fmt.Sprintf("token-%d", s.issued)
+	s.tokens[token] = true
+	return ok(map[string]interface{}{"access_token": token, "token_type": "Bearer", "expires_in": 300})
+}
+
+func (r *Realm) realmRepresentation() *keycloak.Realm {
+	enabled := true
+	return &keycloak.Realm{ID: &r.ID, Realm: &r.Name, Enabled: &enabled}
+}
+
+// route answers the calls on the given path of the realm, like groups/<id>/children
+func (r *Realm) route(req *http.Request, segments []string) response {
+	query := req.URL.Query()
+	switch segments[0] {
+	case "groups":
+		if len(segments) == 1 {
+			if req.Method == http.MethodPost {
+				return r.createGroup(req, "", &r.groups)
+			}
+			return ok(page(r.representations(r.groups, query.Get("briefRepresentation") == "false"), query))
+		}
+		group := r.groupByID(segments[1])
+		if group == nil {
+			return notFound("Group")
+		}
+		return r.routeGroup(req, group, segments[2:])
+	case "group-by-path":
+		if group := r.groupByPath("/" + strings.Join(segments[1:], "/")); group != nil {
+			return ok(r.representation(group, true))
+		}
+		return notFound("Group path")
+	case "default-groups":
+		groups := []*groupRepresentation{}
+		for _, groupPath := range r.DefaultGroups {
+			if group := r.groupByPath(groupPath); group != nil {
+				groups = append(groups, r.representation(group, false))
+			}
+		}
+		return ok(groups)
+	case "roles":
+		return r.routeRoles(req, r.roles, segments[1:])
+	case "roles-by-id":
+		if len(segments) == 3 && segments[1] == r.ID+"-default-roles" && segments[2] == "composites" && req.Method == http.MethodPost {
+			var roles []*keycloak.Role
+			json.NewDecoder(req.Body).Decode(&roles)
+			for _, role := range roles {
+				r.DefaultRoles = append(r.DefaultRoles, *role.Name)
+			}
+			return response{status: http.StatusNoContent}
+		}
+	case "clients":
+		if len(segments) == 1 {
+			clients := []map[string]string{}
+			for _, c := range r.clients {
+				if query.Get("clientId") == "" || c.ClientID == query.Get("clientId") {
+					clients = append(clients, map[string]string{"id": c.ID, "clientId": c.ClientID})
+				}
+			}
+			return ok(page(clients, query))
+		}
+		for _, c := range r.clients {
+			if c.ID == segments[1] && len(segments) >= 3 && segments[2] == "roles" {
+				return r.routeRoles(req, c.roles, segments[3:])
+			}
+		}
+		return notFound("Client")
+	case "users":
+		if len(segments) == 3 && segments[2] == "groups" {
+			groups := []*groupRepresentation{}
+			r.walkGroups(func(g *Group) {
+				for _, member := range g.Members {
+					if member == segments[1] {
+						groups = append(groups, r.representation(g, false))
+					}
+				}
+			})
+			return ok(page(groups, query))
+		}
+	case "organizations":
+		return r.routeOrganizations(segments[1:], query)
+	}
+	return notFound("Resource")
+}
+
+func (r *Realm) routeGroup(req *http.Request, group *Group, segments []string) response {
+	query := req.URL.Query()
+	if len(segments) == 0 {
+		if req.Method == http.MethodDelete {
+			r.walkGroups(func(g *Group) {
+				g.Children = removeGroup(g.Children, group)
+			})
+			r.groups = removeGroup(r.groups, group)
+			return response{status: http.StatusNoContent}
+		}
+		return ok(r.representation(group, true))
+	}
+	switch segments[0] {
+	case "children":
+		if r.server.ChildrenStatus != 0 {
+			return response{status: r.server.ChildrenStatus}
+		}
+		if req.Method == http.MethodPost {
+			return r.createGroup(req, group.Path, &group.Children)
+		}
+		return ok(page(r.representations(group.Children, query.Get("briefRepresentation") == "false"), query))
+	case "members":
+		members := []map[string]string{}
+		for _, member := range group.Members {
+			members = append(members, map[string]string{"id": member, "username": member})
+		}
+		return ok(page(members, query))
+	case "role-mappings":
+		if len(segments) == 2 && segments[1] == "realm" {
+			return r.routeMappings(req, r.roles, &group.RealmRoles)
+		}
+		if len(segments) == 3 && segments[1] == "clients" {
+			for _, c := range r.clients {
+				if c.ID == segments[2] {
+					mapped := group.ClientRoles[c.ClientID]
+					res := r.routeMappings(req, c.roles, &mapped)
+					if len(mapped) > 0 {
+						group.ClientRoles[c.ClientID] = mapped
+					} else {
+						delete(group.ClientRoles, c.ClientID)
+					}
+					return res
+				}
+			}
+		}
+	}
+	return notFound("Resource")
+}
+
+// routeMappings lists, adds or removes the roles of the container mapped to a group
+func (r *Realm) routeMappings(req *http.Request, container *roleContainer, mapped *[]string) response {
+	if req.Method == http.MethodGet {
+		roles := []*keycloak.Role{}
+		for _, name := range *mapped {
+			if role := container.roles[name]; role != nil {
+				roles = append(roles, role)
+			}
+		}
+		return ok(roles)
+	}
+	var roles []*keycloak.Role
+	if err := json.NewDecoder(req.Body).Decode(&roles); err != nil {
+		return response{status: http.StatusBadRequest}
+	}
+	for _, role := range roles {
+		if role.Name == nil || container.roles[*role.Name] == nil {
+			return notFound("Role")
+		}
+	}
+	for _, role := range roles {
+		kept := []string{}
+		for _, name := range *mapped {
+			if name != *role.Name {
+				kept = append(kept, name)
+			}
+		}
+		if req.Method == http.MethodPost {
+			kept = append(kept, *role.Name)
+		}
+		*mapped = kept
+	}
+	return response{status: http.StatusNoContent}
+}
+
+// routeRoles answers the calls on the roles of the realm or of a client
+func (r *Realm) routeRoles(req *http.Request, container *roleContainer, segments []string) response {
+	query := req.URL.Query()
+	if len(segments) == 0 {
+		if req.Method == http.MethodPost {
+			role := &keycloak.Role{}
+			if err := json.NewDecoder(req.Body).Decode(role); err != nil || role.Name == nil {
+				return response{status: http.StatusBadRequest}
+			}
+			if container.roles[*role.Name] != nil {
+				return response{status: http.StatusConflict, body: map[string]string{"errorMessage": "Role with name " + *role.Name + " already exists"}}
+			}
+			container.add(r.server, role)
+			return response{status: http.StatusCreated}
+		}
+		return ok(page(container.list(), query))
+	}
+	role := container.roles[segments[0]]
+	if role == nil {
+		return notFound("Role")
+	}
+	if len(segments) == 1 {
+		if req.Method == http.MethodDelete {
+			delete(container.roles, *role.Name)
+			r.walkGroups(func(g *Group) {
+				if container.clientID == "" {
+					g.RealmRoles = without(g.RealmRoles, *role.Name)
+				} else if g.ClientRoles[container.clientID] != nil {
+					g.ClientRoles[container.clientID] = without(g.ClientRoles[container.clientID], *role.Name)
+				}
+			})
+			return response{status: http.StatusNoContent}
+		}
+		return ok(role)
+	}
+	switch segments[1] {
+	case "composites":
+		if req.Method == http.MethodPost {
+			var roles []*keycloak.Role
+			json.NewDecoder(req.Body).Decode(&roles)
+			for _, composite := range roles {
+				container.composites[*role.Name] = append(container.composites[*role.Name], *composite.Name)
+			}
+			return response{status: http.StatusNoContent}
+		}
+		composites := []*keycloak.Role{}
+		for _, name := range container.composites[*role.Name] {
+			if composite := container.roles[name]; composite != nil {
+				composites = append(composites, composite)
+			}
+		}
+		return ok(composites)
+	case "groups":
+		groups := []*groupRepresentation{}
+		r.walkGroups(func(g *Group) {
+			for _, name := range *container.mapped(g) {
+				if name == *role.Name {
+					groups = append(groups, r.representation(g, false))
+				}
+			}
+		})
+		return ok(page(groups, query))
+	}
+	return notFound("Resource")
+}
+
+func (r *Realm) routeOrganizations(segments []string, query url.Values) response {
+	if len(segments) == 0 {
+		found := []map[string]string{}
+		for _, o := range r.organizations {
+			search := query.Get("search")
+			if search == "" || o.Name == search || o.Alias == search {
+				found = append(found, map[string]string{"id": o.ID, "name": o.Name, "alias": o.Alias})
+			}
+		}
+		return ok(found)
+	}
+	for _, o := range r.organizations {
+		if o.ID == segments[0] && len(segments) == 2 && segments[1] == "members" {
+			members := []map[string]string{}
+			for _, member := range o.Members {
+				members = append(members, map[string]string{"id": member})
+			}
+			return ok(page(members, query))
+		}
+		if o.ID == segments[0] && len(segments) == 2 && segments[1] == "groups" {
+			groups := []*groupRepresentation{}
+			for _, groupPath := range o.Groups {
+				if g := r.groupByPath(groupPath); g != nil {
+					groups = append(groups, r.representation(g, false))
+				}
+			}
+			return ok(page(groups, query))
+		}
+	}
+	return notFound("Organization")
+}
+
+func (r *Realm) createGroup(req *http.Request, parentPath string, siblings *[]*Group) response {
+	var created keycloak.Group
+	if err := json.NewDecoder(req.Body).Decode(&created); err != nil || created.Name == nil {
+		return response{status: http.StatusBadRequest}
+	}
+	for _, g := range *siblings {
+		if g.Name == *created.Name {
+			return response{status: http.StatusConflict}
+		}
+	}
+	r.newGroup(*created.Name, parentPath, siblings)
+	return response{status: http.StatusCreated}
+}
+
+// representation returns the group as returned by the API, with its roles unless brief
+func (r *Realm) representation(g *Group, full bool) *groupRepresentation {
+	representation := &groupRepresentation{ID: g.ID, Name: g.Name, Path: g.Path, Attributes: g.Attributes}
+	if full {
+		representation.RealmRoles = append([]string{}, g.RealmRoles...)
+		representation.ClientRoles = map[string][]string{}
+		for clientID, roles := range g.ClientRoles {
+			representation.ClientRoles[clientID] = append([]string{}, roles...)
+		}
+	}
+	if r.server.EmbedSubGroups {
+		representation.SubGroups = r.representations(g.Children, full)
+	}
+	return representation
+}
+
+func (r *Realm) representations(groups []*Group, full bool) []*groupRepresentation {
+	representations := []*groupRepresentation{}
+	for _, g := range groups {
+		representations = append(representations, r.representation(g, full))
+	}
+	return representations
+}
+
+func removeGroup(groups []*Group, removed *Group) []*Group {
+	kept := []*Group{}
+	for _, g := range groups {
+		if g != removed {
+			kept = append(kept, g)
+		}
+	}
+	return kept
+}
+
+func without(names []string, removed string) []string {
+	kept := []string{}
+	for _, name := range names {
+		if name != removed {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+func sortedKeys[T any](items map[string]T) []string {
+	keys := []string{}
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// page returns the items of the page selected by the first and max query parameters, all the items by default
+func page[T any](items []T, query url.Values) []T {
+	first, _ := strconv.Atoi(query.Get("first"))
+	if first < 0 || first >= len(items) {
+		return []T{}
+	}
+	items = items[first:]
+	if max, err := strconv.Atoi(query.Get("max")); err == nil && max >= 0 && max < len(items) {
+		items = items[:max]
+	}
+	return items
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// RealmGroups are the groups of a realm with their current roles, listed by -list
+type RealmGroups struct {
+	Realm  string              `json:"realm"`
+	Groups []mapper.GroupRoles `json:"groups"`
+}
+
+// listGroupRoles prints the roles currently mapped to the groups of the realms, without planning any change
+func (r *runner) listGroupRoles(realmNames []string) error {
+	lists := []RealmGroups{}
+	for _, realm := range realmNames {
+		if err := r.checkMasterRealm(realm); err != nil {
+			return err
+		}
+		realmConfig := r.config
+		realmConfig.Realm = realm
+		groups, err := mapper.New(r.k, realmConfig).ListGroups(r.ctx)
+		if err != nil {
+			return err
+		}
+		lists = append(lists, RealmGroups{Realm: realm, Groups: groups})
+	}
+	if r.outputFormat == OUTPUT_JSON {
+		return printJSONReport(r.report, lists)
+	}
+	for _, list := range lists {
+		fmt.Fprintf(r.report, "*** Realm %v ***\n", list.Realm)
+		w := tabwriter.NewWriter(r.report, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "GROUP\tREALM ROLES\tCLIENT ROLES")
+		for _, group := range list.Groups {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", group.Path, strings.Join(group.RealmRoles, ","), formatClientRoles(group.ClientRoles))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatClientRoles formats the client roles as client:role, sorted by client and role
+func formatClientRoles(clientRoles map[string][]string) string {
+	roles := []string{}
+	for client, clientRoleNames := range clientRoles {
+		for _, roleName := range clientRoleNames {
+			roles = append(roles, client+":"+roleName)
+		}
+	}
+	sort.Strings(roles)
+	return strings.Join(roles, ",")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+
+	"golang.org/x/oauth2"
+)
+
+// explainLogin tells the likely cause of a login failure: an unknown host or a refused connection point
+// to keycloak.url, a rejected login to the credentials, and a missing token endpoint to the base path
+// or the login realm
+func (r *runner) explainLogin(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return fmt.Errorf("cannot resolve host %s, check %s: %w", dnsErr.Name, PROPS_URL, err)
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("connection refused by %s, check %s and that Keycloak is running: %w", r.config.Server, PROPS_URL, err)
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) {
+		return fmt.Errorf("certificate of %s signed by an unknown authority, check %s: %w", r.config.Server, PROPS_TLS_CA_FILE, err)
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) || retrieveErr.Response == nil {
+		return err
+	}
+	switch retrieveErr.Response.StatusCode {
+	case http.StatusUnauthorized, http.StatusBadRequest:
+		if r.config.ClientSecret != "" {
+			return fmt.Errorf("login rejected, check %s and %s: %w", PROPS_CLIENT_ID, PROPS_CLIENT_SECRET, err)
+		}
+		return fmt.Errorf("login rejected, check %s and the password: %w", PROPS_USER, err)
+	case http.StatusNotFound:
+		return fmt.Errorf("token endpoint not found, check %s (e.g. /auth for legacy Keycloak distributions) and %s: %w",
+			PROPS_BASE_PATH, PROPS_AUTH_REALM, err)
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+func TestExplainLogin(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	tlsServer := httptest.NewTLSServer(nil)
+	defer tlsServer.Close()
+	// Closed last, so that its port is not reused by the other servers of the test
+	closed := httptest.NewServer(nil)
+	closed.Close()
+	tests := []struct {
+		name     string
+		change   func(c *mapper.Config)
+		expected string
+	}{
+		{"connection refused", func(c *mapper.Config) { c.Server = closed.URL }, "connection refused by " + closed.URL + ", check keycloak.url"},
+		{"unknown authority", func(c *mapper.Config) { c.Server = tlsServer.URL }, "signed by an unknown authority, check tls.ca.file"},
+		{"wrong password", func(c *mapper.Config) { c.Password = "wrong" }, "login rejected, check keycloak.user and the password"},
+		{"wrong secret", func(c *mapper.Config) {
+			c.ClientID = keycloaktest.SERVICE_CLIENT
+			c.ClientSecret = "wrong"
+		}, "login rejected, check keycloak.client.id and keycloak.client.secret"},
+		{"wrong base path", func(c *mapper.Config) { c.BasePath = "/auth" }, "token endpoint not found, check keycloak.base.path"},
+		{"unknown auth realm", func(c *mapper.Config) { c.AuthRealm = "missing" }, "and keycloak.auth.realm"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRunner()
+			r.config = mapper.Config{Server: s.URL, User: keycloaktest.ADMIN_USER, Password: keycloaktest.ADMIN_PASSWORD,
+				Logger: logger}
+			test.change(&r.config)
+			_, err := mapper.Connect(context.Background(), r.config)
+			if err == nil {
+				t.Fatal("expected the login to fail")
+			}
+			explained := r.explainLogin(err)
+			if !strings.Contains(explained.Error(), test.expected) {
+				t.Errorf("expected %q, got %v", test.expected, explained)
+			}
+			if !errors.Is(explained, err) {
+				t.Errorf("expected the cause to be wrapped, got %v", explained)
+			}
+		})
+	}
+}
+
+func TestExplainLoginUnknownHost(t *testing.T) {
+	err := fmt.Errorf("cannot login: %w", &net.DNSError{Err: "no such host", Name: "keycloak.invalid", IsNotFound: true})
+	if explained := newRunner().explainLogin(err); !strings.Contains(explained.Error(), "cannot resolve host keycloak.invalid, check keycloak.url") {
+		t.Errorf("expected the host to be reported, got %v", explained)
+	}
+	other := errors.New("unexpected")
+	if explained := newRunner().explainLogin(other); explained != other {
+		t.Errorf("expected the other errors to be kept as is, got %v", explained)
+	}
+}
@@ -3,221 +3,552 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"os"
+	"path"
 	"strings"
+	"time"
 
+	"github.com/dmartinol/keycloak-group2role/mapper"
 	"github.com/magiconair/properties"
-	"github.com/zemirco/keycloak"
-	"golang.org/x/oauth2"
 )
 
-type KeycloakSpec struct {
-	server   string
-	user     string
-	password string
-	realm    string
-}
+// Build metadata, set with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var version = "dev"
+var commit = "unknown"
+var buildDate = "unknown"
 
-var dryRunOnly = false
-var keycloakSpec KeycloakSpec
-var ctx context.Context
-var k *keycloak.Keycloak
+var propsFile = PROPS_FILE_NAME
+var showVersion = false
+var outputFormat = OUTPUT_TEXT
 
-var missingRoles = []string{}
-var groupsWithMissingRole = map[string]string{}
+// templateName is the template file, or built-in template, of -output template
+var templateName = ""
 
-func main() {
-	initProps()
-	connectToKeycloak()
-	validateRealm()
+var autoConfirm = false
+
+// strict fails the run on the warnings of the plan
+var strict = false
+var prune = false
+
+// reconcile applies the additions and the removals of the plan with a single confirmation
+var reconcile = false
+var pruneRoles = false
+
+// detectDrift makes the dry run exit with EXIT_DRIFT when changes are pending
+var detectDrift = false
+
+// planOut is the file saving the plan of the dry run, planIn the file of the plan to apply
+var planOut = ""
+var planIn = ""
+
+// planOnly prints the plan as a planDocument instead of the report
+var planOnly = false
+
+// selfTest maps the groups of a throwaway realm to check the deployment
+var selfTest = false
+
+// exportBaselineFile receives the roles currently mapped to the groups, as mapping rules
+var exportBaselineFile = ""
+
+// rollbackOut is the file saving the changes applied by the run, rollbackIn the file of the changes to undo
+var rollbackOut = ""
+var rollbackIn = ""
+
+// checkpointFile records the groups completed by the run, to resume it after a failure
+var checkpointFile = ""
+
+// scopeGroupPath and scopeGroupID restrict the run to a single group and its sub-groups
+var scopeGroupPath = ""
+var scopeGroupID = ""
+
+// environment selects the env.<name>.* properties overriding the others
+var environment = ""
+
+// fromExport is a realm export file used to plan the changes offline, instead of a live server
+var fromExport = ""
+
+// groupsFile lists the groups the run is restricted to, with their sub-groups when groupsFileSubGroups is set
+var groupsFile = ""
+var groupsFileSubGroups = false
+
+// reportOut is the file receiving the report instead of stdout
+var reportOut = ""
+
+// listOnly lists the roles of the groups, without planning any change
+var listOnly = false
+
+// checkOnly only checks the configuration and the connection to Keycloak
+var checkOnly = false
+
+// promptPassword reads the password from the terminal instead of the configuration
+var promptPassword = false
+
+// interactiveSelect asks which changes to apply one by one, instead of confirming them all at once
+var interactiveSelect = false
+
+// stdin is shared by all the confirmation prompts, as a reader may buffer more than the answer it reads
+var stdin = bufio.NewReader(os.Stdin)
+
+// stdinIsTerminal tells whether the prompts can be answered on stdin
+var stdinIsTerminal = func() bool { return isTerminal(os.Stdin) }
+
+var logLevel = new(slog.LevelVar)
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+var verbose = false
 
-	prepareMapper()
-	printMapper()
-	if !dryRunOnly {
-		createRolesAndMappings()
-	} else {
-		fmt.Printf("\nNote: Disable or remove the %v option in %v to create the missing roles and mappings", PROPS_DRYRUN, PROPS_FILE_NAME)
+// quiet only prints the errors and the summary of the run, besides the JSON and CSV reports
+var quiet = false
+
+func main() {
+	parseFlags()
+	if showVersion {
+		fmt.Printf("keycloak-group2role %s (commit %s, built %s)\n", version, commit, buildDate)
+		return
+	}
+	r := newRunner()
+	if err := r.run(); err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintf(os.Stderr, "Interrupted: %v\n", err)
+			os.Exit(EXIT_INTERRUPTED)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", r.explainTimeout(err))
+		os.Exit(EXIT_ERROR)
+	}
+	if detectDrift && r.driftDetected {
+		os.Exit(EXIT_DRIFT)
 	}
 }
 
 const PROPS_FILE_NAME = "mapper.properties"
+const FLAG_CONFIG = "config"
+const FLAG_OUTPUT = "output"
+const FLAG_REPORT_OUT = "report-out"
+const FLAG_TEMPLATE = "template"
+const OUTPUT_TEXT = "text"
+const OUTPUT_JSON = "json"
+const OUTPUT_DIFF = "diff"
+const OUTPUT_CSV = "csv"
+const OUTPUT_JSONL = "jsonl"
+const OUTPUT_TEMPLATE = "template"
+const FLAG_YES = "yes"
+const FLAG_STRICT = "strict"
+const FLAG_VERBOSE = "v"
+const FLAG_QUIET = "quiet"
+const FLAG_PRUNE = "prune"
+const FLAG_PRUNE_ROLES = "prune-roles"
+const FLAG_RECONCILE = "reconcile"
+const FLAG_VERSION = "version"
+const FLAG_DETECT_DRIFT = "detect-drift"
+const FLAG_PLAN_OUT = "plan-out"
+const FLAG_PLAN_ONLY = "plan-only"
+const FLAG_SELFTEST = "selftest"
+const FLAG_EXPORT_BASELINE = "export-baseline"
+const FLAG_WATCH = "watch"
+const FLAG_PLAN_IN = "plan-in"
+const FLAG_GROUP = "group"
+const FLAG_CHECK = "check"
+const FLAG_LIST = "list"
+const FLAG_GROUP_ID = "group-id"
+const FLAG_GROUPS_FILE = "groups-file"
+const FLAG_FROM_EXPORT = "from-export"
+const FLAG_NO_COLOR = "no-color"
+const FLAG_ENV = "env"
+const FLAG_ROLLBACK_OUT = "rollback-out"
+const FLAG_ROLLBACK_IN = "rollback-in"
+const FLAG_CHECKPOINT = "checkpoint"
+const FLAG_GROUPS_FILE_SUBGROUPS = "groups-file-subgroups"
+const FLAG_INTERACTIVE_SELECT = "interactive-select"
+const FLAG_PROMPT_PASSWORD = "prompt-password"
 const PROPS_DRYRUN = "dry.run.only"
+const PROPS_DRYRUN_VALIDATE = "dry.run.validate"
+const PROPS_AUTO_CONFIRM = "auto.confirm"
+const PROPS_CONFIRM_MODE = "confirm.mode"
+const CONFIRM_SIMPLE = "simple"
+const CONFIRM_TYPE_REALM = "type-realm"
+const PROPS_LOG_LEVEL = "log.level"
 const PROPS_URL = "keycloak.url"
+const PROPS_BASE_PATH = "keycloak.base.path"
+const PROPS_CONTEXT_PATH = "keycloak.context.path"
 const PROPS_USER = "keycloak.user"
 const PROPS_PASSWORD = "keycloak.password"
+const PROPS_PASSWORD_FILE = "keycloak.password.file"
+const PROPS_CLIENT_ID = "keycloak.client.id"
+const PROPS_CLIENT_SECRET = "keycloak.client.secret"
+const PROPS_TOKEN_CLIENT_ID = "keycloak.token.client.id"
+const PROPS_AUTH_REALM = "keycloak.auth.realm"
 const PROPS_REALM = "keycloak.realm"
+const PROPS_REALMS = "keycloak.realms"
+const PROPS_REALM_ALLOWLIST = "realm.allowlist"
+const PROPS_MAX_RETRIES = "max.retries"
+const PROPS_ALLOW_MASTER_REALM = "allow.master.realm"
+const PROPS_TLS_CA_FILE = "tls.ca.file"
+const PROPS_TLS_INSECURE = "tls.insecure.skip.verify"
+const PROPS_HTTP_PROXY = "http.proxy"
+const PROPS_REQUEST_TIMEOUT = "request.timeout"
+const PROPS_RUN_TIMEOUT = "run.timeout"
 
-func templateProps() {
-	template := map[string]string{
-		PROPS_DRYRUN:   "false",
-		PROPS_URL:      "http://localhost:8080",
-		PROPS_USER:     "admin",
-		PROPS_PASSWORD: "password",
-		PROPS_REALM:    "realm",
-	}
-	p := properties.LoadMap(template)
-	f, _ := os.Create(PROPS_FILE_NAME)
-	w := bufio.NewWriter(f)
-	p.Write(w, properties.UTF8)
-	w.Flush()
-}
+// Exit codes of the tool, EXIT_DRIFT is only used with -detect-drift. EXIT_INTERRUPTED is the
+// conventional code of the processes stopped by SIGINT
+const EXIT_ERROR = 1
+const EXIT_DRIFT = 2
+const EXIT_INTERRUPTED = 130
+const DEFAULT_REQUEST_TIMEOUT = 30 * time.Second
+const ALL_REALMS = "*"
 
-func initProps() {
-	p, err := properties.LoadFile(PROPS_FILE_NAME, properties.UTF8)
-	if err != nil {
-		fmt.Printf("Missing properties file %s. Creating a default template for you\n", PROPS_FILE_NAME)
-		templateProps()
-		panic(err)
-	}
-	dryRunOnly = p.GetBool(PROPS_DRYRUN, false)
-	keycloakSpec = KeycloakSpec{}
-	keycloakSpec.server = p.MustGetString(PROPS_URL)
-	keycloakSpec.user = p.MustGetString(PROPS_USER)
-	keycloakSpec.password = p.MustGetString(PROPS_PASSWORD)
-	keycloakSpec.realm = p.MustGetString(PROPS_REALM)
-	fmt.Println("*** Running with ***")
-	fmt.Printf("Dry run only: %v\n", dryRunOnly)
-	fmt.Printf("Keycloak specs: %v\n", keycloakSpec)
+// propsEnvVars are the environment variables overriding the connection properties, to avoid storing
+// the credentials on disk
+var propsEnvVars = map[string]string{
+	PROPS_URL:           "KEYCLOAK_URL",
+	PROPS_USER:          "KEYCLOAK_USER",
+	PROPS_PASSWORD:      "KEYCLOAK_PASSWORD",
+	PROPS_CLIENT_SECRET: "KEYCLOAK_CLIENT_SECRET",
+	PROPS_REALM:         "KEYCLOAK_REALM",
 }
 
-func connectToKeycloak() {
-	config := oauth2.Config{
-		ClientID: "admin-cli",
-		Endpoint: oauth2.Endpoint{
-			TokenURL: keycloakSpec.server + "/auth/realms/master/protocol/openid-connect/token",
-		},
-	}
+const PROPS_ROLE_PREFIX = "role.name.prefix"
+const PROPS_ROLE_SUFFIX = "role.name.suffix"
+const PROPS_TARGET_CLIENT = "keycloak.client.target"
+const PROPS_GROUP_INCLUDE = "group.include"
+const PROPS_GROUP_EXCLUDE = "group.exclude"
+const PROPS_SKIP_DEFAULT_GROUPS = "skip.default.groups"
+const PROPS_SKIP_EMPTY_GROUPS = "skip.empty.groups"
+const PROPS_ORGANIZATION = "keycloak.organization"
+const PROPS_CLIENT_GROUPS_PATH = "group.path.to.client.role"
+const PROPS_STRICT = "strict"
+const PROPS_ROLE_NAME_FROM = "role.name.from"
+const PROPS_ROLE_NAME_SANITIZE = "role.name.sanitize"
+const PROPS_ROLE_NAME_SPLIT = "role.name.split"
+const PROPS_ROLE_FROM_ATTRIBUTE = "role.from.attribute"
+const PROPS_GROUP_MAX_DEPTH = "group.max.depth"
+const PROPS_MANAGED_ATTRIBUTE = "role.managed.attribute"
+const PROPS_ROLE_DESCRIPTION = "role.description.template"
 
-	ctx = context.Background()
-	token, err := config.PasswordCredentialsToken(ctx, keycloakSpec.user, keycloakSpec.password)
-	if err != nil {
-		panic(err)
-	}
+// PROPS_ROLE_ATTRIBUTES prefixes the attribute templates of the created roles, like role.attributes.team={name}
+const PROPS_ROLE_ATTRIBUTES = "role.attributes"
+const PROPS_CONCURRENCY = "concurrency"
+const PROPS_MAPPING_FILE = "role.mapping.file"
+const PROPS_ROLE_COMPOSITE = "role.composite"
+const PROPS_ROLE_DEFAULT = "role.default"
+const PROPS_ROLE_ALWAYS_ADD = "role.always.add"
+const PROPS_MAPPING_MODE = "mapping.mode"
+const PROPS_ROLE_INHERIT_PARENT = "role.inherit.parent"
+const PROPS_AUDIT_FILE = "audit.file"
+const PROPS_METRICS_FILE = "metrics.file"
+const PROPS_CREATE_ROLES = "create.roles"
+const PROPS_CREATE_MAPPINGS = "create.mappings"
+const PROPS_CHECK_UNEXPECTED_ROLES = "role.check.unexpected"
+const PROPS_ROLE_ALLOWLIST = "role.allowlist"
 
-	client := config.Client(ctx, token)
-	k, err = keycloak.NewKeycloak(client, keycloakSpec.server+"/auth/")
-	if err != nil {
-		panic(err)
-	}
-	fmt.Printf("Logged in to %v\n ", keycloakSpec.server)
+func parseFlags() {
+	flag.StringVar(&propsFile, FLAG_CONFIG, PROPS_FILE_NAME, "Path of the properties file")
+	flag.StringVar(&outputFormat, FLAG_OUTPUT, OUTPUT_TEXT, "Format of the report: text, json, diff, csv, jsonl or template")
+	flag.StringVar(&templateName, FLAG_TEMPLATE, "", "Go template file rendering the report, or the built-in markdown or summary template")
+	flag.StringVar(&reportOut, FLAG_REPORT_OUT, "", "Write the report to the given file instead of stdout")
+	flag.BoolVar(&autoConfirm, FLAG_YES, false, "Apply the changes without asking for confirmation")
+	flag.BoolVar(&strict, FLAG_STRICT, false, "Fail the run when the plan has warnings, like malformed groups or unexpected roles")
+	flag.BoolVar(&verbose, FLAG_VERBOSE, false, "Enable the debug logs, same as log.level=debug")
+	flag.BoolVar(&quiet, FLAG_QUIET, false, "Only print the errors and the summary, besides the JSON and CSV reports")
+	flag.BoolVar(&prune, FLAG_PRUNE, false, "Remove the mappings of the roles created by this tool that no longer match a group")
+	flag.BoolVar(&pruneRoles, FLAG_PRUNE_ROLES, false, "Also delete the orphaned roles, implies -"+FLAG_PRUNE)
+	flag.BoolVar(&reconcile, FLAG_RECONCILE, false, "Create the missing roles and mappings and remove the orphaned ones at once, implies -"+FLAG_PRUNE_ROLES)
+	flag.BoolVar(&detectDrift, FLAG_DETECT_DRIFT, false, fmt.Sprintf("Dry run exiting with code %d when changes are pending", EXIT_DRIFT))
+	flag.StringVar(&planOut, FLAG_PLAN_OUT, "", "Dry run saving the plan to the given file")
+	flag.BoolVar(&planOnly, FLAG_PLAN_ONLY, false, "Dry run printing the plan as a versioned JSON document, for the approval systems")
+	flag.StringVar(&planIn, FLAG_PLAN_IN, "", "Apply the plan saved with -"+FLAG_PLAN_OUT+" instead of computing it")
+	flag.StringVar(&scopeGroupPath, FLAG_GROUP, "", "Restrict the run to the group with the given path, like /parent/child, and its sub-groups")
+	flag.StringVar(&scopeGroupID, FLAG_GROUP_ID, "", "Restrict the run to the group with the given ID and its sub-groups")
+	flag.StringVar(&groupsFile, FLAG_GROUPS_FILE, "", "Restrict the run to the groups listed in the given file, one path per line")
+	flag.BoolVar(&groupsFileSubGroups, FLAG_GROUPS_FILE_SUBGROUPS, false, "Also process the sub-groups of the groups of -"+FLAG_GROUPS_FILE)
+	flag.BoolVar(&interactiveSelect, FLAG_INTERACTIVE_SELECT, false, "Ask which roles and mappings to apply one by one")
+	flag.BoolVar(&promptPassword, FLAG_PROMPT_PASSWORD, false, "Type the password of the user on the terminal")
+	flag.StringVar(&fromExport, FLAG_FROM_EXPORT, "", "Dry run computing the plan offline from the given realm export file")
+	flag.BoolVar(&noColor, FLAG_NO_COLOR, false, "Disable the colors of the report, same as the NO_COLOR environment variable")
+	flag.StringVar(&environment, FLAG_ENV, "", "Name of the environment block of the configuration to use, like stage")
+	flag.StringVar(&rollbackOut, FLAG_ROLLBACK_OUT, "", "Save the changes applied by the run to the given file, to undo them later")
+	flag.StringVar(&rollbackIn, FLAG_ROLLBACK_IN, "", "Undo the changes saved with -"+FLAG_ROLLBACK_OUT+" instead of mapping the groups")
+	flag.StringVar(&checkpointFile, FLAG_CHECKPOINT, "", "Record the groups completed by the run to the given file, and skip them when the run is resumed")
+	flag.BoolVar(&selfTest, FLAG_SELFTEST, false, "Map the groups of a throwaway realm, check the result and delete the realm, then exit")
+	flag.StringVar(&exportBaselineFile, FLAG_EXPORT_BASELINE, "", "Export the roles currently mapped to the groups to the given JSON mapping file, then exit")
+	flag.DurationVar(&watchInterval, FLAG_WATCH, 0, "Plan and apply the changes again at the given interval, e.g. 5m, until interrupted")
+	flag.BoolVar(&listOnly, FLAG_LIST, false, "Only list the roles currently mapped to the groups, then exit")
+	flag.BoolVar(&checkOnly, FLAG_CHECK, false, "Only check the configuration, the login and the realms, then exit")
+	flag.BoolVar(&showVersion, FLAG_VERSION, false, "Print the version and exit")
+	flag.Parse()
+	pruneRoles = pruneRoles || reconcile
+	prune = prune || pruneRoles
 }
 
-func validateRealm() {
-	realm, _, err := k.Realms.Get(ctx, keycloakSpec.realm)
+func (r *runner) initProps() error {
+	if _, err := os.Stat(propsFile); errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("Missing properties file. Creating a default template for you", "file", propsFile)
+		if templateErr := templateProps(); templateErr != nil {
+			return fmt.Errorf("cannot create properties template %s: %w", propsFile, templateErr)
+		}
+		return fmt.Errorf("missing properties file %s: edit the template and run again", propsFile)
+	}
+	p, err := loadProps(propsFile)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("cannot load properties file %s: %w", propsFile, err)
 	}
-	if realm.ID == nil {
-		panic(fmt.Sprintf("Provided realm '%s' is not configured", keycloakSpec.realm))
+	if environment != "" {
+		if err := selectEnvironment(p, environment); err != nil {
+			return err
+		}
 	}
-	fmt.Printf("Found realm: %v\n", *realm.Realm)
-}
-
-func prepareMapper() {
-	groups, _, err := k.Groups.List(ctx, keycloakSpec.realm)
-	if err != nil {
-		panic(err)
+	if err := initLogLevel(p.GetString(PROPS_LOG_LEVEL, "info")); err != nil {
+		return err
 	}
-	for _, g := range groups {
-		prepareMapperForGroup(g)
+	r.dryRunOnly = detectDrift || planOnly || planOut != "" || fromExport != "" || p.GetBool(PROPS_DRYRUN, false)
+	r.autoConfirm = autoConfirm || p.GetBool(PROPS_AUTO_CONFIRM, false)
+	r.confirmMode = p.GetString(PROPS_CONFIRM_MODE, CONFIRM_SIMPLE)
+	if r.confirmMode != CONFIRM_SIMPLE && r.confirmMode != CONFIRM_TYPE_REALM {
+		return fmt.Errorf("invalid %s %s: must be %s or %s", PROPS_CONFIRM_MODE, r.confirmMode, CONFIRM_SIMPLE, CONFIRM_TYPE_REALM)
 	}
-}
-
-func prepareMapperForGroup(group *keycloak.Group) {
-	fmt.Printf("Preparing mapper for group: %v/%v\n", *group.Name, *group.ID)
-	g, _, err := k.Groups.Get(ctx, keycloakSpec.realm, *group.ID)
-	if err != nil {
-		panic(err)
+	r.config = mapper.Config{Logger: logger, Prune: prune, PruneRoles: pruneRoles, GroupPath: scopeGroupPath, GroupID: scopeGroupID,
+		GroupPathsRecursive: groupsFileSubGroups}
+	if groupsFile != "" {
+		if r.config.GroupPaths, err = readGroupsFile(groupsFile); err != nil {
+			return err
+		}
 	}
-
-	groupMapped := false
-	for _, r := range g.RealmRoles {
-		if r == *g.Name {
-			fmt.Printf("\tRole %v is already mapped\n", *g.Name)
-			groupMapped = true
-			break
+	// The realm export needs no server nor credentials
+	if fromExport == "" {
+		if r.config.Server, err = requiredProp(p, PROPS_URL); err != nil {
+			return err
 		}
 	}
-
-	if !groupMapped {
-		fmt.Printf("\tRole mapping is missing for: %v\n", *g.Name)
-		mappedRole := getRoleGyName(*g.Name)
-		if mappedRole.ID == nil {
-			missingRoles = append(missingRoles, *g.Name)
-		} else {
-			fmt.Printf("\tMapping role already exists: %v/%v\n", *mappedRole.ID, *mappedRole.Name)
+	r.config.BasePath = normalizeBasePath(p.GetString(PROPS_BASE_PATH, ""))
+	// keycloak.context.path is an alias, e.g. for reverse proxies mounting Keycloak under /identity
+	if contextPath := normalizeBasePath(p.GetString(PROPS_CONTEXT_PATH, "")); contextPath != "" {
+		if r.config.BasePath != "" && r.config.BasePath != contextPath {
+			return fmt.Errorf("%s %s and %s %s cannot be combined", PROPS_BASE_PATH, r.config.BasePath, PROPS_CONTEXT_PATH, contextPath)
 		}
-
-		groupsWithMissingRole[*g.ID] = *g.Name
+		r.config.BasePath = contextPath
 	}
-
-	for _, subGroup := range group.SubGroups {
-		fmt.Printf("\tIterate on sub-group: %v\n", *subGroup.Name)
-		prepareMapperForGroup(subGroup)
+	r.config.AuthRealm = p.GetString(PROPS_AUTH_REALM, mapper.MASTER_REALM)
+	r.config.ClientSecret, _ = lookupProp(p, PROPS_CLIENT_SECRET)
+	if r.config.ClientSecret != "" {
+		if r.config.ClientID, err = requiredProp(p, PROPS_CLIENT_ID); err != nil {
+			return err
+		}
+	} else if fromExport == "" {
+		if r.config.User, err = requiredProp(p, PROPS_USER); err != nil {
+			return err
+		}
+		if r.config.Password, err = r.readPassword(p); err != nil {
+			return err
+		}
+		r.config.TokenClientID = p.GetString(PROPS_TOKEN_CLIENT_ID, mapper.DEFAULT_TOKEN_CLIENT_ID)
 	}
-}
-
-func printMapper() {
-	if anyConfigurationNeeded() {
-		fmt.Println("*** The following missing roles will be created ***")
-		for _, roleName := range missingRoles {
-			fmt.Printf("Role %v\n", roleName)
+	if r.config.RequestTimeout, err = durationProp(p, PROPS_REQUEST_TIMEOUT, DEFAULT_REQUEST_TIMEOUT); err != nil {
+		return err
+	}
+	if r.runTimeout, err = durationProp(p, PROPS_RUN_TIMEOUT, 0); err != nil {
+		return err
+	}
+	r.config.TLSCAFile = p.GetString(PROPS_TLS_CA_FILE, "")
+	r.config.TLSInsecure = p.GetBool(PROPS_TLS_INSECURE, false)
+	r.config.Proxy = p.GetString(PROPS_HTTP_PROXY, "")
+	r.allowMaster = p.GetBool(PROPS_ALLOW_MASTER_REALM, false)
+	r.config.MaxRetries = p.GetInt(PROPS_MAX_RETRIES, mapper.DEFAULT_MAX_RETRIES)
+	if r.config.MaxRetries < 0 {
+		return fmt.Errorf("invalid %s %d: must be 0 or more", PROPS_MAX_RETRIES, r.config.MaxRetries)
+	}
+	// KEYCLOAK_REALM takes precedence over both keycloak.realms and keycloak.realm
+	if os.Getenv(propsEnvVars[PROPS_REALM]) == "" {
+		r.realms = splitList(p.GetString(PROPS_REALMS, ""))
+	}
+	if r.config.AllowedRealms, err = globList(p, PROPS_REALM_ALLOWLIST); err != nil {
+		return err
+	}
+	if len(r.realms) == 0 {
+		realm, err := requiredProp(p, PROPS_REALM)
+		if err != nil {
+			return err
+		}
+		r.realms = []string{realm}
+	}
+	r.config.RolePrefix = p.GetString(PROPS_ROLE_PREFIX, "")
+	r.config.RoleSuffix = p.GetString(PROPS_ROLE_SUFFIX, "")
+	r.config.TargetClient = p.GetString(PROPS_TARGET_CLIENT, "")
+	r.config.RoleNameFrom = p.GetString(PROPS_ROLE_NAME_FROM, mapper.ROLE_NAME_FROM_NAME)
+	if r.config.RoleNameFrom != mapper.ROLE_NAME_FROM_NAME && r.config.RoleNameFrom != mapper.ROLE_NAME_FROM_PATH {
+		return fmt.Errorf("invalid %s %s: must be %s or %s", PROPS_ROLE_NAME_FROM, r.config.RoleNameFrom,
+			mapper.ROLE_NAME_FROM_NAME, mapper.ROLE_NAME_FROM_PATH)
+	}
+	r.config.RoleNameSplit = p.GetString(PROPS_ROLE_NAME_SPLIT, "")
+	r.config.RoleFromAttribute = p.GetString(PROPS_ROLE_FROM_ATTRIBUTE, "")
+	r.config.RoleNameSanitize = p.GetString(PROPS_ROLE_NAME_SANITIZE, mapper.SANITIZE_NONE)
+	switch r.config.RoleNameSanitize {
+	case mapper.SANITIZE_NONE, mapper.SANITIZE_REJECT, mapper.SANITIZE_REPLACE:
+	default:
+		return fmt.Errorf("invalid %s %s: must be %s, %s or %s", PROPS_ROLE_NAME_SANITIZE, r.config.RoleNameSanitize,
+			mapper.SANITIZE_NONE, mapper.SANITIZE_REJECT, mapper.SANITIZE_REPLACE)
+	}
+	r.config.ManagedAttribute = p.GetString(PROPS_MANAGED_ATTRIBUTE, mapper.DEFAULT_MANAGED_ATTRIBUTE)
+	r.config.RoleDescription = p.GetString(PROPS_ROLE_DESCRIPTION, mapper.DEFAULT_ROLE_DESCRIPTION)
+	if r.config.ManagedAttribute == "" {
+		return fmt.Errorf("invalid %s: must not be empty", PROPS_MANAGED_ATTRIBUTE)
+	}
+	r.config.RoleAttributes = map[string]string{}
+	for key, template := range p.FilterStripPrefix(PROPS_ROLE_ATTRIBUTES + ".").Map() {
+		if key == r.config.ManagedAttribute || key == mapper.SOURCE_GROUP_ATTRIBUTE {
+			return fmt.Errorf("invalid %s.%s: the %s attribute is reserved to track the created roles", PROPS_ROLE_ATTRIBUTES, key, key)
+		}
+		r.config.RoleAttributes[key] = template
+	}
+	// role.inherit.parent is an alias, named after the inheritance of the roles along the group hierarchy
+	r.config.CompositeRoles = p.GetBool(PROPS_ROLE_COMPOSITE, false) || p.GetBool(PROPS_ROLE_INHERIT_PARENT, false)
+	r.config.SkipRoles = !p.GetBool(PROPS_CREATE_ROLES, true)
+	r.config.DefaultRoles = p.GetBool(PROPS_ROLE_DEFAULT, false)
+	r.config.AlwaysAddRoles = splitList(p.GetString(PROPS_ROLE_ALWAYS_ADD, ""))
+	if r.config.DefaultRoles && r.config.SkipRoles {
+		return fmt.Errorf("%s only applies to the created roles, it cannot be combined with %s=false", PROPS_ROLE_DEFAULT, PROPS_CREATE_ROLES)
+	}
+	r.config.SkipMappings = !p.GetBool(PROPS_CREATE_MAPPINGS, true)
+	r.config.MappingMode = p.GetString(PROPS_MAPPING_MODE, mapper.MAPPING_MODE_ENSURE_PRESENT)
+	if r.config.MappingMode != mapper.MAPPING_MODE_ENSURE_PRESENT && r.config.MappingMode != mapper.MAPPING_MODE_EXACT {
+		return fmt.Errorf("invalid %s %s: must be %s or %s", PROPS_MAPPING_MODE, r.config.MappingMode,
+			mapper.MAPPING_MODE_ENSURE_PRESENT, mapper.MAPPING_MODE_EXACT)
+	}
+	r.auditFile = p.GetString(PROPS_AUDIT_FILE, "")
+	r.metricsFile = p.GetString(PROPS_METRICS_FILE, "")
+	r.config.CheckUnexpectedRoles = p.GetBool(PROPS_CHECK_UNEXPECTED_ROLES, false)
+	if r.config.AllowedRoles, err = globList(p, PROPS_ROLE_ALLOWLIST); err != nil {
+		return err
+	}
+	r.config.RoleOverrides = map[string]string{}
+	if mappingFile := p.GetString(PROPS_MAPPING_FILE, ""); mappingFile != "" {
+		if r.config.RoleOverrides, err = loadMappingRules(mappingFile); err != nil {
+			return err
 		}
-		fmt.Println("*** The following mappings will be created ***")
-		for _, groupName := range groupsWithMissingRole {
-			fmt.Printf("Group %v to Role %v\n", groupName, groupName)
+	}
+	r.config.Concurrency = p.GetInt(PROPS_CONCURRENCY, mapper.DEFAULT_CONCURRENCY)
+	if r.config.Concurrency < 1 {
+		return fmt.Errorf("invalid %s %d: must be 1 or more", PROPS_CONCURRENCY, r.config.Concurrency)
+	}
+	r.config.MaxDepth = p.GetInt(PROPS_GROUP_MAX_DEPTH, 0)
+	if r.config.MaxDepth < 0 {
+		return fmt.Errorf("invalid %s %d: must be 0 (unlimited) or more", PROPS_GROUP_MAX_DEPTH, r.config.MaxDepth)
+	}
+	if prune && r.config.MaxDepth > 0 {
+		return fmt.Errorf("-%s needs all the groups: remove the %s property", FLAG_PRUNE, PROPS_GROUP_MAX_DEPTH)
+	}
+	r.config.SkipDefaultGroups = p.GetBool(PROPS_SKIP_DEFAULT_GROUPS, false)
+	r.config.SkipEmptyGroups = p.GetBool(PROPS_SKIP_EMPTY_GROUPS, false)
+	r.config.Organization = p.GetString(PROPS_ORGANIZATION, "")
+	if r.config.Organization != "" && fromExport != "" {
+		return fmt.Errorf("the realm export has no organizations, %s cannot be combined with -%s", PROPS_ORGANIZATION, FLAG_FROM_EXPORT)
+	}
+	r.config.ValidateRoles = r.dryRunOnly && p.GetBool(PROPS_DRYRUN_VALIDATE, false)
+	if clientGroupsPath := p.GetString(PROPS_CLIENT_GROUPS_PATH, ""); clientGroupsPath != "" {
+		if !strings.HasPrefix(clientGroupsPath, "/") {
+			return fmt.Errorf("invalid %s %s: must be a group path starting with /", PROPS_CLIENT_GROUPS_PATH, clientGroupsPath)
+		}
+		if r.config.TargetClient != "" {
+			return fmt.Errorf("%s and %s cannot be combined", PROPS_CLIENT_GROUPS_PATH, PROPS_TARGET_CLIENT)
 		}
-	} else {
-		fmt.Println("*** All roles and mappings are already set, no changes needed ***")
+		if prune || planIn != "" || planOut != "" || rollbackIn != "" || rollbackOut != "" || checkpointFile != "" || watchInterval > 0 {
+			return fmt.Errorf("%s cannot be combined with -%s, -%s, -%s, -%s, -%s, -%s or -%s", PROPS_CLIENT_GROUPS_PATH, FLAG_PRUNE,
+				FLAG_PLAN_IN, FLAG_PLAN_OUT, FLAG_ROLLBACK_IN, FLAG_ROLLBACK_OUT, FLAG_CHECKPOINT, FLAG_WATCH)
+		}
+		r.config.ClientGroupsPath = path.Clean(clientGroupsPath)
+	}
+	r.strict = strict || p.GetBool(PROPS_STRICT, false)
+	if r.config.GroupInclude, err = globList(p, PROPS_GROUP_INCLUDE); err != nil {
+		return err
+	}
+	if r.config.GroupExclude, err = globList(p, PROPS_GROUP_EXCLUDE); err != nil {
+		return err
 	}
+	logger.Info("Running with", "dryRunOnly", r.dryRunOnly, "autoConfirm", r.autoConfirm, "realms", r.realms,
+		"config", r.config.String())
+	return nil
 }
 
-func anyConfigurationNeeded() bool {
-	return len(missingRoles) > 0 || len(groupsWithMissingRole) > 0
+// initLogLevel sets the level of the logger from the log.level property: debug, info, warn or error.
+// The -v flag always enables the debug level, -quiet the error level
+func initLogLevel(level string) error {
+	if verbose {
+		logLevel.Set(slog.LevelDebug)
+		return nil
+	}
+	if quiet {
+		logLevel.Set(slog.LevelError)
+		return nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid %s %s: %w", PROPS_LOG_LEVEL, level, err)
+	}
+	logLevel.Set(l)
+	return nil
 }
 
-func createRolesAndMappings() {
-	if anyConfigurationNeeded() {
-		reader := bufio.NewReader(os.Stdin)
-
-		fmt.Print("Do you really want to continue? (Y/N): ")
-		answer, _ := reader.ReadString('\n')
+// splitList splits a comma-separated property value, ignoring the blank items
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
 
-		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(answer)), "Y") {
-			fmt.Println("*** Creating missing roles ***")
-			for _, roleName := range missingRoles {
-				createRoleByName(roleName)
-			}
-			fmt.Println("*** Creating missing mappings ***")
-			for groupID, groupName := range groupsWithMissingRole {
-				addRoleToGroup(groupID, getRoleGyName(groupName))
-			}
+func requiredProp(p *properties.Properties, key string) (string, error) {
+	value, ok := lookupProp(p, key)
+	if !ok {
+		if env, found := propsEnvVars[key]; found {
+			return "", fmt.Errorf("missing property %s in %s or environment variable %s", key, propsFile, env)
 		}
+		return "", fmt.Errorf("missing property %s in %s", key, propsFile)
 	}
+	return value, nil
 }
 
-func createRoleByName(name string) {
-	role := &keycloak.Role{Name: &name}
-	fmt.Printf("Creating missing role %v\n", *role.Name)
-	_, err := k.RealmRoles.Create(ctx, keycloakSpec.realm, role)
+// durationProp reads a duration property like 30s or 5m, 0 meaning unlimited
+func durationProp(p *properties.Properties, key string, def time.Duration) (time.Duration, error) {
+	value, ok := p.Get(key)
+	if !ok || strings.TrimSpace(value) == "" {
+		return def, nil
+	}
+	duration, err := time.ParseDuration(strings.TrimSpace(value))
 	if err != nil {
-		panic(err)
+		return 0, fmt.Errorf("invalid %s %s: %w", key, value, err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("invalid %s %s: must not be negative", key, value)
 	}
+	return duration, nil
 }
 
-func getRoleGyName(name string) *keycloak.Role {
-	role, _, err := k.RealmRoles.GetByName(ctx, keycloakSpec.realm, name)
-	if err != nil {
-		panic(err)
+// lookupProp returns the value of the property, unless it's overridden by its environment variable
+func lookupProp(p *properties.Properties, key string) (string, bool) {
+	if env, found := propsEnvVars[key]; found {
+		if value := os.Getenv(env); value != "" {
+			return value, true
+		}
+	}
+	return p.Get(key)
+}
+
+// normalizeBasePath returns the context path with a leading slash and no trailing one,
+// e.g. "auth/" becomes "/auth". An empty path is kept as is (Keycloak 17+ default)
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return ""
 	}
-	return role
+	return "/" + basePath
 }
 
-func addRoleToGroup(groupID string, role *keycloak.Role) {
-	groupName := groupsWithMissingRole[groupID]
-	mappedRole := getRoleGyName(groupName)
-	fmt.Printf("Creating mapping between group %v and role %v/%v\n", groupName, *mappedRole.Name, *mappedRole.ID)
-	var mappedRoles = []*keycloak.Role{mappedRole}
-	k.Groups.AddRealmRoles(ctx, keycloakSpec.realm, groupID, mappedRoles)
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,85 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const PAGE_SIZE = 100
+const DEFAULT_MAX_RETRIES = 3
+const RETRY_INITIAL_BACKOFF = 500 * time.Millisecond
+
+// apiCall sends a request to the Keycloak admin REST API, for the endpoints not covered by the keycloak client.
+// The path is relative to the base URL, e.g. admin/realms/myrealm/clients
+func (m *Mapper) apiCall(ctx context.Context, method, path string, body, v interface{}) (*http.Response, error) {
+	return m.retry(ctx, func() (*http.Response, error) {
+		req, err := m.client.NewRequest(method, path, body)
+		if err != nil {
+			return nil, err
+		}
+		return m.client.Do(ctx, req, v)
+	})
+}
+
+// listPages reads the items returned by the given API page by page, until an empty page is returned
+func listPages[T any](ctx context.Context, m *Mapper, path string) ([]T, *http.Response, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	items := []T{}
+	for first := 0; ; first += PAGE_SIZE {
+		var page []T
+		res, err := m.apiCall(ctx, http.MethodGet, fmt.Sprintf("%s%sfirst=%d&max=%d", path, separator, first, PAGE_SIZE), nil, &page)
+		if err != nil {
+			return nil, res, err
+		}
+		if len(page) == 0 {
+			return items, res, nil
+		}
+		items = append(items, page...)
+	}
+}
+
+// retry runs the API call until it succeeds, fails with a non transient error or Config.MaxRetries is reached.
+// The delay between two attempts doubles at every retry. The returned error includes the response of Keycloak
+func (m *Mapper) retry(ctx context.Context, call func() (*http.Response, error)) (*http.Response, error) {
+	backoff := RETRY_INITIAL_BACKOFF
+	for attempt := 1; ; attempt++ {
+		res, err := call()
+		if err == nil || attempt > m.config.MaxRetries || !isTransient(ctx, res) {
+			return res, m.withErrorBody(res, err)
+		}
+		m.logger.Warn("Retrying failed API call", "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return res, m.withErrorBody(res, err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isTransient tells whether a failed call can be retried: network errors, with no response, and 5xx
+// server errors are retried, 4xx client errors are not
+func isTransient(ctx context.Context, res *http.Response) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return res == nil || res.StatusCode >= http.StatusInternalServerError
+}
+
+func isNotFound(res *http.Response) bool {
+	return res != nil && res.StatusCode == http.StatusNotFound
+}
+
+func isMethodNotAllowed(res *http.Response) bool {
+	return res != nil && res.StatusCode == http.StatusMethodNotAllowed
+}
+
+func isConflict(res *http.Response) bool {
+	return res != nil && res.StatusCode == http.StatusConflict
+}
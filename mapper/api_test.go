@@ -0,0 +1,47 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestListGroupsReadsAllPages(t *testing.T) {
+	s, realm := newTestServer(t)
+	count := 2*PAGE_SIZE + 50
+	for i := 0; i < count; i++ {
+		realm.AddGroup(fmt.Sprintf("/group-%03d", i))
+	}
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if planned := m.Summary().PlannedMappings; planned != count {
+		t.Errorf("expected %d mappings, got %d", count, planned)
+	}
+	// Three full pages, then the empty page ending the list
+	if calls := s.Count(http.MethodGet, TEST_REALM, "groups"); calls != 4 {
+		t.Errorf("expected 4 pages of groups, got %d", calls)
+	}
+}
+
+func TestListPagesStopsOnError(t *testing.T) {
+	s, realm := newTestServer(t)
+	for i := 0; i < PAGE_SIZE+1; i++ {
+		realm.AddRole(fmt.Sprintf("role-%03d", i))
+	}
+	m := newTestMapper(t, s, testConfig(s))
+	pages := 0
+	s.Fail = func(method string, path string) int {
+		if path == "admin/realms/"+TEST_REALM+"/roles" {
+			if pages++; pages == 2 {
+				return http.StatusInternalServerError
+			}
+		}
+		return 0
+	}
+	if roles, _, err := listPages[*struct{}](context.Background(), m, "admin/realms/"+TEST_REALM+"/roles"); err == nil {
+		t.Errorf("expected the failed page to be returned, got %d roles", len(roles))
+	}
+}
@@ -0,0 +1,69 @@
+package mapper
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Actions and results of the audit entries
+const AUDIT_CREATE_ROLE = "create-role"
+const AUDIT_CREATE_MAPPING = "create-mapping"
+const AUDIT_REMOVE_MAPPING = "remove-mapping"
+const AUDIT_DELETE_ROLE = "delete-role"
+const AUDIT_PLANNED = "planned"
+const AUDIT_SUCCESS = "success"
+const AUDIT_SKIPPED = "skipped"
+const AUDIT_FAILURE = "failure"
+
+// AuditEntry records a change of the realm, written as a JSON line to Config.Audit
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Realm  string    `json:"realm"`
+	Group  string    `json:"group,omitempty"`
+	Role   string    `json:"role"`
+	Action string    `json:"action"`
+	Result string    `json:"result"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// audit records the outcome of a change, or the planned change when result is AUDIT_PLANNED
+func (m *Mapper) audit(action string, groupPath string, roleName string, result string, err error) {
+	if m.config.Audit == nil {
+		return
+	}
+	user := m.config.User
+	if m.config.ClientSecret != "" {
+		user = m.config.ClientID
+	}
+	entry := AuditEntry{Time: time.Now().UTC(), User: user, Realm: m.config.Realm, Group: groupPath, Role: roleName,
+		Action: action, Result: result}
+	if err != nil {
+		entry.Result = AUDIT_FAILURE
+		entry.Error = err.Error()
+	}
+	line, _ := json.Marshal(entry)
+	if _, writeErr := m.config.Audit.Write(append(line, '\n')); writeErr != nil {
+		m.logger.Error("Cannot write audit entry", "entry", string(line), "error", writeErr)
+	}
+}
+
+// AuditPlan records the planned changes, e.g. for a dry run
+func (m *Mapper) AuditPlan() {
+	if !m.config.SkipRoles {
+		for _, roleName := range m.missingRoles {
+			m.audit(AUDIT_CREATE_ROLE, m.roleSourceGroups[roleName], roleName, AUDIT_PLANNED, nil)
+		}
+	}
+	if !m.config.SkipMappings {
+		for _, mapping := range m.sortedMappings() {
+			m.audit(AUDIT_CREATE_MAPPING, mapping.Path, mapping.Role, AUDIT_PLANNED, nil)
+		}
+	}
+	for _, mapping := range m.orphanedMappings {
+		m.audit(AUDIT_REMOVE_MAPPING, mapping.Path, mapping.Role, AUDIT_PLANNED, nil)
+	}
+	for _, roleName := range m.orphanedRoles {
+		m.audit(AUDIT_DELETE_ROLE, "", roleName, AUDIT_PLANNED, nil)
+	}
+}
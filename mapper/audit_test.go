@@ -0,0 +1,73 @@
+package mapper
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+func TestAudit(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	realm.AddGroup("/users")
+	realm.AddRole("users")
+	audit := &bytes.Buffer{}
+	config := testConfig(s)
+	config.Audit = audit
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m.AuditPlan()
+	s.Fail = failOn(http.MethodPost, "groups/*/role-mappings/realm", http.StatusForbidden)
+	if err := m.Apply(context.Background()); err == nil {
+		t.Fatal("expected the mappings to fail")
+	}
+	expected := []AuditEntry{
+		{Group: "/admins", Role: "admins", Action: AUDIT_CREATE_ROLE, Result: AUDIT_PLANNED},
+		{Group: "/admins", Role: "admins", Action: AUDIT_CREATE_MAPPING, Result: AUDIT_PLANNED},
+		{Group: "/users", Role: "users", Action: AUDIT_CREATE_MAPPING, Result: AUDIT_PLANNED},
+		{Group: "/admins", Role: "admins", Action: AUDIT_CREATE_ROLE, Result: AUDIT_SUCCESS},
+		{Group: "/admins", Role: "admins", Action: AUDIT_CREATE_MAPPING, Result: AUDIT_FAILURE},
+		{Group: "/users", Role: "users", Action: AUDIT_CREATE_MAPPING, Result: AUDIT_FAILURE},
+	}
+	entries := auditEntries(t, audit)
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+	}
+	for i, entry := range entries {
+		if entry.Group != expected[i].Group || entry.Role != expected[i].Role || entry.Action != expected[i].Action ||
+			entry.Result != expected[i].Result {
+			t.Errorf("entry %d: expected %+v, got %+v", i, expected[i], entry)
+		}
+		if entry.User != keycloaktest.ADMIN_USER || entry.Realm != TEST_REALM || entry.Time.IsZero() {
+			t.Errorf("entry %d: expected the user, the realm and the time, got %+v", i, entry)
+		}
+		if (entry.Result == AUDIT_FAILURE) != (entry.Error != "") {
+			t.Errorf("entry %d: expected the error of the failures only, got %+v", i, entry)
+		}
+	}
+}
+
+func TestAuditUserOfServiceAccount(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	audit := &bytes.Buffer{}
+	config := testConfig(s)
+	config.ClientID = keycloaktest.SERVICE_CLIENT
+	config.ClientSecret = keycloaktest.SERVICE_SECRET
+	config.Audit = audit
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m.AuditPlan()
+	for _, entry := range auditEntries(t, audit) {
+		if entry.User != keycloaktest.SERVICE_CLIENT {
+			t.Errorf("expected the client of the service account, got %+v", entry)
+		}
+	}
+}
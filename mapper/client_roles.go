@@ -0,0 +1,100 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/zemirco/keycloak"
+)
+
+type clientRepresentation struct {
+	ID       *string `json:"id,omitempty"`
+	ClientID *string `json:"clientId,omitempty"`
+}
+
+// resolveTargetClient looks up the internal ID of the client whose roles are mapped to the groups,
+// when Config.TargetClient is set
+func (m *Mapper) resolveTargetClient(ctx context.Context) error {
+	if m.config.TargetClient == "" {
+		return nil
+	}
+	var clients []*clientRepresentation
+	path := fmt.Sprintf("admin/realms/%s/clients?clientId=%s", m.config.Realm, url.QueryEscape(m.config.TargetClient))
+	if _, err := m.apiCall(ctx, http.MethodGet, path, nil, &clients); err != nil {
+		return fmt.Errorf("cannot read client %v: %w", m.config.TargetClient, err)
+	}
+	for _, c := range clients {
+		if c.ID != nil && c.ClientID != nil && *c.ClientID == m.config.TargetClient {
+			m.targetClientID = *c.ID
+			m.logger.Info("Found client", "client", *c.ClientID, "id", *c.ID)
+			return nil
+		}
+	}
+	return fmt.Errorf("provided client '%s' is not configured in realm %s", m.config.TargetClient, m.config.Realm)
+}
+
+// currentRoles returns the roles already mapped to the group: the roles of the target client if any,
+// otherwise the realm roles
+func (m *Mapper) currentRoles(g *keycloak.Group) []string {
+	if m.config.TargetClient != "" {
+		return g.ClientRoles[m.config.TargetClient]
+	}
+	return g.RealmRoles
+}
+
+// rolesListed tells whether the group returned by a group list includes its roles, like the full
+// representation does, otherwise the group must be read
+func (m *Mapper) rolesListed(g *keycloak.Group) bool {
+	if m.config.TargetClient != "" {
+		return g.ClientRoles != nil
+	}
+	return g.RealmRoles != nil
+}
+
+// rolesPath is the API path of the roles mapped to the groups: the roles of the target client if any,
+// otherwise the realm roles
+func (m *Mapper) rolesPath() string {
+	if m.config.TargetClient != "" {
+		return fmt.Sprintf("admin/realms/%s/clients/%s/roles", m.config.Realm, m.targetClientID)
+	}
+	return fmt.Sprintf("admin/realms/%s/roles", m.config.Realm)
+}
+
+// roleMappingsPath is the API path of the role mappings of the group, for the roles returned by rolesPath
+func (m *Mapper) roleMappingsPath(groupID string) string {
+	if m.config.TargetClient != "" {
+		return fmt.Sprintf("admin/realms/%s/groups/%s/role-mappings/clients/%s", m.config.Realm, groupID, m.targetClientID)
+	}
+	return fmt.Sprintf("admin/realms/%s/groups/%s/role-mappings/realm", m.config.Realm, groupID)
+}
+
+// groupRoles returns the names of the roles currently mapped to the group
+func (m *Mapper) groupRoles(ctx context.Context, groupID string) (map[string]bool, error) {
+	var roles []*keycloak.Role
+	if _, err := m.apiCall(ctx, http.MethodGet, m.roleMappingsPath(groupID), nil, &roles); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, role := range roles {
+		if role.Name != nil {
+			names[*role.Name] = true
+		}
+	}
+	return names, nil
+}
+
+func (m *Mapper) getClientRoleByName(ctx context.Context, name string) (*keycloak.Role, *http.Response, error) {
+	role := &keycloak.Role{}
+	res, err := m.apiCall(ctx, http.MethodGet, m.rolesPath()+"/"+url.PathEscape(name), nil, role)
+	return role, res, err
+}
+
+func (m *Mapper) createClientRole(ctx context.Context, role *keycloak.Role) (*http.Response, error) {
+	return m.apiCall(ctx, http.MethodPost, m.rolesPath(), role, nil)
+}
+
+func (m *Mapper) addClientRolesToGroup(ctx context.Context, groupID string, roles []*keycloak.Role) (*http.Response, error) {
+	return m.apiCall(ctx, http.MethodPost, m.roleMappingsPath(groupID), roles, nil)
+}
@@ -0,0 +1,108 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/zemirco/keycloak"
+)
+
+// addChildRole records that the roles of the sub-group must be composed by the roles of its parent group.
+// Both groups must be selected by the group filters
+func (m *Mapper) addChildRole(parentPath string, parent *keycloak.Group, childPath string, child *keycloak.Group) {
+	if !m.groupSelected(parentPath) || !m.groupSelected(childPath) {
+		return
+	}
+	for _, parentRole := range m.mappedRoleNames(parent, parentPath) {
+		for _, childRole := range m.mappedRoleNames(child, childPath) {
+			m.addCompositeRole(parentRole, childRole)
+		}
+	}
+}
+
+func (m *Mapper) addCompositeRole(parentRole string, childRole string) {
+	if parentRole == childRole {
+		return
+	}
+	for _, r := range m.roleChildren[parentRole] {
+		if r == childRole {
+			return
+		}
+	}
+	m.roleChildren[parentRole] = append(m.roleChildren[parentRole], childRole)
+}
+
+// prepareComposites compares the roles of the sub-groups with the composites of the role of their
+// parent group
+func (m *Mapper) prepareComposites(ctx context.Context) error {
+	for parentRole, children := range m.roleChildren {
+		composites := map[string]bool{}
+		if _, created := m.roleSourceGroups[parentRole]; !created {
+			var roles []*keycloak.Role
+			path := m.rolesPath() + "/" + url.PathEscape(parentRole) + "/composites"
+			res, err := m.apiCall(ctx, http.MethodGet, path, nil, &roles)
+			if err != nil && !isNotFound(res) {
+				return fmt.Errorf("cannot read composites of role %v: %w", parentRole, err)
+			}
+			for _, role := range roles {
+				if role.Name != nil {
+					composites[*role.Name] = true
+				}
+			}
+		}
+		for _, childRole := range children {
+			if !composites[childRole] {
+				m.missingComposites[parentRole] = append(m.missingComposites[parentRole], childRole)
+			}
+		}
+		sort.Strings(m.missingComposites[parentRole])
+	}
+	return nil
+}
+
+func (m *Mapper) printComposites(w io.Writer) {
+	if len(m.missingComposites) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "*** The following composite roles will be configured ***")
+	for _, parentRole := range m.sortedCompositeRoles() {
+		fmt.Fprintf(w, "Role %v composed of %v\n", parentRole, m.missingComposites[parentRole])
+	}
+}
+
+// sortedCompositeRoles returns the parent roles of the missing composites sorted by name, to print and
+// apply them always in the same order
+func (m *Mapper) sortedCompositeRoles() []string {
+	parentRoles := []string{}
+	for parentRole := range m.missingComposites {
+		parentRoles = append(parentRoles, parentRole)
+	}
+	sort.Strings(parentRoles)
+	return parentRoles
+}
+
+// createComposites adds the missing child roles to the composites of their parent role, once all
+// the roles are created
+func (m *Mapper) createComposites(ctx context.Context) error {
+	for _, parentRole := range m.sortedCompositeRoles() {
+		children := m.missingComposites[parentRole]
+		roles := []*keycloak.Role{}
+		for _, childRole := range children {
+			role, err := m.getExistingRole(ctx, childRole)
+			if err != nil {
+				return err
+			}
+			roles = append(roles, role)
+		}
+		m.logger.Info("Adding composite roles", "role", parentRole, "composites", children)
+		path := m.rolesPath() + "/" + url.PathEscape(parentRole) + "/composites"
+		if _, err := m.apiCall(ctx, http.MethodPost, path, roles, nil); err != nil {
+			return fmt.Errorf("cannot add composites to role %v: %w", parentRole, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package mapper
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCompositeRoles(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/parent/child1")
+	realm.AddGroup("/parent/child2/grandchild")
+	config := testConfig(s)
+	config.CompositeRoles = true
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"parent": "child1,child2", "child2": "grandchild"}
+	report := m.Report()
+	if len(report.MissingComposites) != len(expected) {
+		t.Errorf("expected the composites %v, got %v", expected, report.MissingComposites)
+	}
+	for role, composites := range expected {
+		if actual := strings.Join(report.MissingComposites[role], ","); actual != composites {
+			t.Errorf("expected role %s to be planned with composites %s, got %s", role, composites, actual)
+		}
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for role, composites := range expected {
+		if actual := strings.Join(realm.Composites(role), ","); actual != composites {
+			t.Errorf("expected role %s to be composed of %s, got %s", role, composites, actual)
+		}
+	}
+	for _, role := range []string{"child1", "grandchild"} {
+		if composites := realm.Composites(role); len(composites) != 0 {
+			t.Errorf("expected role %s not to be a composite, got %v", role, composites)
+		}
+	}
+}
+
+func TestCompositeRolesKeepsExistingComposites(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/parent/child1", "child1")
+	realm.AddGroup("/parent/child2", "child2")
+	realm.Group("/parent").RealmRoles = []string{"parent"}
+	for _, role := range []string{"parent", "child1", "child2"} {
+		realm.AddRole(role)
+	}
+	config := testConfig(s)
+	config.CompositeRoles = true
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Once composed, a second run has nothing to do
+	m = newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if composites := m.Report().MissingComposites; len(composites) != 0 {
+		t.Errorf("expected no missing composite, got %v", composites)
+	}
+	if count := s.Count(http.MethodPost, TEST_REALM, "roles/parent/composites"); count != 1 {
+		t.Errorf("expected the composites to be added once, got %d", count)
+	}
+}
@@ -0,0 +1,177 @@
+package mapper
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/zemirco/keycloak"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const MASTER_REALM = "master"
+const DEFAULT_TOKEN_CLIENT_ID = "admin-cli"
+
+// Connect logs in to Config.AuthRealm of the Keycloak server and returns the client of the admin API.
+// The service account of Config.ClientID is used when Config.ClientSecret is set, otherwise the admin user
+func Connect(ctx context.Context, config Config) (*keycloak.Keycloak, error) {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	authRealm := config.AuthRealm
+	if authRealm == "" {
+		authRealm = MASTER_REALM
+	}
+	if parsed, err := url.Parse(config.baseURL()); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid Keycloak URL %s: must be like https://host[:port][/context-path]", config.baseURL())
+	}
+	tokenURL := config.baseURL() + "/realms/" + url.PathEscape(authRealm) + "/protocol/openid-connect/token"
+	logger.Debug("Keycloak URLs", "admin", config.baseURL()+"/admin", "token", tokenURL)
+	baseClient, err := newHTTPClient(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	// The oauth2 package sends the token requests with the client found in the context, and the
+	// Keycloak requests are authenticated by wrapping its transport
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, baseClient)
+
+	login := passwordLogin(ctx, config, tokenURL)
+	if config.ClientSecret != "" {
+		login = clientCredentialsLogin(ctx, config, tokenURL)
+	}
+	transport, err := newReloginTransport(baseClient.Transport, login, logger)
+	if loginRejected(err) {
+		return nil, withKind(ErrAuthFailed, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: &errorBodyTransport{base: transport}, Timeout: config.RequestTimeout}
+
+	k, err := keycloak.NewKeycloak(client, config.baseURL()+"/")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create Keycloak client: %w", err)
+	}
+	logger.Info("Logged in", "server", config.Server, "realm", authRealm)
+	return k, nil
+}
+
+// baseURL is the server URL including the optional context path, like
+// http://localhost:8080/auth for legacy distributions
+func (config Config) baseURL() string {
+	return strings.TrimSuffix(config.Server, "/") + config.BasePath
+}
+
+// passwordLogin authenticates the admin user with the password grant of Config.TokenClientID,
+// admin-cli by default. The token source refreshes the token whenever it expires
+func passwordLogin(ctx context.Context, config Config, tokenURL string) func() (oauth2.TokenSource, error) {
+	clientID := config.TokenClientID
+	if clientID == "" {
+		clientID = DEFAULT_TOKEN_CLIENT_ID
+	}
+	oauthConfig := oauth2.Config{
+		ClientID: clientID,
+		Endpoint: oauth2.Endpoint{
+			TokenURL: tokenURL,
+		},
+	}
+
+	return func() (oauth2.TokenSource, error) {
+		token, err := oauthConfig.PasswordCredentialsToken(ctx, config.User, config.Password)
+		if err != nil {
+			return nil, fmt.Errorf("cannot login to %v as %v: %w", config.Server, config.User, err)
+		}
+		return oauthConfig.TokenSource(ctx, token), nil
+	}
+}
+
+// clientCredentialsLogin authenticates the service account of a confidential client with the
+// client_credentials grant. The token source fetches a new token whenever the current one expires
+func clientCredentialsLogin(ctx context.Context, config Config, tokenURL string) func() (oauth2.TokenSource, error) {
+	oauthConfig := clientcredentials.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		TokenURL:     tokenURL,
+	}
+
+	return func() (oauth2.TokenSource, error) {
+		token, err := oauthConfig.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot login to %v as client %v: %w", config.Server, config.ClientID, err)
+		}
+		return oauth2.ReuseTokenSource(token, oauthConfig.TokenSource(ctx)), nil
+	}
+}
+
+// newHTTPClient returns the client used for both the token requests and the Keycloak API calls.
+// The server certificate is verified with the system roots, plus the CA bundle of Config.TLSCAFile if any.
+// The default transport goes through the proxy of the environment variables, unless Config.Proxy is set
+func newHTTPClient(config Config, logger *slog.Logger) (*http.Client, error) {
+	client := &http.Client{Timeout: config.RequestTimeout}
+	if config.TLSCAFile == "" && !config.TLSInsecure && config.Proxy == "" {
+		return client, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	client.Transport = transport
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %s: %w", config.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if config.TLSCAFile == "" && !config.TLSInsecure {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if config.TLSCAFile != "" {
+		pem, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file %s: %w", config.TLSCAFile, err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificate found in CA file %s", config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if config.TLSInsecure {
+		logger.Warn("TLS certificate verification is disabled")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return client, nil
+}
+
+// ListRealms returns the names of all the realms of the server
+func ListRealms(ctx context.Context, client *keycloak.Keycloak, config Config) ([]string, error) {
+	m := New(client, config)
+	var realms []*keycloak.Realm
+	_, err := m.retry(ctx, func() (res *http.Response, err error) {
+		realms, res, err = client.Realms.List(ctx)
+		return res, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list realms: %w", err)
+	}
+	names := []string{}
+	for _, realm := range realms {
+		if realm.Realm != nil {
+			names = append(names, *realm.Realm)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,182 @@
+package mapper
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+// testLogger discards the logs of the mappers under test
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// TEST_REALM is the realm created by newTestServer
+const TEST_REALM = "test"
+
+// newTestServer returns a Keycloak with the empty realm TEST_REALM
+func newTestServer(t *testing.T) (*keycloaktest.Server, *keycloaktest.Realm) {
+	s := keycloaktest.NewServer(t)
+	return s, s.AddRealm(TEST_REALM)
+}
+
+// testConfig returns the config logging in to the server as the admin user, for TEST_REALM
+func testConfig(s *keycloaktest.Server) Config {
+	return Config{Server: s.URL, BasePath: s.BasePath, User: keycloaktest.ADMIN_USER, Password: keycloaktest.ADMIN_PASSWORD,
+		Realm: TEST_REALM, Logger: testLogger}
+}
+
+// newTestMapper connects to the server with the config and returns its mapper
+func newTestMapper(t *testing.T, s *keycloaktest.Server, config Config) *Mapper {
+	t.Helper()
+	client, err := Connect(context.Background(), config)
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	return New(client, config)
+}
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		server   string
+		basePath string
+		expected string
+	}{
+		{"http://localhost:8080", "", "http://localhost:8080"},
+		{"http://localhost:8080/", "", "http://localhost:8080"},
+		{"http://localhost:8080", "/auth", "http://localhost:8080/auth"},
+		{"https://sso.example.com/", "/identity", "https://sso.example.com/identity"},
+	}
+	for _, test := range tests {
+		config := Config{Server: test.server, BasePath: test.basePath}
+		if actual := config.baseURL(); actual != test.expected {
+			t.Errorf("baseURL of %s and %q: expected %s, got %s", test.server, test.basePath, test.expected, actual)
+		}
+	}
+}
+
+func TestConnectWithBasePath(t *testing.T) {
+	for _, basePath := range []string{"", "/auth", "/identity"} {
+		t.Run("base path "+basePath, func(t *testing.T) {
+			s, _ := newTestServer(t)
+			s.BasePath = basePath
+			client, err := Connect(context.Background(), testConfig(s))
+			if err != nil {
+				t.Fatalf("cannot connect: %v", err)
+			}
+			if !strings.HasSuffix(client.BaseURL.Path, basePath+"/") {
+				t.Errorf("expected the admin API under %s/, got %s", basePath, client.BaseURL)
+			}
+			realms, err := ListRealms(context.Background(), client, testConfig(s))
+			if err != nil {
+				t.Fatalf("cannot list realms: %v", err)
+			}
+			if strings.Join(realms, ",") != "master,test" {
+				t.Errorf("expected realms master and test, got %v", realms)
+			}
+			if s.Count("POST", "", "realms/master/protocol/openid-connect/token") != 1 {
+				t.Errorf("expected a login under %s, got requests %v", basePath, s.Requests())
+			}
+		})
+	}
+}
+
+func TestConnectWithWrongBasePath(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.BasePath = "/auth"
+	config := testConfig(s)
+	config.BasePath = ""
+	if _, err := Connect(context.Background(), config); err == nil {
+		t.Fatal("expected the login to fail without the base path of the server")
+	}
+}
+
+func TestConnectRejectsInvalidURL(t *testing.T) {
+	for _, server := range []string{"localhost:8080", "ftp://localhost", "http://", "://"} {
+		_, err := Connect(context.Background(), Config{Server: server, Logger: testLogger})
+		if err == nil || !strings.Contains(err.Error(), "invalid Keycloak URL") {
+			t.Errorf("expected an invalid URL error for %s, got %v", server, err)
+		}
+	}
+}
+
+func TestTokenClientID(t *testing.T) {
+	tests := []struct {
+		name          string
+		clientID      string
+		publicClients []string
+		err           bool
+	}{
+		{"default", "", []string{"admin-cli"}, false},
+		{"configured", "automation", []string{"automation"}, false},
+		// The hardened realms disable admin-cli
+		{"default disabled", "", []string{"automation"}, true},
+		{"unknown", "automation", []string{"admin-cli"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, _ := newTestServer(t)
+			s.PublicClients = map[string]bool{}
+			for _, clientID := range test.publicClients {
+				s.PublicClients[clientID] = true
+			}
+			config := testConfig(s)
+			config.TokenClientID = test.clientID
+			_, err := Connect(context.Background(), config)
+			if (err != nil) != test.err {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestAuthRealm(t *testing.T) {
+	s, realm := newTestServer(t)
+	s.AddRealm("ops")
+	realm.AddGroup("/admins")
+	config := testConfig(s)
+	config.AuthRealm = "ops"
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s.Count("POST", "", "realms/ops/protocol/openid-connect/token") != 1 ||
+		s.Count("POST", "", "realms/master/protocol/openid-connect/token") != 0 {
+		t.Errorf("expected a single login in realm ops, got requests %v", s.Requests())
+	}
+	if s.Count("GET", TEST_REALM, "groups") == 0 || s.Count("GET", "ops", "groups") != 0 {
+		t.Errorf("expected the groups of realm %s to be read, got requests %v", TEST_REALM, s.Requests())
+	}
+
+	config.AuthRealm = "missing"
+	if _, err := Connect(context.Background(), config); err == nil {
+		t.Error("expected the login in a missing realm to fail")
+	}
+}
+
+func TestContextPath(t *testing.T) {
+	s, realm := newTestServer(t)
+	s.BasePath = "/identity"
+	realm.AddGroup("/admins")
+	var logs bytes.Buffer
+	config := testConfig(s)
+	config.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if roles := strings.Join(realm.Group("/admins").RealmRoles, ","); roles != "admins" {
+		t.Errorf("expected group /admins to be mapped under the context path, got %s", roles)
+	}
+	for _, url := range []string{"admin=" + s.URL + "/identity/admin", "token=" + s.URL + "/identity/realms/master/protocol/openid-connect/token"} {
+		if !strings.Contains(logs.String(), url) {
+			t.Errorf("expected the URL %s to be logged, got %s", url, logs.String())
+		}
+	}
+}
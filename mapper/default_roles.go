@@ -0,0 +1,44 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/zemirco/keycloak"
+)
+
+// realmDefaultRole is the part of the realm representation with the composite role granted to all the users
+type realmDefaultRole struct {
+	DefaultRole *keycloak.Role `json:"defaultRole,omitempty"`
+}
+
+// containerID is the ID of the container of the created roles: the target client if any, otherwise the realm
+func (m *Mapper) containerID() string {
+	if m.config.TargetClient != "" {
+		return m.targetClientID
+	}
+	return m.realmID
+}
+
+// addToDefaultRoles adds the created role to the default roles of the realm, with Config.DefaultRoles
+func (m *Mapper) addToDefaultRoles(ctx context.Context, name string) error {
+	var realm realmDefaultRole
+	if _, err := m.apiCall(ctx, http.MethodGet, fmt.Sprintf("admin/realms/%s", m.config.Realm), nil, &realm); err != nil {
+		return fmt.Errorf("cannot read the default roles of realm %s: %w", m.config.Realm, err)
+	}
+	if realm.DefaultRole == nil || realm.DefaultRole.ID == nil {
+		return fmt.Errorf("realm %s has no default roles, Keycloak 13 or later is needed", m.config.Realm)
+	}
+	role, err := m.getExistingRole(ctx, name)
+	if err != nil {
+		return err
+	}
+	m.logger.Info("Adding role to the default roles", "role", name, "realm", m.config.Realm)
+	path := fmt.Sprintf("admin/realms/%s/roles-by-id/%s/composites", m.config.Realm, url.PathEscape(*realm.DefaultRole.ID))
+	if _, err := m.apiCall(ctx, http.MethodPost, path, []*keycloak.Role{role}, nil); err != nil {
+		return fmt.Errorf("cannot add role %v to the default roles of realm %s: %w", name, m.config.Realm, err)
+	}
+	return nil
+}
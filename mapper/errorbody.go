@@ -0,0 +1,60 @@
+package mapper
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// MAX_ERROR_BODY bounds the size of the response bodies added to the errors
+const MAX_ERROR_BODY = 4096
+
+// errorBody is the body of a failed response, kept in memory so that it can still be read once the
+// keycloak client closed the response
+type errorBody struct {
+	*bytes.Reader
+	data []byte
+}
+
+func (b *errorBody) Close() error {
+	return nil
+}
+
+// errorBodyTransport keeps the body of the failed responses, as the error message of Keycloak, like
+// {"errorMessage":"Role with name admins already exists"}, often explains the failure
+type errorBodyTransport struct {
+	base http.RoundTripper
+}
+
+func (t *errorBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil || res.StatusCode < http.StatusBadRequest {
+		return res, err
+	}
+	data, _ := io.ReadAll(io.LimitReader(res.Body, MAX_ERROR_BODY))
+	res.Body.Close()
+	res.Body = &errorBody{Reader: bytes.NewReader(data), data: data}
+	return res, nil
+}
+
+// withErrorBody adds the HTTP status and the body of the failed response, if any, to the error
+func (m *Mapper) withErrorBody(res *http.Response, err error) error {
+	if err == nil || res == nil {
+		return err
+	}
+	body, ok := res.Body.(*errorBody)
+	if !ok {
+		return err
+	}
+	message := strings.TrimSpace(string(body.data))
+	if res.Request != nil {
+		m.logger.Debug("API call failed", "method", res.Request.Method, "url", res.Request.URL.String(),
+			"status", res.StatusCode, "body", message)
+	}
+	if message == "" {
+		return fmt.Errorf("%w (HTTP %d)", err, res.StatusCode)
+	}
+	return fmt.Errorf("%w (HTTP %d: %s)", err, res.StatusCode, message)
+}
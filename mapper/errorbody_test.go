@@ -0,0 +1,65 @@
+package mapper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorBody(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	var logs bytes.Buffer
+	config := testConfig(s)
+	config.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Fail = failOn(http.MethodPost, "roles", http.StatusBadRequest)
+	err := m.Apply(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `(HTTP 400: {"errorMessage":"injected failure"})`) {
+		t.Fatalf("expected the status and the body of the response, got %v", err)
+	}
+	if !strings.Contains(logs.String(), "status=400") || !strings.Contains(logs.String(), "injected failure") {
+		t.Errorf("expected the failed call to be logged, got %s", logs.String())
+	}
+}
+
+func TestErrorBodyTruncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, strings.Repeat("x", 2*MAX_ERROR_BODY), http.StatusForbidden)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &errorBodyTransport{base: http.DefaultTransport}}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(nil, Config{Logger: testLogger})
+	err = m.withErrorBody(res, errors.New("call failed"))
+	if !strings.Contains(err.Error(), "(HTTP 403: xxx") || len(err.Error()) > MAX_ERROR_BODY+100 {
+		t.Errorf("expected the body to be truncated, got %d bytes", len(err.Error()))
+	}
+}
+
+func TestErrorWithoutBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &errorBodyTransport{base: http.DefaultTransport}}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(nil, Config{Logger: testLogger})
+	if err := m.withErrorBody(res, errors.New("call failed")); !strings.HasSuffix(err.Error(), "(HTTP 502)") {
+		t.Errorf("expected the status only, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package mapper
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// The kinds of the errors returned by the mapper, to tell them apart with errors.Is. The errors keep their
+// message and still wrap their cause, like the error of the keycloak client
+var (
+	// ErrRealmNotFound is returned by Plan, Check, ListGroups, LoadPlan and LoadRollback when Keycloak answers
+	// 404 to the read of the realm, or a realm without ID. The other failures, like a 403, are not of this kind
+	ErrRealmNotFound = errors.New("realm not found")
+	// ErrAuthFailed is returned by Connect when the token endpoint rejects the credentials, with a 401 or a
+	// 400 invalid_grant. The network errors and the other answers of the token endpoint are not of this kind
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrRoleCreateFailed is returned by Apply when a missing role cannot be created
+	ErrRoleCreateFailed = errors.New("role creation failed")
+	// ErrMappingFailed is returned by Apply when some missing mappings cannot be created
+	ErrMappingFailed = errors.New("mapping failed")
+)
+
+// kindError is an error of the given kind, with the message of the wrapped error
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// withKind marks the error with one of the Err* kinds, nil stays nil
+func withKind(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// loginRejected tells whether the login failed because the token endpoint rejected the credentials
+func loginRejected(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) || retrieveErr.Response == nil {
+		return false
+	}
+	switch retrieveErr.Response.StatusCode {
+	case http.StatusUnauthorized:
+		return true
+	case http.StatusBadRequest:
+		return retrieveErr.ErrorCode == "invalid_grant"
+	}
+	return false
+}
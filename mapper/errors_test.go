@@ -0,0 +1,129 @@
+package mapper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTokenServer returns a token endpoint answering every login with the status and the OAuth2 error code
+func newTokenServer(t *testing.T, status int, code string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":"` + code + `","error_description":"rejected"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestErrAuthFailed(t *testing.T) {
+	s, _ := newTestServer(t)
+	closed := httptest.NewServer(nil)
+	closed.Close()
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		kind   bool
+	}{
+		{"wrong password", func(config *Config) { config.Password = "wrong" }, true},
+		{"wrong secret", func(config *Config) { config.ClientID, config.ClientSecret = "group2role", "wrong" }, true},
+		{"invalid grant", func(config *Config) { config.Server = newTokenServer(t, http.StatusBadRequest, "invalid_grant").URL }, true},
+		{"invalid request", func(config *Config) { config.Server = newTokenServer(t, http.StatusBadRequest, "invalid_request").URL }, false},
+		{"server error", func(config *Config) {
+			config.Server = newTokenServer(t, http.StatusInternalServerError, "server_error").URL
+		}, false},
+		{"unknown auth realm", func(config *Config) { config.AuthRealm = "missing" }, false},
+		{"connection refused", func(config *Config) { config.Server = closed.URL }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig(s)
+			test.change(&config)
+			_, err := Connect(context.Background(), config)
+			if err == nil {
+				t.Fatal("expected the login to fail")
+			}
+			if errors.Is(err, ErrAuthFailed) != test.kind {
+				t.Errorf("expected errors.Is(ErrAuthFailed) %v, got %v", test.kind, err)
+			}
+		})
+	}
+}
+
+func TestErrRealmNotFound(t *testing.T) {
+	tests := []struct {
+		name   string
+		realm  string
+		status int
+		kind   bool
+	}{
+		{"missing", "missing", 0, true},
+		{"forbidden", TEST_REALM, http.StatusForbidden, false},
+		{"server error", TEST_REALM, http.StatusInternalServerError, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, _ := newTestServer(t)
+			config := testConfig(s)
+			config.Realm = test.realm
+			m := newTestMapper(t, s, config)
+			if test.status != 0 {
+				s.Fail = func(method string, path string) int {
+					if method == http.MethodGet && path == "admin/realms/"+TEST_REALM {
+						return test.status
+					}
+					return 0
+				}
+			}
+			calls := map[string]func() error{
+				"Plan":  func() error { return m.Plan(context.Background()) },
+				"Check": func() error { return m.Check(context.Background()) },
+				"ListGroups": func() error {
+					_, err := m.ListGroups(context.Background())
+					return err
+				},
+				"LoadPlan":     func() error { return m.LoadPlan(context.Background(), Report{Realm: test.realm}) },
+				"LoadRollback": func() error { return m.LoadRollback(context.Background(), Rollback{Realm: test.realm}) },
+			}
+			for name, call := range calls {
+				err := call()
+				if err == nil {
+					t.Fatalf("%s: expected the read of the realm to fail", name)
+				}
+				if errors.Is(err, ErrRealmNotFound) != test.kind {
+					t.Errorf("%s: expected errors.Is(ErrRealmNotFound) %v, got %v", name, test.kind, err)
+				}
+			}
+		})
+	}
+}
+
+func TestErrRoleCreateFailed(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Fail = failOn(http.MethodPost, "roles", http.StatusForbidden)
+	if err := m.Apply(context.Background()); !errors.Is(err, ErrRoleCreateFailed) || errors.Is(err, ErrAuthFailed) {
+		t.Errorf("expected a role creation error, got %v", err)
+	}
+}
+
+func TestErrMappingFailed(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	realm.AddRole("admins")
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Fail = failOn(http.MethodPost, "groups/*/role-mappings/realm", http.StatusInternalServerError)
+	if err := m.Apply(context.Background()); !errors.Is(err, ErrMappingFailed) || errors.Is(err, ErrRoleCreateFailed) {
+		t.Errorf("expected a mapping error, got %v", err)
+	}
+}
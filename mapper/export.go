@@ -0,0 +1,269 @@
+package mapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zemirco/keycloak"
+)
+
+// EXPORT_BASE_URL is the base URL of the client returned by ConnectExport, which never reaches the network
+const EXPORT_BASE_URL = "http://realm-export/"
+
+// realmExport is the part of a realm export file, as written by the Keycloak export, used to plan the changes
+type realmExport struct {
+	ID            string            `json:"id"`
+	Realm         string            `json:"realm"`
+	Groups        []*keycloak.Group `json:"groups"`
+	DefaultGroups []string          `json:"defaultGroups"`
+	// Users are only exported on demand, with the paths of their groups
+	Users   []*exportUser           `json:"users"`
+	Clients []*clientRepresentation `json:"clients"`
+	Roles   struct {
+		Realm  []*exportRole            `json:"realm"`
+		Client map[string][]*exportRole `json:"client"`
+	} `json:"roles"`
+}
+
+// exportRole is a role of the export, with the names of its composites
+type exportRole struct {
+	keycloak.Role
+	Composites *struct {
+		Realm  []string            `json:"realm"`
+		Client map[string][]string `json:"client"`
+	} `json:"composites,omitempty"`
+}
+
+type exportUser struct {
+	ID       *string  `json:"id,omitempty"`
+	Username *string  `json:"username,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// exportTransport answers the read-only admin API calls of the mapper from a realm export, so that the
+// plan is computed offline by the same code as with a live server. Any change is refused
+type exportTransport struct {
+	export   *realmExport
+	groups   map[string]*keycloak.Group
+	paths    map[string]*keycloak.Group
+	clientID map[string]string
+}
+
+// ConnectExport returns a client of the admin API serving the realm export file, and the name of the
+// exported realm. The client only supports the calls needed to compute the plan
+func ConnectExport(fileName string) (*keycloak.Keycloak, string, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot read realm export %s: %w", fileName, err)
+	}
+	export := &realmExport{}
+	if err := json.Unmarshal(content, export); err != nil {
+		return nil, "", fmt.Errorf("cannot parse realm export %s: %w", fileName, err)
+	}
+	if export.Realm == "" {
+		return nil, "", fmt.Errorf("no realm in realm export %s", fileName)
+	}
+	if export.ID == "" {
+		export.ID = export.Realm
+	}
+	t := &exportTransport{export: export, groups: map[string]*keycloak.Group{}, paths: map[string]*keycloak.Group{},
+		clientID: map[string]string{}}
+	t.indexGroups(export.Groups, "")
+	for _, c := range export.Clients {
+		if c.ID != nil && c.ClientID != nil {
+			t.clientID[*c.ID] = *c.ClientID
+		}
+	}
+	k, err := keycloak.NewKeycloak(&http.Client{Transport: t}, EXPORT_BASE_URL)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot create Keycloak client: %w", err)
+	}
+	return k, export.Realm, nil
+}
+
+// indexGroups indexes the groups by ID and by path, filling the paths missing from older exports
+func (t *exportTransport) indexGroups(groups []*keycloak.Group, parentPath string) {
+	for _, g := range groups {
+		if g == nil || g.Name == nil || g.ID == nil {
+			continue
+		}
+		groupPath := parentPath + "/" + *g.Name
+		g.Path = &groupPath
+		t.groups[*g.ID] = g
+		t.paths[groupPath] = g
+		t.indexGroups(g.SubGroups, groupPath)
+	}
+}
+
+func (t *exportTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return nil, fmt.Errorf("cannot %s %s: the realm export is read-only", req.Method, req.URL.Path)
+	}
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segments[i] = unescaped
+		}
+	}
+	if len(segments) < 2 || segments[0] != "admin" || segments[1] != "realms" {
+		return t.respond(req, http.StatusNotFound, nil)
+	}
+	if len(segments) == 2 {
+		return t.respond(req, http.StatusOK, []*keycloak.Realm{t.realm()})
+	}
+	if segments[2] != t.export.Realm {
+		return t.respond(req, http.StatusNotFound, nil)
+	}
+	status, body := t.route(segments[3:], req.URL.Query())
+	return t.respond(req, status, body)
+}
+
+// route returns the answer to the API call on the given path of the realm, like groups/<id>/children
+func (t *exportTransport) route(path []string, query url.Values) (int, interface{}) {
+	if len(path) == 0 {
+		return http.StatusOK, t.realm()
+	}
+	switch path[0] {
+	case "groups":
+		if len(path) == 1 {
+			return http.StatusOK, page(t.export.Groups, query)
+		}
+		group, found := t.groups[path[1]]
+		if !found {
+			return http.StatusNotFound, nil
+		}
+		if len(path) == 2 {
+			return http.StatusOK, group
+		}
+		if len(path) == 3 && path[2] == "children" {
+			return http.StatusOK, page(group.SubGroups, query)
+		}
+		if len(path) == 3 && path[2] == "members" {
+			members := []*exportUser{}
+			for _, user := range t.export.Users {
+				for _, groupPath := range user.Groups {
+					if groupPath == *group.Path {
+						members = append(members, user)
+						break
+					}
+				}
+			}
+			return http.StatusOK, page(members, query)
+		}
+	case "group-by-path":
+		if group, found := t.paths["/"+strings.Join(path[1:], "/")]; found {
+			return http.StatusOK, group
+		}
+	case "default-groups":
+		groups := []*keycloak.Group{}
+		for _, groupPath := range t.export.DefaultGroups {
+			if group, found := t.paths[groupPath]; found {
+				groups = append(groups, group)
+			}
+		}
+		return http.StatusOK, groups
+	case "roles":
+		return t.routeRoles(t.export.Roles.Realm, path[1:], query)
+	case "clients":
+		if len(path) == 1 {
+			clients := []*clientRepresentation{}
+			for _, c := range t.export.Clients {
+				if c.ClientID != nil && (query.Get("clientId") == "" || *c.ClientID == query.Get("clientId")) {
+					clients = append(clients, c)
+				}
+			}
+			return http.StatusOK, clients
+		}
+		if len(path) >= 3 && path[2] == "roles" {
+			return t.routeRoles(t.export.Roles.Client[t.clientID[path[1]]], path[3:], query)
+		}
+	}
+	return http.StatusNotFound, nil
+}
+
+// routeRoles answers the calls on the given roles: the list, a role by name, or its composites
+func (t *exportTransport) routeRoles(roles []*exportRole, path []string, query url.Values) (int, interface{}) {
+	if len(path) == 0 {
+		list := []*keycloak.Role{}
+		for _, role := range roles {
+			list = append(list, &role.Role)
+		}
+		return http.StatusOK, page(list, query)
+	}
+	role := findExportRole(roles, path[0])
+	if role == nil {
+		return http.StatusNotFound, nil
+	}
+	if len(path) == 1 {
+		return http.StatusOK, &role.Role
+	}
+	if len(path) == 2 && path[1] == "composites" {
+		composites := []*keycloak.Role{}
+		if role.Composites != nil {
+			names := role.Composites.Realm
+			pool := t.export.Roles.Realm
+			if role.ClientRole != nil && *role.ClientRole {
+				// The composites of a client role are looked up among the roles of the same client
+				for clientID, clientRoles := range t.export.Roles.Client {
+					if findExportRole(clientRoles, *role.Name) == role {
+						names = role.Composites.Client[clientID]
+						pool = clientRoles
+					}
+				}
+			}
+			for _, name := range names {
+				if composite := findExportRole(pool, name); composite != nil {
+					composites = append(composites, &composite.Role)
+				}
+			}
+		}
+		return http.StatusOK, composites
+	}
+	return http.StatusNotFound, nil
+}
+
+func (t *exportTransport) realm() *keycloak.Realm {
+	return &keycloak.Realm{ID: &t.export.ID, Realm: &t.export.Realm}
+}
+
+func (t *exportTransport) respond(req *http.Request, status int, body interface{}) (*http.Response, error) {
+	content := []byte{}
+	if body != nil {
+		var err error
+		if content, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	return &http.Response{StatusCode: status, Status: fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header: http.Header{"Content-Type": []string{"application/json"}}, Body: io.NopCloser(bytes.NewReader(content)),
+		ContentLength: int64(len(content)), Request: req}, nil
+}
+
+func findExportRole(roles []*exportRole, name string) *exportRole {
+	for _, role := range roles {
+		if role.Name != nil && *role.Name == name {
+			return role
+		}
+	}
+	return nil
+}
+
+// page returns the items of the page selected by the first and max query parameters, all the items by default
+func page[T any](items []T, query url.Values) []T {
+	first, _ := strconv.Atoi(query.Get("first"))
+	if first < 0 || first >= len(items) {
+		return []T{}
+	}
+	items = items[first:]
+	if max, err := strconv.Atoi(query.Get("max")); err == nil && max >= 0 && max < len(items) {
+		items = items[:max]
+	}
+	return items
+}
@@ -0,0 +1,84 @@
+package mapper
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TEST_EXPORT is a realm export with the groups /admins, /eng, /eng/backend and /eng/viewers
+var TEST_EXPORT = filepath.Join("testdata", "realm-export.json")
+
+// newExportMapper returns the mapper of the realm export
+func newExportMapper(t *testing.T, fileName string, config Config) *Mapper {
+	t.Helper()
+	client, realm, err := ConnectExport(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Realm = realm
+	config.Logger = testLogger
+	return New(client, config)
+}
+
+func TestPlanFromExport(t *testing.T) {
+	tests := []struct {
+		targetClient string
+		missingRoles string
+		mappings     string
+	}{
+		{"", "backend,eng,viewers", "/eng,/eng/backend,/eng/viewers"},
+		{"web", "admins,backend,eng", "/admins,/eng,/eng/backend"},
+	}
+	for _, test := range tests {
+		t.Run("client "+test.targetClient, func(t *testing.T) {
+			m := newExportMapper(t, TEST_EXPORT, Config{TargetClient: test.targetClient})
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			report := m.Report()
+			if report.Realm != "acme" {
+				t.Errorf("expected the realm of the export, got %s", report.Realm)
+			}
+			if missing := strings.Join(report.MissingRoles, ","); missing != test.missingRoles {
+				t.Errorf("expected the missing roles %s, got %s", test.missingRoles, missing)
+			}
+			if paths := plannedPaths(m); paths != test.mappings {
+				t.Errorf("expected the mappings of %s, got %s", test.mappings, paths)
+			}
+		})
+	}
+}
+
+func TestApplyFromExportRefused(t *testing.T) {
+	m := newExportMapper(t, TEST_EXPORT, Config{})
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err == nil || !strings.Contains(err.Error(), "the realm export is read-only") {
+		t.Errorf("expected the changes to be refused, got %v", err)
+	}
+}
+
+func TestInvalidExport(t *testing.T) {
+	tests := map[string]string{
+		"":               "cannot parse realm export",
+		"[]":             "cannot parse realm export",
+		`{"groups": []}`: "no realm in realm export",
+	}
+	for content, expected := range tests {
+		fileName := filepath.Join(t.TempDir(), "export.json")
+		if err := os.WriteFile(fileName, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := ConnectExport(fileName); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected error %q, got %v", content, expected, err)
+		}
+	}
+	if _, _, err := ConnectExport(filepath.Join(t.TempDir(), "missing.json")); err == nil ||
+		!strings.Contains(err.Error(), "cannot read realm export") {
+		t.Errorf("expected the missing export to be reported, got %v", err)
+	}
+}
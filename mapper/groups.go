@@ -0,0 +1,190 @@
+package mapper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/zemirco/keycloak"
+)
+
+// validGroup tells whether the group has a name and an ID. Malformed groups are skipped, with their
+// raw content logged to investigate them
+func (m *Mapper) validGroup(group *keycloak.Group) bool {
+	if group != nil && group.Name != nil && group.ID != nil {
+		return true
+	}
+	raw, _ := json.Marshal(group)
+	m.warn("Skipping group without name or ID", "group", string(raw))
+	return false
+}
+
+// groupSelected tells whether the group with the given full path, like /parent/child, must be mapped.
+// A group matching both Config.GroupInclude and Config.GroupExclude is excluded
+func (m *Mapper) groupSelected(groupPath string) bool {
+	if m.config.ClientGroupsPath != "" && m.config.TargetClient == "" &&
+		(groupPath == m.config.ClientGroupsPath || strings.HasPrefix(groupPath, m.config.ClientGroupsPath+"/")) {
+		return false
+	}
+	for _, pattern := range m.config.GroupExclude {
+		if matched, _ := path.Match(pattern, groupPath); matched {
+			return false
+		}
+	}
+	if len(m.config.GroupInclude) == 0 {
+		return true
+	}
+	for _, pattern := range m.config.GroupInclude {
+		if matched, _ := path.Match(pattern, groupPath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeGroup returns the group the run is restricted to, with its full path. Config.GroupPath is resolved
+// with the group-by-path API
+func (m *Mapper) scopeGroup(ctx context.Context) (*keycloak.Group, string, error) {
+	group := &keycloak.Group{}
+	if m.config.GroupID != "" {
+		_, err := m.retry(ctx, func() (res *http.Response, err error) {
+			group, res, err = m.client.Groups.Get(ctx, m.config.Realm, m.config.GroupID)
+			return res, err
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot read group %v: %w", m.config.GroupID, err)
+		}
+	} else if _, err := m.groupByPath(ctx, m.config.GroupPath, group); err != nil {
+		return nil, "", fmt.Errorf("cannot read group %v: %w", m.config.GroupPath, err)
+	}
+	if !m.validGroup(group) || group.Path == nil {
+		return nil, "", fmt.Errorf("cannot read the path of group %v%v", m.config.GroupPath, m.config.GroupID)
+	}
+	m.logger.Info("Restricting the run to group", "path", *group.Path, "id", *group.ID)
+	return group, *group.Path, nil
+}
+
+// groupByPath reads the group with the given full path, like /parent/child, with the group-by-path API
+func (m *Mapper) groupByPath(ctx context.Context, groupPath string, group *keycloak.Group) (*http.Response, error) {
+	escaped := []string{}
+	for _, segment := range strings.Split(strings.Trim(groupPath, "/"), "/") {
+		escaped = append(escaped, url.PathEscape(segment))
+	}
+	apiPath := fmt.Sprintf("admin/realms/%s/group-by-path/%s", m.config.Realm, strings.Join(escaped, "/"))
+	return m.apiCall(ctx, http.MethodGet, apiPath, nil, group)
+}
+
+// groupListTasks returns the groups of Config.GroupPaths, with their sub-groups when Config.GroupPathsRecursive
+// is set. The groups that don't exist are skipped with a warning
+func (m *Mapper) groupListTasks(ctx context.Context) ([]groupTask, error) {
+	tasks := []groupTask{}
+	for _, groupPath := range m.config.GroupPaths {
+		group := &keycloak.Group{}
+		res, err := m.groupByPath(ctx, groupPath, group)
+		if isNotFound(res) {
+			m.warn("Skipping missing group of the group list", "path", groupPath)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read group %v: %w", groupPath, err)
+		}
+		if !m.validGroup(group) || group.Path == nil {
+			continue
+		}
+		if !m.config.GroupPathsRecursive {
+			if err := m.addGroupTask(group, *group.Path, &tasks); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		parentPath := path.Dir(*group.Path)
+		if parentPath == "/" {
+			parentPath = ""
+		}
+		if err := m.prepareMapperForGroup(ctx, group, parentPath, strings.Count(*group.Path, "/"), &tasks); err != nil {
+			return nil, err
+		}
+	}
+	// A group may be listed twice, or be a sub-group of another listed group
+	unique := []groupTask{}
+	seen := map[string]bool{}
+	for _, task := range tasks {
+		if !seen[*task.group.ID] {
+			seen[*task.group.ID] = true
+			unique = append(unique, task)
+		}
+	}
+	return unique, nil
+}
+
+// ClientGroups returns the names of the sub-groups of Config.ClientGroupsPath that match a client of the
+// realm, sorted by name. The other sub-groups are skipped with a warning, and a missing group has no client
+func (m *Mapper) ClientGroups(ctx context.Context) ([]string, error) {
+	group := &keycloak.Group{}
+	res, err := m.groupByPath(ctx, m.config.ClientGroupsPath, group)
+	if isNotFound(res) {
+		m.logger.Info("Missing group of the client roles", "path", m.config.ClientGroupsPath)
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read group %v: %w", m.config.ClientGroupsPath, err)
+	}
+	if !m.validGroup(group) {
+		return []string{}, nil
+	}
+	subGroups, err := m.listSubGroups(ctx, group)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list sub-groups of group %v: %w", m.config.ClientGroupsPath, err)
+	}
+	clients := []string{}
+	for _, subGroup := range subGroups {
+		if !m.validGroup(subGroup) {
+			continue
+		}
+		var found []*clientRepresentation
+		apiPath := fmt.Sprintf("admin/realms/%s/clients?clientId=%s", m.config.Realm, url.QueryEscape(*subGroup.Name))
+		if _, err := m.apiCall(ctx, http.MethodGet, apiPath, nil, &found); err != nil {
+			return nil, fmt.Errorf("cannot read client %v: %w", *subGroup.Name, err)
+		}
+		exists := false
+		for _, c := range found {
+			exists = exists || (c.ClientID != nil && *c.ClientID == *subGroup.Name)
+		}
+		if !exists {
+			m.warn("Skipping group not named after a client", "path", m.config.ClientGroupsPath+"/"+*subGroup.Name)
+			continue
+		}
+		clients = append(clients, *subGroup.Name)
+	}
+	sort.Strings(clients)
+	return clients, nil
+}
+
+// groupEmpty tells whether the group has no direct members, reading at most one of them
+func (m *Mapper) groupEmpty(ctx context.Context, groupID string) (bool, error) {
+	var members []json.RawMessage
+	apiPath := fmt.Sprintf("admin/realms/%s/groups/%s/members?briefRepresentation=true&first=0&max=1", m.config.Realm, groupID)
+	if _, err := m.apiCall(ctx, http.MethodGet, apiPath, nil, &members); err != nil {
+		return false, err
+	}
+	return len(members) == 0, nil
+}
+
+// loadDefaultGroups reads the paths of the default groups of the realm
+func (m *Mapper) loadDefaultGroups(ctx context.Context) error {
+	var groups []*keycloak.Group
+	if _, err := m.apiCall(ctx, http.MethodGet, fmt.Sprintf("admin/realms/%s/default-groups", m.config.Realm), nil, &groups); err != nil {
+		return fmt.Errorf("cannot list default groups: %w", err)
+	}
+	for _, group := range groups {
+		if group.Path != nil {
+			m.defaultGroups[*group.Path] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package mapper
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+// plannedPaths returns the paths of the groups with a missing mapping, sorted
+func plannedPaths(m *Mapper) string {
+	paths := []string{}
+	for _, mapping := range m.sortedMappings() {
+		paths = append(paths, mapping.Path)
+	}
+	return strings.Join(paths, ",")
+}
+
+func TestGroupFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		expected string
+	}{
+		{"all", nil, nil, "/eng,/eng/backend,/eng/backend/db,/eng/frontend,/ops"},
+		{"include top-level", []string{"/eng"}, nil, "/eng"},
+		{"include nested", []string{"/eng/*"}, nil, "/eng/backend,/eng/frontend"},
+		{"include several", []string{"/ops", "/eng/*/*"}, nil, "/eng/backend/db,/ops"},
+		// The sub-groups of an excluded group are still evaluated
+		{"exclude parent", nil, []string{"/eng"}, "/eng/backend,/eng/backend/db,/eng/frontend,/ops"},
+		{"exclude nested", nil, []string{"/eng/*"}, "/eng,/eng/backend/db,/ops"},
+		{"exclude wins", []string{"/eng/*"}, []string{"/eng/backend"}, "/eng/frontend"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/eng/backend/db")
+			realm.AddGroup("/eng/frontend")
+			realm.AddGroup("/ops")
+			config := testConfig(s)
+			config.GroupInclude = test.include
+			config.GroupExclude = test.exclude
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if actual := plannedPaths(m); actual != test.expected {
+				t.Errorf("expected mappings of %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGroupsListedWithRoles(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/eng", "eng")
+	realm.AddGroup("/eng/backend")
+	realm.AddGroup("/eng/frontend", "frontend")
+	realm.AddGroup("/ops")
+	for _, role := range []string{"eng", "frontend"} {
+		realm.AddRole(role)
+	}
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if actual := plannedPaths(m); actual != "/eng/backend,/ops" {
+		t.Errorf("expected mappings of /eng/backend and /ops, got %s", actual)
+	}
+	// The roles of the groups come with the list of the groups, none is read on its own
+	if calls := s.Count(http.MethodGet, TEST_REALM, "groups/*"); calls != 0 {
+		t.Errorf("expected no read of a single group, got %d", calls)
+	}
+	if calls := s.Count(http.MethodGet, TEST_REALM, "groups/*/role-mappings/realm"); calls != 0 {
+		t.Errorf("expected no read of the mappings of a group, got %d", calls)
+	}
+}
+
+func TestSubGroups(t *testing.T) {
+	tests := []struct {
+		name           string
+		embedSubGroups bool
+		childrenStatus int
+	}{
+		// Recent versions only return the sub-groups with the children API
+		{"children API", false, 0},
+		{"embedded, children API not found", true, http.StatusNotFound},
+		{"embedded, children API not allowed", true, http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			s.EmbedSubGroups = test.embedSubGroups
+			s.ChildrenStatus = test.childrenStatus
+			realm.AddGroup("/eng/backend/db")
+			realm.AddGroup("/eng/frontend")
+			m := newTestMapper(t, s, testConfig(s))
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if actual := plannedPaths(m); actual != "/eng,/eng/backend,/eng/backend/db,/eng/frontend" {
+				t.Errorf("expected the mappings of all the sub-groups, got %s", actual)
+			}
+		})
+	}
+}
+
+func TestSubGroupsError(t *testing.T) {
+	s, realm := newTestServer(t)
+	s.EmbedSubGroups = true
+	s.ChildrenStatus = http.StatusInternalServerError
+	realm.AddGroup("/eng/backend")
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.Plan(context.Background()); err == nil || !strings.Contains(err.Error(), "cannot list sub-groups of /eng") {
+		t.Errorf("expected the failed sub-groups to be returned, got %v", err)
+	}
+}
+
+func TestMalformedGroups(t *testing.T) {
+	tests := []struct {
+		name       string
+		breakGroup func(g *keycloaktest.Group)
+	}{
+		{"without name", func(g *keycloaktest.Group) { g.Name = "" }},
+		{"without ID", func(g *keycloaktest.Group) { g.ID = "" }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/admins")
+			test.breakGroup(realm.AddGroup("/broken"))
+			m := newTestMapper(t, s, testConfig(s))
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if paths := plannedPaths(m); paths != "/admins" {
+				t.Errorf("expected only /admins to be planned, got %v", paths)
+			}
+			if warnings := m.Summary().Warnings; warnings != 1 {
+				t.Errorf("expected the malformed group to be reported as a warning, got %d", warnings)
+			}
+		})
+	}
+}
+
+func TestGroupPaths(t *testing.T) {
+	tests := []struct {
+		name      string
+		recursive bool
+		expected  string
+	}{
+		{"listed groups", false, "/apps/web,/ops"},
+		{"with sub-groups", true, "/apps/web,/apps/web/admins,/ops"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/apps/web/admins")
+			realm.AddGroup("/ops")
+			realm.AddGroup("/other")
+			config := testConfig(s)
+			config.GroupPaths = []string{"/apps/web", "/ops", "/missing"}
+			config.GroupPathsRecursive = test.recursive
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if paths := plannedPaths(m); paths != test.expected {
+				t.Errorf("expected the groups %s, got %s", test.expected, paths)
+			}
+			// The missing group is a warning, not an error
+			if warnings := m.Summary().Warnings; warnings != 1 {
+				t.Errorf("expected the missing group to be reported, got %d warnings", warnings)
+			}
+			if count := s.Count(http.MethodGet, TEST_REALM, "groups"); count != 0 {
+				t.Errorf("expected the listed groups to be read by path instead of listing all the groups, got %d", count)
+			}
+		})
+	}
+}
+
+func TestSkipEmptyGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		skip     bool
+		expected string
+		calls    int
+	}{
+		{"all groups", false, "/admins,/empty,/users", 0},
+		// One members call per group, including the empty one
+		{"skip empty groups", true, "/admins,/users", 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/admins").Members = []string{"alice"}
+			realm.AddGroup("/users").Members = []string{"alice", "bob"}
+			realm.AddGroup("/empty")
+			config := testConfig(s)
+			config.SkipEmptyGroups = test.skip
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if paths := plannedPaths(m); paths != test.expected {
+				t.Errorf("expected the groups %s, got %s", test.expected, paths)
+			}
+			if calls := s.Count(http.MethodGet, TEST_REALM, "groups/*/members"); calls != test.calls {
+				t.Errorf("expected %d members calls, got %d", test.calls, calls)
+			}
+		})
+	}
+}
+
+func TestClientGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		groups   []string
+		expected string
+		warnings int
+	}{
+		{"matching", []string{"/clients/app1/admin", "/clients/app2"}, "app1,app2", 0},
+		{"not named after a client", []string{"/clients/app1/admin", "/clients/ghost/admin"}, "app1", 1},
+		{"missing", []string{"/other"}, "", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddClient("app1")
+			realm.AddClient("app2")
+			for _, groupPath := range test.groups {
+				realm.AddGroup(groupPath)
+			}
+			config := testConfig(s)
+			config.ClientGroupsPath = "/clients"
+			m := newTestMapper(t, s, config)
+			clients, err := m.ClientGroups(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := strings.Join(clients, ","); actual != test.expected {
+				t.Errorf("expected the clients %s, got %s", test.expected, actual)
+			}
+			if warnings := m.Summary().Warnings; warnings != test.warnings {
+				t.Errorf("expected %d warnings, got %d", test.warnings, warnings)
+			}
+		})
+	}
+}
+
+func TestClientGroupsSkippedByRealmMapper(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddClient("app1")
+	realm.AddGroup("/clients/app1/admin")
+	realm.AddGroup("/clientsbis")
+	realm.AddGroup("/ops")
+	config := testConfig(s)
+	config.ClientGroupsPath = "/clients"
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if paths := plannedPaths(m); paths != "/clientsbis,/ops" {
+		t.Errorf("expected only the groups out of /clients to get a realm role, got %s", paths)
+	}
+}
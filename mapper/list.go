@@ -0,0 +1,60 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// GroupRoles are the roles currently mapped to a group
+type GroupRoles struct {
+	GroupID     string              `json:"groupId"`
+	Path        string              `json:"path"`
+	RealmRoles  []string            `json:"realmRoles"`
+	ClientRoles map[string][]string `json:"clientRoles,omitempty"`
+}
+
+// ListGroups returns the roles of the groups selected by the configuration, sorted by group path,
+// without planning any change
+func (m *Mapper) ListGroups(ctx context.Context) ([]GroupRoles, error) {
+	m.reset()
+	if err := m.validateRealm(ctx); err != nil {
+		return nil, err
+	}
+	if m.config.SkipDefaultGroups {
+		if err := m.loadDefaultGroups(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if m.config.Organization != "" {
+		if err := m.loadOrganizationGroups(ctx); err != nil {
+			return nil, err
+		}
+	}
+	tasks, err := m.groupTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups := []GroupRoles{}
+	for _, task := range tasks {
+		g := task.group
+		if g.RealmRoles == nil && g.ClientRoles == nil {
+			_, err := m.retry(ctx, func() (res *http.Response, err error) {
+				g, res, err = m.client.Groups.Get(ctx, m.config.Realm, *task.group.ID)
+				return res, err
+			})
+			if err != nil {
+				return nil, fmt.Errorf("cannot read group %v: %w", task.path, err)
+			}
+		}
+		realmRoles := append([]string{}, g.RealmRoles...)
+		sort.Strings(realmRoles)
+		groups = append(groups, GroupRoles{GroupID: *task.group.ID, Path: task.path, RealmRoles: realmRoles,
+			ClientRoles: g.ClientRoles})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Path < groups[j].Path
+	})
+	return groups, nil
+}
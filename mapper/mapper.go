@@ -0,0 +1,1065 @@
+// Package mapper maps the groups of a Keycloak realm to roles named after them: it plans the missing
+// roles and mappings, reports them and applies them
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zemirco/keycloak"
+)
+
+const DEFAULT_CONCURRENCY = 4
+const ROLE_NAME_FROM_NAME = "name"
+const ROLE_NAME_FROM_PATH = "path"
+
+// Modes of Config.MappingMode: MAPPING_MODE_ENSURE_PRESENT only adds the missing roles to the groups,
+// MAPPING_MODE_EXACT also removes their other roles
+const MAPPING_MODE_ENSURE_PRESENT = "ensure-present"
+const MAPPING_MODE_EXACT = "exact"
+
+// DEFAULT_ROLE_DESCRIPTION is the description template of the created roles. In the templates,
+// GROUP_PLACEHOLDER and PATH_PLACEHOLDER are replaced by the path of the group the role is created for,
+// NAME_PLACEHOLDER by its name
+const DEFAULT_ROLE_DESCRIPTION = "Auto-created for group " + GROUP_PLACEHOLDER
+const GROUP_PLACEHOLDER = "{group}"
+const PATH_PLACEHOLDER = "{path}"
+const NAME_PLACEHOLDER = "{name}"
+
+// PATH_SEPARATOR_REPLACEMENT replaces the / separators of the group paths in the role names
+const PATH_SEPARATOR_REPLACEMENT = "."
+
+// Config holds the connection and mapping settings. The zero values of the optional settings
+// select the defaults
+type Config struct {
+	Server       string
+	BasePath     string
+	User         string
+	Password     string
+	ClientID     string
+	ClientSecret string
+	// TokenClientID is the public client of the password grant, DEFAULT_TOKEN_CLIENT_ID by default
+	TokenClientID string
+	// AuthRealm is the realm of the user or client logging in, MASTER_REALM by default
+	AuthRealm string
+	// Realm is the realm whose groups are mapped
+	Realm string
+	// AllowedRealms are the glob patterns of the realms that can be mapped. Empty allows all the realms
+	AllowedRealms []string
+	// MaxRetries is the number of retries of the API calls failing with a transient error
+	MaxRetries  int
+	TLSCAFile   string
+	TLSInsecure bool
+	// Proxy is the URL of the HTTP proxy, overriding the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+	Proxy string
+	// RequestTimeout bounds each HTTP request, 0 is unlimited
+	RequestTimeout time.Duration
+
+	RolePrefix   string
+	RoleSuffix   string
+	TargetClient string
+	GroupInclude []string
+	GroupExclude []string
+	// GroupPath or GroupID restrict the run to a single group, like /parent/child, and its sub-groups
+	GroupPath string
+	GroupID   string
+	// GroupPaths restrict the run to the listed groups, with their sub-groups when GroupPathsRecursive is set
+	GroupPaths          []string
+	GroupPathsRecursive bool
+	// ClientGroupsPath is the group whose sub-groups are named after clients, like /clients: their own
+	// sub-groups are mapped to the roles of the client, e.g. /clients/app1/admin to role admin of client app1,
+	// by a mapper per client returned by ClientGroups. Without Config.TargetClient, the mapper skips them
+	ClientGroupsPath string
+	// SkipDefaultGroups skips the default groups of the realm, which are assigned to all the new users
+	SkipDefaultGroups bool
+	// Organization restricts the run to the groups of the organization and their sub-groups, by name or alias
+	// of the organization. Needs a Keycloak version with the organization groups
+	Organization string
+	// SkipEmptyGroups skips the groups without members, at the cost of an API call per group
+	SkipEmptyGroups bool
+	// RoleNameFrom tells whether roles are named after the group name or its full path
+	RoleNameFrom string
+	// RoleNameSanitize is the policy of the role names with illegal characters: SANITIZE_NONE (default),
+	// SANITIZE_REJECT or SANITIZE_REPLACE
+	RoleNameSanitize string
+	// RoleNameSplit is the delimiter of the group names holding several roles, like "," for team-a:read,write.
+	// Empty maps every group to a single role
+	RoleNameSplit string
+	// RoleFromAttribute is the group attribute holding the names of the roles of the group, like role=admins.
+	// The groups without the attribute are mapped to a role named after them
+	RoleFromAttribute string
+	// RoleDescription is the template of the description of the created roles, like DEFAULT_ROLE_DESCRIPTION.
+	// Empty creates the roles without description
+	RoleDescription string
+	// DefaultRoles adds the created roles to the default roles of the realm, granted to all the users
+	DefaultRoles bool
+	// RoleAttributes are the templates of the attributes of the created roles, by attribute key. They
+	// cannot override the provenance attributes
+	RoleAttributes map[string]string
+	// MaxDepth is the depth of the deepest groups to map, 1 for top-level groups only. 0 is unlimited
+	MaxDepth int
+	// ManagedAttribute is the role attribute marking the roles created by this tool
+	ManagedAttribute string
+	// Concurrency is the number of groups processed in parallel
+	Concurrency int
+	// RoleOverrides are the roles mapped to specific groups, by group path
+	RoleOverrides map[string]string
+	// AlwaysAddRoles are mapped to every processed group, besides its own roles. They are used as is
+	AlwaysAddRoles []string
+	// CompositeRoles makes the role of each parent group a composite of the roles of its sub-groups
+	CompositeRoles bool
+	// Prune plans the removal of the mappings of the orphaned roles, PruneRoles also their deletion
+	Prune      bool
+	PruneRoles bool
+	// SkipRoles only creates the mappings of the existing roles, SkipMappings only creates the roles
+	SkipRoles    bool
+	SkipMappings bool
+	// ValidateRoles looks for the existing roles the missing roles may conflict with, at the cost of
+	// listing the roles of every client
+	ValidateRoles bool
+	// MappingMode is MAPPING_MODE_ENSURE_PRESENT (default) or MAPPING_MODE_EXACT, which plans the removal of
+	// the roles of the groups other than their own roles, the AllowedRoles and the built-in roles of the
+	// realm, like orphaned mappings
+	MappingMode string
+	// CheckUnexpectedRoles reports the roles mapped to the groups other than their own role, ignoring
+	// the roles matching the AllowedRoles glob patterns and the built-in roles of the realm
+	CheckUnexpectedRoles bool
+	AllowedRoles         []string
+
+	Logger *slog.Logger
+	// Audit receives an AuditEntry for every change, as a JSON line
+	Audit io.Writer
+	// Stream receives a PlannedChange for every missing role and mapping and every orphaned one, as a JSON
+	// line, while the plan is computed
+	Stream io.Writer
+	// Progress is called after the mapping of each group is prepared, with the number of groups prepared
+	// so far and the total number of groups. The calls are serialized
+	Progress func(done int, total int)
+	// ProcessedGroups are the IDs of the groups completed by a previous run, which are not planned again
+	ProcessedGroups map[string]bool
+	// GroupDone is called by Apply once all the missing mappings of a group are created, with its ID
+	GroupDone func(groupID string)
+}
+
+// String hides the credentials when printing the config
+func (config Config) String() string {
+	// plain has no String method, to print the fields without recursion
+	type plain Config
+	config.Password = mask(config.Password)
+	config.ClientSecret = mask(config.ClientSecret)
+	config.Logger = nil
+	config.Progress = nil
+	config.Audit = nil
+	config.Stream = nil
+	config.ProcessedGroups = nil
+	config.GroupDone = nil
+	return fmt.Sprintf("%+v", plain(config))
+}
+
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "****"
+}
+
+// Mapper plans and applies the mappings of the groups of a realm. Mappers share no state, several
+// of them can run at the same time
+type Mapper struct {
+	client *keycloak.Keycloak
+	config Config
+	logger *slog.Logger
+
+	// lock protects the plan updated by the concurrent workers of Plan
+	lock sync.Mutex
+	// realmID is the internal ID of the realm, targetClientID the one of the client of Config.TargetClient
+	realmID        string
+	targetClientID string
+	// roles caches the roles of the realm, or of the target client, by name
+	roles map[string]*keycloak.Role
+	// defaultGroups are the paths of the default groups, only read with Config.SkipDefaultGroups
+	defaultGroups map[string]bool
+	// organizationGroups are the paths of the groups of Config.Organization, nil when unset
+	organizationGroups map[string]bool
+	missingRoles       []string
+	// roleSourceGroups are the paths of the groups the missing roles are created for, by role name.
+	// It's also the set of missingRoles
+	roleSourceGroups map[string]string
+	// groupsWithMissingRole are the missing mappings by mappingKey
+	groupsWithMissingRole map[string]*GroupRoleMapping
+	// existingMappings are the mappings of the selected groups already in place
+	existingMappings []*GroupRoleMapping
+	// desiredRoles are the names of the roles mapped to the existing groups, whether they are filtered or not
+	desiredRoles map[string]bool
+	// roleChildren are the roles of the sub-groups, by role of their parent group
+	roleChildren map[string][]string
+	// missingComposites are the child roles not yet composed by the role of their parent group
+	missingComposites map[string][]string
+	// groupsWithUnexpectedRoles are only computed with Config.CheckUnexpectedRoles
+	groupsWithUnexpectedRoles []UnexpectedRoles
+	// roleConflicts are only computed with Config.ValidateRoles
+	roleConflicts    []RoleConflict
+	orphanedMappings []*GroupRoleMapping
+	orphanedRoles    []string
+	createdRoles     []string
+	failedRoles      []string
+	deletedRoles     []string
+	applied          bool
+	summary          Summary
+	// roleLookups count the roles read one by one, which took roleLookupTime in total
+	roleLookups    int
+	roleLookupTime time.Duration
+	// warnings counts the calls of warn, which may come from the concurrent workers of Plan
+	warnings int64
+	// sanitizedNames are the role names already reported as sanitized, the same name being computed several times
+	sanitizedNames *sync.Map
+}
+
+// New returns a mapper of the groups of config.Realm, using a client returned by Connect
+func New(client *keycloak.Keycloak, config Config) *Mapper {
+	if config.Concurrency < 1 {
+		config.Concurrency = DEFAULT_CONCURRENCY
+	}
+	if config.RoleNameFrom == "" {
+		config.RoleNameFrom = ROLE_NAME_FROM_NAME
+	}
+	if config.ManagedAttribute == "" {
+		config.ManagedAttribute = DEFAULT_MANAGED_ATTRIBUTE
+	}
+	m := &Mapper{client: client, config: config, logger: config.Logger}
+	if m.logger == nil {
+		m.logger = slog.Default()
+	}
+	m.reset()
+	return m
+}
+
+func (m *Mapper) reset() {
+	m.realmID = ""
+	m.targetClientID = ""
+	m.roles = map[string]*keycloak.Role{}
+	m.defaultGroups = map[string]bool{}
+	m.organizationGroups = nil
+	m.missingRoles = []string{}
+	m.roleSourceGroups = map[string]string{}
+	m.groupsWithMissingRole = map[string]*GroupRoleMapping{}
+	m.existingMappings = []*GroupRoleMapping{}
+	m.desiredRoles = map[string]bool{}
+	m.roleChildren = map[string][]string{}
+	m.missingComposites = map[string][]string{}
+	m.groupsWithUnexpectedRoles = []UnexpectedRoles{}
+	m.roleConflicts = []RoleConflict{}
+	m.orphanedMappings = []*GroupRoleMapping{}
+	m.orphanedRoles = []string{}
+	m.createdRoles = []string{}
+	m.failedRoles = []string{}
+	m.deletedRoles = []string{}
+	m.applied = false
+	m.roleLookups = 0
+	m.roleLookupTime = 0
+	atomic.StoreInt64(&m.warnings, 0)
+	m.sanitizedNames = &sync.Map{}
+	m.summary = Summary{}
+}
+
+// Plan computes the missing roles and mappings of the realm, and the orphaned ones when Config.Prune
+// is set. Any previous plan is discarded
+func (m *Mapper) Plan(ctx context.Context) error {
+	defer m.timed("plan", time.Now())
+	m.reset()
+	if err := m.validateRealm(ctx); err != nil {
+		return err
+	}
+	if err := m.resolveTargetClient(ctx); err != nil {
+		return err
+	}
+	if err := m.loadRoles(ctx); err != nil {
+		return err
+	}
+	if m.config.SkipDefaultGroups {
+		if err := m.loadDefaultGroups(ctx); err != nil {
+			return err
+		}
+	}
+	if m.config.Organization != "" {
+		if err := m.loadOrganizationGroups(ctx); err != nil {
+			return err
+		}
+	}
+	if err := m.prepareMapper(ctx); err != nil {
+		return err
+	}
+	if err := m.prepareComposites(ctx); err != nil {
+		return err
+	}
+	if m.config.ValidateRoles {
+		if err := m.validateMissingRoles(ctx); err != nil {
+			return err
+		}
+	}
+	if m.config.Prune {
+		if err := m.preparePrune(ctx); err != nil {
+			return err
+		}
+	}
+	// The workers find the extra mappings in any order, the plan, the audit and the removals follow the paths
+	sortOrphanedMappings(m.orphanedMappings)
+	if !m.config.SkipRoles {
+		m.summary.PlannedRoles = len(m.missingRoles)
+	}
+	if !m.config.SkipMappings {
+		m.summary.PlannedMappings = len(m.groupsWithMissingRole)
+	}
+	return nil
+}
+
+// Check verifies that the realm, and the target client if any, exist, without planning anything
+func (m *Mapper) Check(ctx context.Context) error {
+	m.reset()
+	if err := m.validateRealm(ctx); err != nil {
+		return err
+	}
+	return m.resolveTargetClient(ctx)
+}
+
+// ChangesNeeded tells whether the plan has roles, mappings or composites to create
+func (m *Mapper) ChangesNeeded() bool {
+	return len(m.missingRoles) > 0 || len(m.groupsWithMissingRole) > 0 || len(m.missingComposites) > 0
+}
+
+// Apply creates the planned roles, mappings and composites, except the roles when Config.SkipRoles
+// is set and the mappings when Config.SkipMappings is set
+func (m *Mapper) Apply(ctx context.Context) error {
+	if !m.ChangesNeeded() {
+		return nil
+	}
+	defer m.timed("apply", time.Now())
+	defer m.logRoleLookups()
+	m.applied = true
+	if !m.config.SkipRoles {
+		m.logger.Info("Creating missing roles", "realm", m.config.Realm, "count", len(m.missingRoles))
+		for _, roleName := range m.missingRoles {
+			// A cancelled context stops the run between two changes
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			created, err := m.createRoleByName(ctx, roleName, m.roleSourceGroups[roleName])
+			// A role added to the default roles may fail after its creation
+			if created {
+				m.createdRoles = append(m.createdRoles, roleName)
+			} else if err != nil {
+				m.failedRoles = append(m.failedRoles, roleName)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !m.config.SkipMappings {
+		if err := m.createMappings(ctx); err != nil {
+			return err
+		}
+	}
+	return m.createComposites(ctx)
+}
+
+func (m *Mapper) createMappings(ctx context.Context) error {
+	defer m.timed("mappings", time.Now())
+	m.logger.Info("Creating missing mappings", "realm", m.config.Realm, "count", len(m.groupsWithMissingRole))
+	// Each role is read once, then the missing roles of each group are added with a single call. A failing
+	// mapping does not stop the other ones, the error reports how many failed
+	roles := map[string]*keycloak.Role{}
+	roleErrors := map[string]error{}
+	for _, mappings := range m.mappingsByRole() {
+		roleName := mappings[0].Role
+		if _, missing := m.roleSourceGroups[roleName]; missing && m.config.SkipRoles {
+			roleErrors[roleName] = fmt.Errorf("role %v is missing and the roles are not created", roleName)
+			continue
+		}
+		role, err := m.getExistingRole(ctx, roleName)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		roles[roleName] = role
+		roleErrors[roleName] = err
+	}
+	failed := 0
+	for _, mappings := range m.mappingsByGroup() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		added := []*GroupRoleMapping{}
+		for _, mapping := range mappings {
+			if err := roleErrors[mapping.Role]; err != nil {
+				m.failMapping(mapping, err)
+				failed++
+			} else {
+				added = append(added, mapping)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+		if err := m.addRolesToGroup(ctx, added, roles); err != nil {
+			for _, mapping := range added {
+				m.failMapping(mapping, err)
+				failed++
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		if len(added) == len(mappings) && m.config.GroupDone != nil {
+			m.config.GroupDone(mappings[0].GroupID)
+		}
+	}
+	if failed > 0 {
+		return withKind(ErrMappingFailed, fmt.Errorf("cannot create %d of the %d mappings in realm %s", failed,
+			len(m.groupsWithMissingRole), m.config.Realm))
+	}
+	return nil
+}
+
+// failMapping records a planned mapping that cannot be created
+func (m *Mapper) failMapping(mapping *GroupRoleMapping, err error) {
+	m.logger.Error("Cannot create mapping", "group", mapping.Path, "role", mapping.Role, "error", err)
+	m.summary.Errors++
+	m.audit(AUDIT_CREATE_MAPPING, mapping.Path, mapping.Role, AUDIT_FAILURE, err)
+	mapping.Status = MAPPING_FAILED
+	mapping.Error = err.Error()
+}
+
+// Summary returns the counters of the planned and applied changes
+func (m *Mapper) Summary() Summary {
+	summary := m.summary
+	summary.Warnings = int(atomic.LoadInt64(&m.warnings))
+	return summary
+}
+
+// warn logs an anomaly of the realm, counted in Summary.Warnings
+func (m *Mapper) warn(msg string, args ...interface{}) {
+	atomic.AddInt64(&m.warnings, 1)
+	m.logger.Warn(msg, args...)
+}
+
+// RealmAllowed tells whether the realm matches Config.AllowedRealms
+func (config Config) RealmAllowed(realm string) bool {
+	if len(config.AllowedRealms) == 0 {
+		return true
+	}
+	for _, pattern := range config.AllowedRealms {
+		if matched, _ := path.Match(pattern, realm); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Mapper) validateRealm(ctx context.Context) error {
+	if !m.config.RealmAllowed(m.config.Realm) {
+		return fmt.Errorf("realm %s is not in the allowed realms %v", m.config.Realm, m.config.AllowedRealms)
+	}
+	var realm *keycloak.Realm
+	res, err := m.retry(ctx, func() (res *http.Response, err error) {
+		realm, res, err = m.client.Realms.Get(ctx, m.config.Realm)
+		return res, err
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot read realm %s: %w", m.config.Realm, err)
+		if isNotFound(res) {
+			return withKind(ErrRealmNotFound, err)
+		}
+		return err
+	}
+	if realm.ID == nil {
+		return withKind(ErrRealmNotFound, fmt.Errorf("provided realm '%s' is not configured", m.config.Realm))
+	}
+	m.realmID = *realm.ID
+	m.logger.Info("Found realm", "realm", *realm.Realm)
+	return nil
+}
+
+// groupTask is a group whose mapping must be prepared
+type groupTask struct {
+	group *keycloak.Group
+	path  string
+}
+
+// prepareMapper walks through the group tree, then prepares the mappings of the selected groups
+// in parallel
+func (m *Mapper) prepareMapper(ctx context.Context) error {
+	defer m.timed("groups", time.Now())
+	tasks, err := m.groupTasks(ctx)
+	if err != nil {
+		return err
+	}
+	if err := m.prepareGroupMappings(ctx, tasks); err != nil {
+		return err
+	}
+	// Workers complete in any order
+	sort.Strings(m.missingRoles)
+	return nil
+}
+
+// groupTasks walks through the group tree, or the sub-tree of the group of Config.GroupPath or
+// Config.GroupID, and returns the selected groups
+func (m *Mapper) groupTasks(ctx context.Context) ([]groupTask, error) {
+	if len(m.config.GroupPaths) > 0 {
+		return m.groupListTasks(ctx)
+	}
+	tasks := []groupTask{}
+	if m.config.GroupPath != "" || m.config.GroupID != "" {
+		group, groupPath, err := m.scopeGroup(ctx)
+		if err != nil {
+			return nil, err
+		}
+		parentPath := path.Dir(groupPath)
+		if parentPath == "/" {
+			parentPath = ""
+		}
+		if err := m.prepareMapperForGroup(ctx, group, parentPath, strings.Count(groupPath, "/"), &tasks); err != nil {
+			return nil, err
+		}
+		return tasks, nil
+	}
+	groups, err := m.listGroups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list groups: %w", err)
+	}
+	for _, g := range groups {
+		if !m.validGroup(g) {
+			continue
+		}
+		if err := m.prepareMapperForGroup(ctx, g, "", 1, &tasks); err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+// prepareGroupMappings runs prepareGroupMapping on all the tasks with a pool of workers, whose size is
+// set by Config.Concurrency. The first error, if any, is returned once all the tasks are done
+func (m *Mapper) prepareGroupMappings(ctx context.Context, tasks []groupTask) error {
+	jobs := make(chan groupTask)
+	errs := make(chan error, len(tasks))
+	done := 0
+	var wg sync.WaitGroup
+	for i := 0; i < m.config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range jobs {
+				if err := m.prepareGroupMapping(ctx, task.group, task.path); err != nil {
+					errs <- err
+				}
+				m.lock.Lock()
+				done++
+				m.summary.GroupsProcessed++
+				if m.config.Progress != nil {
+					m.config.Progress(done, len(tasks))
+				}
+				m.lock.Unlock()
+			}
+		}()
+	}
+	for _, task := range tasks {
+		jobs <- task
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// listGroups returns all the top-level groups of the realm. The full representation includes the roles
+// mapped to the groups, which saves reading every group
+func (m *Mapper) listGroups(ctx context.Context) ([]*keycloak.Group, error) {
+	groups, _, err := listPages[*keycloak.Group](ctx, m, fmt.Sprintf("admin/realms/%s/groups?briefRepresentation=false", m.config.Realm))
+	return groups, err
+}
+
+// listSubGroups returns the direct sub-groups of the group from the group children API, as recent
+// Keycloak versions no longer embed them in the group list. Keycloak 22 and older, which answer 404 or 405
+// to this API, fall back to the embedded sub-groups
+func (m *Mapper) listSubGroups(ctx context.Context, group *keycloak.Group) ([]*keycloak.Group, error) {
+	subGroups, res, err := listPages[*keycloak.Group](ctx, m, fmt.Sprintf("admin/realms/%s/groups/%s/children?briefRepresentation=false", m.config.Realm, *group.ID))
+	if isNotFound(res) || isMethodNotAllowed(res) {
+		return group.SubGroups, nil
+	}
+	return subGroups, err
+}
+
+// prepareMapperForGroup collects the tasks to prepare the mapping of the group and of all its sub-groups,
+// down to Config.MaxDepth. The parentPath is the full path of the parent group, empty for top-level groups
+// whose depth is 1
+func (m *Mapper) prepareMapperForGroup(ctx context.Context, group *keycloak.Group, parentPath string, depth int, tasks *[]groupTask) error {
+	groupPath := parentPath + "/" + *group.Name
+	if err := m.addGroupTask(group, groupPath, tasks); err != nil {
+		return err
+	}
+	if m.config.MaxDepth > 0 && depth >= m.config.MaxDepth {
+		m.logger.Debug("Skipping sub-groups beyond the maximum depth", "path", groupPath, "maxDepth", m.config.MaxDepth)
+		return nil
+	}
+	subGroups, err := m.listSubGroups(ctx, group)
+	if err != nil {
+		return fmt.Errorf("cannot list sub-groups of %v: %w", groupPath, err)
+	}
+	for _, subGroup := range subGroups {
+		if !m.validGroup(subGroup) {
+			continue
+		}
+		if m.config.CompositeRoles {
+			m.addChildRole(groupPath, group, groupPath+"/"+*subGroup.Name, subGroup)
+		}
+		m.logger.Debug("Iterate on sub-group", "group", *group.Name, "subGroup", *subGroup.Name)
+		if err := m.prepareMapperForGroup(ctx, subGroup, groupPath, depth+1, tasks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addGroupTask adds the task to prepare the mapping of the group, unless it is a default group or it is
+// filtered out, and records its roles as desired
+func (m *Mapper) addGroupTask(group *keycloak.Group, groupPath string, tasks *[]groupTask) error {
+	if m.defaultGroups[groupPath] {
+		m.logger.Info("Skipping default group", "path", groupPath)
+	} else if m.organizationGroups != nil && !m.inOrganization(groupPath) {
+		m.logger.Debug("Skipping group outside of the organization", "path", groupPath)
+	} else if m.groupSelected(groupPath) {
+		*tasks = append(*tasks, groupTask{group: group, path: groupPath})
+	} else {
+		m.logger.Debug("Skipping filtered group", "path", groupPath)
+	}
+
+	for _, roleName := range m.groupRoleNames(group, groupPath) {
+		if err := m.checkRoleName(roleName, groupPath); err != nil {
+			return err
+		}
+		m.desiredRoles[roleName] = true
+	}
+	return nil
+}
+
+func (m *Mapper) prepareGroupMapping(ctx context.Context, group *keycloak.Group, groupPath string) error {
+	m.logger.Debug("Preparing mapper for group", "group", *group.Name, "id", *group.ID)
+	if m.config.ProcessedGroups[*group.ID] {
+		m.logger.Debug("Skipping group processed by a previous run", "path", groupPath)
+		return nil
+	}
+	if m.config.SkipEmptyGroups {
+		empty, err := m.groupEmpty(ctx, *group.ID)
+		if err != nil {
+			return fmt.Errorf("cannot list members of group %v: %w", groupPath, err)
+		}
+		if empty {
+			m.logger.Info("Skipping empty group", "path", groupPath)
+			return nil
+		}
+	}
+	g := group
+	if !m.rolesListed(group) {
+		_, err := m.retry(ctx, func() (res *http.Response, err error) {
+			g, res, err = m.client.Groups.Get(ctx, m.config.Realm, *group.ID)
+			return res, err
+		})
+		if err != nil {
+			return fmt.Errorf("cannot read group %v: %w", *group.Name, err)
+		}
+		if !m.validGroup(g) {
+			return nil
+		}
+	}
+
+	roleNames := m.groupRoleNames(g, groupPath)
+	if m.config.CheckUnexpectedRoles {
+		m.checkUnexpectedRoles(g, groupPath, roleNames)
+	}
+	currentRoles := m.currentRoles(g)
+	extra := []string{}
+	if m.config.MappingMode == MAPPING_MODE_EXACT {
+		extra = m.unexpectedRoles(g, roleNames)
+	}
+	mapped := map[string]bool{}
+	for _, r := range currentRoles {
+		mapped[r] = true
+	}
+	// The group is mapped only once it has all its roles
+	missing := []string{}
+	existing := []*GroupRoleMapping{}
+	for _, roleName := range roleNames {
+		if mapped[roleName] {
+			m.logger.Debug("Role is already mapped", "group", *g.Name, "role", roleName)
+			existing = append(existing, &GroupRoleMapping{GroupID: *g.ID, Group: *g.Name, Path: groupPath, Role: roleName,
+				RolesBefore: len(currentRoles), RolesAfter: len(currentRoles)})
+		} else {
+			missing = append(missing, roleName)
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.summary.SkippedExisting += len(existing)
+	m.existingMappings = append(m.existingMappings, existing...)
+	for _, roleName := range extra {
+		m.logger.Debug("Role mapping is extra", "group", *g.Name, "role", roleName)
+		m.orphanedMappings = append(m.orphanedMappings, &GroupRoleMapping{GroupID: *g.ID, Group: *g.Name, Path: groupPath, Role: roleName})
+		m.stream(AUDIT_REMOVE_MAPPING, groupPath, roleName)
+	}
+	for _, roleName := range missing {
+		m.logger.Debug("Role mapping is missing", "group", *g.Name, "role", roleName)
+		if m.roles[roleName] == nil {
+			// Several groups may be mapped to the same missing role, which is created only once. The
+			// first path in order is its source group, whatever the order of the concurrent workers
+			if sourceGroup, found := m.roleSourceGroups[roleName]; !found {
+				m.missingRoles = append(m.missingRoles, roleName)
+				m.roleSourceGroups[roleName] = groupPath
+				if !m.config.SkipRoles {
+					m.stream(AUDIT_CREATE_ROLE, groupPath, roleName)
+				}
+			} else if groupPath < sourceGroup {
+				m.roleSourceGroups[roleName] = groupPath
+			}
+		} else {
+			m.logger.Debug("Mapping role already exists", "role", roleName)
+		}
+
+		m.groupsWithMissingRole[mappingKey(*g.ID, roleName)] = &GroupRoleMapping{GroupID: *g.ID, Group: *g.Name, Path: groupPath,
+			Role: roleName, CurrentRoles: currentRoles, RolesBefore: len(currentRoles), RolesAfter: len(currentRoles) + len(missing)}
+		if !m.config.SkipMappings {
+			m.stream(AUDIT_CREATE_MAPPING, groupPath, roleName)
+		}
+	}
+	return nil
+}
+
+// attributeRoleNames returns the roles declared by the Config.RoleFromAttribute attribute of the group,
+// used as is. A multi-valued attribute maps the group to several roles
+func (m *Mapper) attributeRoleNames(group *keycloak.Group) []string {
+	if m.config.RoleFromAttribute == "" {
+		return nil
+	}
+	roleNames := []string{}
+	seen := map[string]bool{}
+	for _, value := range group.Attributes[m.config.RoleFromAttribute] {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		roleName := m.sanitizeRoleName(value)
+		if !seen[roleName] {
+			seen[roleName] = true
+			roleNames = append(roleNames, roleName)
+		}
+	}
+	return roleNames
+}
+
+// mappingKey identifies the mapping of a role to a group
+func mappingKey(groupID string, roleName string) string {
+	return groupID + "/" + roleName
+}
+
+// mappedRoleNames returns the names of the roles mapped to the given group: the role of its mapping rule
+// if any, then the values of its Config.RoleFromAttribute attribute, otherwise a role named after the group.
+// With ROLE_NAME_FROM_PATH the role is named after the full group path, e.g. /parent/child is mapped to
+// parent.child. With Config.RoleNameSplit the name is split into several roles, each one with the prefix
+// and suffix
+func (m *Mapper) mappedRoleNames(group *keycloak.Group, groupPath string) []string {
+	if roleName, found := m.config.RoleOverrides[groupPath]; found {
+		return []string{m.sanitizeRoleName(roleName)}
+	}
+	if roleNames := m.attributeRoleNames(group); len(roleNames) > 0 {
+		return roleNames
+	}
+	name := *group.Name
+	if m.config.RoleNameFrom == ROLE_NAME_FROM_PATH {
+		name = strings.ReplaceAll(strings.TrimPrefix(groupPath, "/"), "/", PATH_SEPARATOR_REPLACEMENT)
+	}
+	parts := []string{name}
+	if m.config.RoleNameSplit != "" {
+		parts = strings.Split(name, m.config.RoleNameSplit)
+	}
+	roleNames := []string{}
+	seen := map[string]bool{}
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" && len(parts) > 1 {
+			continue
+		}
+		roleName := m.sanitizeRoleName(m.config.RolePrefix + part + m.config.RoleSuffix)
+		if !seen[roleName] {
+			seen[roleName] = true
+			roleNames = append(roleNames, roleName)
+		}
+	}
+	return roleNames
+}
+
+// groupRoleNames returns the roles mapped to the group followed by Config.AlwaysAddRoles, without duplicates
+func (m *Mapper) groupRoleNames(group *keycloak.Group, groupPath string) []string {
+	roleNames := m.mappedRoleNames(group, groupPath)
+	seen := map[string]bool{}
+	for _, roleName := range roleNames {
+		seen[roleName] = true
+	}
+	for _, roleName := range m.config.AlwaysAddRoles {
+		if !seen[roleName] {
+			seen[roleName] = true
+			roleNames = append(roleNames, roleName)
+		}
+	}
+	return roleNames
+}
+
+// createRoleByName creates the role mapped to the group with the given path. The role is tagged with
+// the provenance attributes, which identify the roles that can be pruned. It returns false when the
+// role already exists, and true with an error when the role cannot be added to the default roles
+func (m *Mapper) createRoleByName(ctx context.Context, name string, groupPath string) (bool, error) {
+	// The container of the role follows Config.TargetClient, so that the two can't contradict each other
+	clientRole := m.config.TargetClient != ""
+	containerID := m.containerID()
+	role := &keycloak.Role{Name: &name, Attributes: m.provenanceAttributes(groupPath), ClientRole: &clientRole, ContainerID: &containerID}
+	if m.config.RoleDescription != "" {
+		description := expandGroupTemplate(m.config.RoleDescription, groupPath)
+		role.Description = &description
+	}
+	for key, template := range m.config.RoleAttributes {
+		if _, reserved := role.Attributes[key]; !reserved && key != SOURCE_GROUP_ATTRIBUTE {
+			role.Attributes[key] = []string{expandGroupTemplate(template, groupPath)}
+		}
+	}
+	m.logger.Info("Creating missing role", "role", *role.Name)
+	var res *http.Response
+	var err error
+	if m.config.TargetClient != "" {
+		res, err = m.createClientRole(ctx, role)
+	} else {
+		res, err = m.retry(ctx, func() (*http.Response, error) {
+			return m.client.RealmRoles.Create(ctx, m.config.Realm, role)
+		})
+	}
+	if isConflict(res) {
+		// Another process created the role since the plan: it is read again, to map it like an existing role
+		if _, err := m.getExistingRole(ctx, name); err != nil {
+			err = withKind(ErrRoleCreateFailed, fmt.Errorf("role %v already exists but cannot be read: %w", name, err))
+			m.summary.Errors++
+			m.audit(AUDIT_CREATE_ROLE, groupPath, name, AUDIT_FAILURE, err)
+			return false, err
+		}
+		m.logger.Warn("Role was created by another process since the plan, using it", "role", name)
+		m.summary.SkippedExisting++
+		m.audit(AUDIT_CREATE_ROLE, groupPath, name, AUDIT_SKIPPED, nil)
+		return false, nil
+	}
+	if err != nil {
+		err = withKind(ErrRoleCreateFailed, fmt.Errorf("cannot create role %v: %w", name, err))
+		m.summary.Errors++
+		m.audit(AUDIT_CREATE_ROLE, groupPath, name, AUDIT_FAILURE, err)
+		return false, err
+	}
+	m.summary.RolesCreated++
+	m.audit(AUDIT_CREATE_ROLE, groupPath, name, AUDIT_SUCCESS, nil)
+	if m.config.DefaultRoles {
+		if err := m.addToDefaultRoles(ctx, name); err != nil {
+			m.summary.Errors++
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// expandGroupTemplate replaces the placeholders of the template with the path and the name of the group
+func expandGroupTemplate(template string, groupPath string) string {
+	name := ""
+	if groupPath != "" {
+		name = path.Base(groupPath)
+	}
+	return strings.NewReplacer(GROUP_PLACEHOLDER, groupPath, PATH_PLACEHOLDER, groupPath, NAME_PLACEHOLDER, name).Replace(template)
+}
+
+// loadRoles reads all the roles at once, instead of reading the role of every group
+func (m *Mapper) loadRoles(ctx context.Context) error {
+	defer m.timed("roles", time.Now())
+	roles, _, err := listPages[*keycloak.Role](ctx, m, m.rolesPath()+"?briefRepresentation=false")
+	if err != nil {
+		return fmt.Errorf("cannot list roles: %w", err)
+	}
+	for _, role := range roles {
+		if role.Name != nil {
+			m.roles[*role.Name] = role
+		}
+	}
+	m.logger.Debug("Loaded roles", "realm", m.config.Realm, "count", len(m.roles))
+	return nil
+}
+
+// getRoleGyName returns the role with the given name, or nil if the role does not exist. The roles
+// missing from the cache, like the ones created by Apply, are read and then cached
+func (m *Mapper) getRoleGyName(ctx context.Context, name string) (*keycloak.Role, error) {
+	if role, found := m.roles[name]; found {
+		return role, nil
+	}
+	role, err := m.readRole(ctx, name)
+	if role != nil {
+		m.roles[name] = role
+	}
+	return role, err
+}
+
+// readRole reads the role with the given name, returning nil if the role does not exist
+func (m *Mapper) readRole(ctx context.Context, name string) (*keycloak.Role, error) {
+	defer m.recordRoleLookup(time.Now())
+	if m.config.TargetClient != "" {
+		role, res, err := m.getClientRoleByName(ctx, name)
+		if isNotFound(res) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read role %v of client %v: %w", name, m.config.TargetClient, err)
+		}
+		return role, nil
+	}
+	var role *keycloak.Role
+	res, err := m.retry(ctx, func() (res *http.Response, err error) {
+		role, res, err = m.client.RealmRoles.GetByName(ctx, m.config.Realm, name)
+		return res, err
+	})
+	if isNotFound(res) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read role %v: %w", name, err)
+	}
+	return role, nil
+}
+
+// getExistingRole returns the role with the given name, failing if the role does not exist
+func (m *Mapper) getExistingRole(ctx context.Context, name string) (*keycloak.Role, error) {
+	role, err := m.getRoleGyName(ctx, name)
+	if err == nil && role == nil {
+		err = fmt.Errorf("role %v not found", name)
+	} else if err == nil && role.ID == nil {
+		err = fmt.Errorf("role %v has no ID", name)
+	}
+	return role, err
+}
+
+// sortedMappings returns the missing mappings sorted by group path
+func (m *Mapper) sortedMappings() []*GroupRoleMapping {
+	mappings := []*GroupRoleMapping{}
+	for _, mapping := range m.groupsWithMissingRole {
+		mappings = append(mappings, mapping)
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		if mappings[i].Path != mappings[j].Path {
+			return mappings[i].Path < mappings[j].Path
+		}
+		if mappings[i].GroupID != mappings[j].GroupID {
+			return mappings[i].GroupID < mappings[j].GroupID
+		}
+		return mappings[i].Role < mappings[j].Role
+	})
+	return mappings
+}
+
+// mappingsByRole returns the missing mappings grouped by role, sorted by group path
+func (m *Mapper) mappingsByRole() [][]*GroupRoleMapping {
+	byRole := [][]*GroupRoleMapping{}
+	indexes := map[string]int{}
+	for _, mapping := range m.sortedMappings() {
+		index, ok := indexes[mapping.Role]
+		if !ok {
+			index = len(byRole)
+			indexes[mapping.Role] = index
+			byRole = append(byRole, []*GroupRoleMapping{})
+		}
+		byRole[index] = append(byRole[index], mapping)
+	}
+	return byRole
+}
+
+// mappingsByGroup returns the missing mappings grouped by group, sorted by group path
+func (m *Mapper) mappingsByGroup() [][]*GroupRoleMapping {
+	byGroup := [][]*GroupRoleMapping{}
+	indexes := map[string]int{}
+	for _, mapping := range m.sortedMappings() {
+		index, ok := indexes[mapping.GroupID]
+		if !ok {
+			index = len(byGroup)
+			indexes[mapping.GroupID] = index
+			byGroup = append(byGroup, []*GroupRoleMapping{})
+		}
+		byGroup[index] = append(byGroup[index], mapping)
+	}
+	return byGroup
+}
+
+// skipExistingMapping records a planned mapping that was found already in place
+func (m *Mapper) skipExistingMapping(mapping *GroupRoleMapping, role string) {
+	m.logger.Info("Mapping already exists", "group", mapping.Group, "role", role)
+	mapping.Status = MAPPING_EXISTING
+	m.summary.SkippedExisting++
+	m.audit(AUDIT_CREATE_MAPPING, mapping.Path, role, AUDIT_SKIPPED, nil)
+}
+
+// addRolesToGroup adds the roles of the missing mappings of a group with a single call. The roles the group
+// got since the plan, like from a concurrent or interrupted run, are skipped
+func (m *Mapper) addRolesToGroup(ctx context.Context, mappings []*GroupRoleMapping, roles map[string]*keycloak.Role) error {
+	group := mappings[0]
+	mapped, err := m.groupRoles(ctx, group.GroupID)
+	if err != nil {
+		return fmt.Errorf("cannot read the roles of group %v: %w", group.Path, err)
+	}
+	added := []*GroupRoleMapping{}
+	addedRoles := []*keycloak.Role{}
+	names := []string{}
+	for _, mapping := range mappings {
+		if mapped[mapping.Role] {
+			m.skipExistingMapping(mapping, mapping.Role)
+			continue
+		}
+		added = append(added, mapping)
+		addedRoles = append(addedRoles, roles[mapping.Role])
+		names = append(names, mapping.Role)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	m.logger.Info("Creating mappings", "group", group.Group, "roles", names)
+	var res *http.Response
+	if m.config.TargetClient != "" {
+		res, err = m.addClientRolesToGroup(ctx, group.GroupID, addedRoles)
+	} else {
+		res, err = m.retry(ctx, func() (*http.Response, error) {
+			return m.client.Groups.AddRealmRoles(ctx, m.config.Realm, group.GroupID, addedRoles)
+		})
+	}
+	if isConflict(res) {
+		for _, mapping := range added {
+			m.skipExistingMapping(mapping, mapping.Role)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot add roles %v to group %v: %w", names, group.Path, err)
+	}
+	for _, mapping := range added {
+		mapping.Status = MAPPING_CREATED
+		m.summary.MappingsCreated++
+		m.audit(AUDIT_CREATE_MAPPING, mapping.Path, mapping.Role, AUDIT_SUCCESS, nil)
+	}
+	return nil
+}
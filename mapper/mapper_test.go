@@ -0,0 +1,842 @@
+package mapper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+	"github.com/zemirco/keycloak"
+)
+
+// failOn returns a keycloaktest.Server.Fail hook answering the requests with the method and the path
+// pattern, relative to TEST_REALM, with the status
+func failOn(method string, pattern string, status int) func(string, string) int {
+	return func(requestMethod string, requestPath string) int {
+		if matched, _ := path.Match("admin/realms/"+TEST_REALM+"/"+pattern, requestPath); matched && requestMethod == method {
+			return status
+		}
+		return 0
+	}
+}
+
+func TestApplyReturnsMappingErrors(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	realm.AddRole("admins")
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Fail = failOn(http.MethodPost, "groups/*/role-mappings/realm", http.StatusInternalServerError)
+	err := m.Apply(context.Background())
+	if err == nil {
+		t.Fatal("expected the failed mapping to be returned")
+	}
+	if !strings.Contains(err.Error(), "cannot create 1 of the 1 mappings") {
+		t.Errorf("unexpected error %v", err)
+	}
+	report := m.Report()
+	if report.Mappings[0].Status != MAPPING_FAILED || !strings.Contains(report.Mappings[0].Error, "HTTP 500") {
+		t.Errorf("expected the mapping to fail with the HTTP status, got %+v", report.Mappings[0])
+	}
+	if summary := m.Summary(); summary.Errors != 1 || summary.MappingsCreated != 0 {
+		t.Errorf("expected 1 error and no mapping, got %+v", summary)
+	}
+}
+
+func TestApplyReturnsRoleErrors(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Fail = failOn(http.MethodPost, "roles", http.StatusForbidden)
+	err := m.Apply(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cannot create role admins") {
+		t.Fatalf("expected the failed role to be returned, got %v", err)
+	}
+	if s.Count(http.MethodPost, TEST_REALM, "groups/*/role-mappings/realm") != 0 {
+		t.Error("expected no mapping once a role failed")
+	}
+}
+
+func TestPlanReturnsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		realm string
+		fail  func(string, string) int
+		err   string
+	}{
+		{"missing realm", "missing", nil, "cannot read realm missing"},
+		{"roles", TEST_REALM, failOn(http.MethodGet, "roles", http.StatusInternalServerError), "cannot list roles"},
+		{"groups", TEST_REALM, failOn(http.MethodGet, "groups", http.StatusInternalServerError), "cannot list groups"},
+		{"group", TEST_REALM, failOn(http.MethodGet, "groups/*/children", http.StatusBadGateway), "cannot list sub-groups of /admins"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/admins")
+			config := testConfig(s)
+			config.Realm = test.realm
+			m := newTestMapper(t, s, config)
+			s.Fail = test.fail
+			err := m.Plan(context.Background())
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestRolePrefixAndSuffix(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		suffix   string
+		expected string
+	}{
+		{"", "", "admins"},
+		{"grp_", "", "grp_admins"},
+		{"", "_role", "admins_role"},
+		{"grp_", "_role", "grp_admins_role"},
+	}
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/admins")
+			// The group is already mapped to its role, but only with the same prefix and suffix
+			realm.AddGroup("/mapped", test.prefix+"mapped"+test.suffix)
+			realm.AddRole(test.prefix + "mapped" + test.suffix)
+			config := testConfig(s)
+			config.RolePrefix = test.prefix
+			config.RoleSuffix = test.suffix
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			report := m.Report()
+			if strings.Join(report.MissingRoles, ",") != test.expected || len(report.Mappings) != 1 {
+				t.Fatalf("expected only role %s to be missing, got %v and %v", test.expected, report.MissingRoles, report.Mappings)
+			}
+			if err := m.Apply(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if roles := realm.Group("/admins").RealmRoles; strings.Join(roles, ",") != test.expected {
+				t.Errorf("expected group /admins to be mapped to %s, got %v", test.expected, roles)
+			}
+		})
+	}
+}
+
+func TestSameNamedSubGroups(t *testing.T) {
+	tests := []struct {
+		roleNameFrom string
+		expected     map[string]string
+	}{
+		// Named after the group, both groups share the same role
+		{ROLE_NAME_FROM_NAME, map[string]string{"/sales/team": "team", "/support/team": "team"}},
+		{ROLE_NAME_FROM_PATH, map[string]string{"/sales/team": "sales.team", "/support/team": "support.team"}},
+	}
+	for _, test := range tests {
+		t.Run(test.roleNameFrom, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/sales/team")
+			realm.AddGroup("/support/team")
+			config := testConfig(s)
+			config.RoleNameFrom = test.roleNameFrom
+			config.GroupInclude = []string{"/*/team"}
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Apply(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			for groupPath, role := range test.expected {
+				if roles := realm.Group(groupPath).RealmRoles; strings.Join(roles, ",") != role {
+					t.Errorf("expected group %s to be mapped to %s, got %v", groupPath, role, roles)
+				}
+				if realm.Role(role) == nil {
+					t.Errorf("expected role %s to be created", role)
+				}
+			}
+		})
+	}
+}
+
+func TestGetRoleByName(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   string
+		status int
+		found  bool
+		err    bool
+	}{
+		{"found", "admins", 0, true, false},
+		{"not found", "missing", 0, false, false},
+		{"server error", "admins", http.StatusInternalServerError, false, true},
+		{"forbidden", "admins", http.StatusForbidden, false, true},
+	}
+	for _, test := range tests {
+		for _, targetClient := range []string{"", "app"} {
+			t.Run(test.name+" "+targetClient, func(t *testing.T) {
+				s, realm := newTestServer(t)
+				realm.AddRole("admins")
+				realm.AddClient("app").AddRole("admins")
+				config := testConfig(s)
+				config.TargetClient = targetClient
+				m := newTestMapper(t, s, config)
+				if err := m.Check(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				if test.status != 0 {
+					s.Fail = func(method string, path string) int {
+						if strings.HasSuffix(path, "/roles/"+test.role) {
+							return test.status
+						}
+						return 0
+					}
+				}
+				role, err := m.getRoleGyName(context.Background(), test.role)
+				if (err != nil) != test.err {
+					t.Errorf("expected error %v, got %v", test.err, err)
+				}
+				if (role != nil) != test.found {
+					t.Errorf("expected found %v, got %v", test.found, role)
+				}
+				if test.found && (*role.ClientRole != (targetClient != "")) {
+					t.Errorf("expected the role of client %q, got %v", targetClient, *role.ContainerID)
+				}
+			})
+		}
+	}
+}
+
+func TestApplyAddsRolesOncePerGroup(t *testing.T) {
+	s, realm := newTestServer(t)
+	for _, group := range []string{"/a", "/b", "/c"} {
+		realm.AddGroup(group)
+	}
+	config := testConfig(s)
+	config.AlwaysAddRoles = []string{"users"}
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if count := s.Count(http.MethodPost, TEST_REALM, "groups/*/role-mappings/realm"); count != 3 {
+		t.Errorf("expected a single mapping call per group, got %d", count)
+	}
+	for _, group := range []string{"/a", "/b", "/c"} {
+		if roles := realm.Group(group).RealmRoles; len(roles) != 2 {
+			t.Errorf("expected group %s to be mapped to 2 roles, got %v", group, roles)
+		}
+	}
+	if summary := m.Summary(); summary.MappingsCreated != 6 {
+		t.Errorf("expected 6 mappings, got %+v", summary)
+	}
+}
+
+func TestApplyReadsEachRoleOnce(t *testing.T) {
+	s, realm := newTestServer(t)
+	for _, group := range []string{"/a", "/b", "/c"} {
+		realm.AddGroup(group)
+	}
+	realm.AddRole("users")
+	config := testConfig(s)
+	config.AlwaysAddRoles = []string{"users"}
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// A second lookup of the same role fails the run
+	lookups := map[string]int{}
+	s.Fail = func(method string, requestPath string) int {
+		if method == http.MethodGet && strings.HasPrefix(requestPath, "admin/realms/"+TEST_REALM+"/roles/") {
+			lookups[requestPath]++
+			if lookups[requestPath] > 1 {
+				return http.StatusInternalServerError
+			}
+		}
+		return 0
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if summary := m.Summary(); summary.MappingsCreated != 6 || summary.Errors != 0 {
+		t.Errorf("expected 6 mappings and no error, got %+v", summary)
+	}
+}
+
+func TestPlanListsRolesOnce(t *testing.T) {
+	s, realm := newTestServer(t)
+	count := 2*PAGE_SIZE + 40
+	for i := 0; i < count; i++ {
+		group := fmt.Sprintf("group-%03d", i)
+		realm.AddGroup("/" + group)
+		// Half of the roles exist already
+		if i%2 == 0 {
+			realm.AddRole(group)
+		}
+	}
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if summary := m.Summary(); summary.PlannedRoles != count/2 || summary.PlannedMappings != count {
+		t.Errorf("expected %d roles and %d mappings, got %+v", count/2, count, summary)
+	}
+	// A single list of the roles, in two full pages then the empty page ending it, and no read of a role per group
+	if calls := s.Count(http.MethodGet, TEST_REALM, "roles"); calls != 3 {
+		t.Errorf("expected 3 pages of roles, got %d", calls)
+	}
+	if calls := s.Count(http.MethodGet, TEST_REALM, "roles/*"); calls != 0 {
+		t.Errorf("expected no read of a single role, got %d", calls)
+	}
+}
+
+func TestApplySkipsMappingsCreatedSincePlan(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/a")
+	realm.AddGroup("/b")
+	config := testConfig(s)
+	config.AlwaysAddRoles = []string{"users"}
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Another run maps a role after the plan
+	realm.AddRole("users")
+	realm.Group("/a").RealmRoles = append(realm.Group("/a").RealmRoles, "users")
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if count := s.Count(http.MethodGet, TEST_REALM, "groups/*/role-mappings/realm"); count != 2 {
+		t.Errorf("expected the roles of each group to be read once, got %d", count)
+	}
+	for _, mapping := range m.Report().Mappings {
+		expected := MAPPING_CREATED
+		if mapping.Path == "/a" && mapping.Role == "users" {
+			expected = MAPPING_EXISTING
+		}
+		if mapping.Status != expected {
+			t.Errorf("expected mapping %s to %s to be %s, got %s", mapping.Path, mapping.Role, expected, mapping.Status)
+		}
+	}
+	if summary := m.Summary(); summary.MappingsCreated != 3 || summary.SkippedExisting != 2 {
+		t.Errorf("expected 3 mappings, 1 skipped role and 1 skipped mapping, got %+v", summary)
+	}
+}
+
+func TestCreateDuplicateRoleOnce(t *testing.T) {
+	tests := []struct {
+		name    string
+		created bool
+	}{
+		{"missing", false},
+		// The role is created by another run after the plan, so that its creation answers 409
+		{"created since plan", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/sales/team")
+			realm.AddGroup("/support/team")
+			config := testConfig(s)
+			config.GroupInclude = []string{"/*/team"}
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if missing := m.Report().MissingRoles; strings.Join(missing, ",") != "team" {
+				t.Fatalf("expected role team to be missing once, got %v", missing)
+			}
+			if test.created {
+				realm.AddRole("team")
+			}
+			if err := m.Apply(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if count := s.Count(http.MethodPost, TEST_REALM, "roles"); count != 1 {
+				t.Errorf("expected role team to be created once, got %d", count)
+			}
+			expected := 1
+			if test.created {
+				expected = 0
+			}
+			if summary := m.Summary(); summary.RolesCreated != expected || summary.MappingsCreated != 2 {
+				t.Errorf("expected %d role and 2 mappings, got %+v", expected, summary)
+			}
+		})
+	}
+}
+
+func TestCreateRoleConflictReadsRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		readable bool
+	}{
+		{"readable", true},
+		{"not readable", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/admins")
+			m := newTestMapper(t, s, testConfig(s))
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			// Another process creates the role between the plan and the apply
+			realm.AddRole("admins")
+			if !test.readable {
+				s.Fail = failOn(http.MethodGet, "roles/admins", http.StatusInternalServerError)
+			}
+			err := m.Apply(context.Background())
+			if s.Count(http.MethodPost, TEST_REALM, "roles") != 1 || s.Count(http.MethodGet, TEST_REALM, "roles/admins") == 0 {
+				t.Errorf("expected the role to be read again after the conflict, got requests %v", s.Requests())
+			}
+			if test.readable {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if roles := strings.Join(realm.Group("/admins").RealmRoles, ","); roles != "admins" {
+					t.Errorf("expected the role created by the other process to be mapped, got %s", roles)
+				}
+				return
+			}
+			if !errors.Is(err, ErrRoleCreateFailed) || !strings.Contains(err.Error(), "role admins already exists but cannot be read") {
+				t.Errorf("expected the role to be reported as unreadable, got %v", err)
+			}
+			if len(realm.Group("/admins").RealmRoles) != 0 {
+				t.Errorf("expected no mapping of the unreadable role, got %v", realm.Group("/admins").RealmRoles)
+			}
+		})
+	}
+}
+
+func TestRoleOverrides(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	realm.AddGroup("/users")
+	config := testConfig(s)
+	config.RoleOverrides = map[string]string{"/admins": "administrators", "/missing": "unused"}
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The rule of /admins is used, /users falls back to the role named after it
+	expected := map[string]string{"/admins": "administrators", "/users": "users"}
+	for groupPath, role := range expected {
+		if roles := realm.Group(groupPath).RealmRoles; strings.Join(roles, ",") != role {
+			t.Errorf("expected group %s to be mapped to %s, got %v", groupPath, role, roles)
+		}
+	}
+	if realm.Role("admins") != nil || realm.Role("unused") != nil {
+		t.Errorf("expected only the roles of the groups to be created, got %v", realm.RoleNames())
+	}
+}
+
+func TestCreateRolesAndMappingsModes(t *testing.T) {
+	tests := []struct {
+		name         string
+		skipRoles    bool
+		skipMappings bool
+		roles        string
+		mappings     map[string]string
+		err          string
+	}{
+		{"roles and mappings", false, false, "admins,users", map[string]string{"/admins": "admins", "/users": "users"}, ""},
+		// users exists, admins is missing: only the mapping of users is created
+		{"mappings only", true, false, "users", map[string]string{"/admins": "", "/users": "users"},
+			"cannot create 1 of the 2 mappings"},
+		{"roles only", false, true, "admins,users", map[string]string{"/admins": "", "/users": ""}, ""},
+		{"nothing", true, true, "users", map[string]string{"/admins": "", "/users": ""}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/admins")
+			realm.AddGroup("/users")
+			realm.AddRole("users")
+			config := testConfig(s)
+			config.SkipRoles = test.skipRoles
+			config.SkipMappings = test.skipMappings
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			err := m.Apply(context.Background())
+			if test.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+			if roles := strings.Join(realm.RoleNames(), ","); roles != test.roles {
+				t.Errorf("expected roles %s, got %s", test.roles, roles)
+			}
+			for groupPath, role := range test.mappings {
+				if roles := strings.Join(realm.Group(groupPath).RealmRoles, ","); roles != role {
+					t.Errorf("expected group %s to be mapped to %q, got %q", groupPath, role, roles)
+				}
+			}
+		})
+	}
+}
+
+func TestMissingRoleErrorWithoutRoleCreation(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	config := testConfig(s)
+	config.SkipRoles = true
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err == nil {
+		t.Fatal("expected the mapping of the missing role to fail")
+	}
+	mapping := m.Report().Mappings[0]
+	if mapping.Status != MAPPING_FAILED || !strings.Contains(mapping.Error, "role admins is missing and the roles are not created") {
+		t.Errorf("expected the missing role to be reported, got %+v", mapping)
+	}
+	if s.Count(http.MethodPost, TEST_REALM, "roles") != 0 {
+		t.Error("expected no role to be created")
+	}
+}
+
+func TestApplyReportsFailedMappingsPerGroup(t *testing.T) {
+	for _, targetClient := range []string{"", "app"} {
+		t.Run("client "+targetClient, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/admins")
+			realm.AddGroup("/users")
+			realm.AddClient("app")
+			config := testConfig(s)
+			config.TargetClient = targetClient
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			// Only the mapping of /users fails
+			users := realm.Group("/users").ID
+			s.Fail = func(method string, requestPath string) int {
+				if method == http.MethodPost && strings.Contains(requestPath, "/groups/"+users+"/role-mappings/") {
+					return http.StatusBadRequest
+				}
+				return 0
+			}
+			err := m.Apply(context.Background())
+			if err == nil || !errors.Is(err, ErrMappingFailed) || !strings.Contains(err.Error(), "cannot create 1 of the 2 mappings") {
+				t.Fatalf("expected the failed mapping to be returned, got %v", err)
+			}
+			for _, mapping := range m.Report().Mappings {
+				expected := MAPPING_CREATED
+				if mapping.Path == "/users" {
+					expected = MAPPING_FAILED
+				}
+				if mapping.Status != expected {
+					t.Errorf("expected the mapping of %s to be %s, got %+v", mapping.Path, expected, mapping)
+				}
+			}
+			if summary := m.Summary(); summary.MappingsCreated != 1 || summary.Errors != 1 {
+				t.Errorf("expected 1 mapping and 1 error, got %+v", summary)
+			}
+		})
+	}
+}
+
+func TestRoleNameSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		split    string
+		group    string
+		mapped   []string
+		expected string
+		planned  string
+	}{
+		{"single role", "", "read,write", nil, "read,write", "read,write"},
+		{"multiple roles", ",", "read,write", nil, "read|write", "read|write"},
+		{"spaces and empty parts", ",", "read, write,,read", nil, "read|write", "read|write"},
+		{"partially mapped", ",", "read,write", []string{"read"}, "read|write", "write"},
+		{"fully mapped", ",", "read,write", []string{"read", "write"}, "read|write", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/"+test.group, test.mapped...)
+			for _, role := range test.mapped {
+				realm.AddRole(role)
+			}
+			config := testConfig(s)
+			config.RoleNameSplit = test.split
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			planned := []string{}
+			for _, mapping := range m.Report().Mappings {
+				planned = append(planned, mapping.Role)
+			}
+			if strings.Join(planned, "|") != test.planned {
+				t.Errorf("expected the mappings %s, got %v", test.planned, planned)
+			}
+			if err := m.Apply(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			roles := append([]string{}, realm.Group("/"+test.group).RealmRoles...)
+			sort.Strings(roles)
+			if strings.Join(roles, "|") != test.expected {
+				t.Errorf("expected group %s to be mapped to %s, got %v", test.group, test.expected, roles)
+			}
+		})
+	}
+}
+
+func TestRoleDescription(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{DEFAULT_ROLE_DESCRIPTION, "Auto-created for group /parent/child"},
+		{"Members of {name} ({path})", "Members of child (/parent/child)"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/parent/child")
+			config := testConfig(s)
+			config.RoleDescription = test.template
+			config.GroupInclude = []string{"/parent/child"}
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Apply(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			role := realm.Role("child")
+			if role == nil {
+				t.Fatal("expected role child to be created")
+			}
+			description := ""
+			if role.Description != nil {
+				description = *role.Description
+			}
+			if description != test.expected {
+				t.Errorf("expected description %q, got %q", test.expected, description)
+			}
+		})
+	}
+}
+
+func TestRoleAttributes(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/platform/sre")
+	config := testConfig(s)
+	config.GroupInclude = []string{"/platform/sre"}
+	config.RoleAttributes = map[string]string{"team": "{name}", "source": "group {path}", "cost-center": "cc-42",
+		// The provenance attributes can't be overridden
+		SOURCE_GROUP_ATTRIBUTE: "{name}"}
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	role := realm.Role("sre")
+	if role == nil {
+		t.Fatal("expected role sre to be created")
+	}
+	expected := map[string]string{"team": "sre", "source": "group /platform/sre", "cost-center": "cc-42",
+		SOURCE_GROUP_ATTRIBUTE: "/platform/sre", DEFAULT_MANAGED_ATTRIBUTE: MANAGED_VALUE}
+	for key, value := range expected {
+		if actual := strings.Join(role.Attributes[key], ","); actual != value {
+			t.Errorf("expected attribute %s=%s, got %q", key, value, actual)
+		}
+	}
+}
+
+func TestAlwaysAddRoles(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	realm.AddGroup("/users", "users", "default-user")
+	realm.AddGroup("/default-user")
+	realm.AddRole("users")
+	realm.AddRole("default-user")
+	config := testConfig(s)
+	config.AlwaysAddRoles = []string{"default-user", "audit"}
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The baseline roles are created if missing and mapped once, besides the role of the group
+	expected := map[string]string{"/admins": "admins,audit,default-user", "/users": "audit,default-user,users",
+		"/default-user": "audit,default-user"}
+	for groupPath, roles := range expected {
+		actual := append([]string{}, realm.Group(groupPath).RealmRoles...)
+		sort.Strings(actual)
+		if strings.Join(actual, ",") != roles {
+			t.Errorf("expected group %s to be mapped to %s, got %v", groupPath, roles, actual)
+		}
+	}
+	if roles := strings.Join(realm.RoleNames(), ","); roles != "admins,audit,default-user,users" {
+		t.Errorf("expected the roles admins, audit, default-user and users, got %s", roles)
+	}
+}
+
+func TestRoleFromAttribute(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/ops").Attributes = map[string][]string{"role": {"operators"}}
+	realm.AddGroup("/devs").Attributes = map[string][]string{"role": {"read", " write ", "", "read"}}
+	realm.AddGroup("/blank").Attributes = map[string][]string{"role": {" "}}
+	realm.AddGroup("/users").Attributes = map[string][]string{"team": {"core"}}
+	realm.AddGroup("/ruled").Attributes = map[string][]string{"role": {"ignored"}}
+	config := testConfig(s)
+	config.RoleFromAttribute = "role"
+	config.RolePrefix = "grp_"
+	config.RoleOverrides = map[string]string{"/ruled": "rule"}
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The attribute values are used as is, the groups without it fall back to their name
+	expected := map[string]string{"/ops": "operators", "/devs": "read,write", "/blank": "grp_blank", "/users": "grp_users",
+		"/ruled": "rule"}
+	for groupPath, roles := range expected {
+		actual := append([]string{}, realm.Group(groupPath).RealmRoles...)
+		sort.Strings(actual)
+		if strings.Join(actual, ",") != roles {
+			t.Errorf("expected group %s to be mapped to %s, got %v", groupPath, roles, actual)
+		}
+	}
+}
+
+func TestApplyStopsWhenCancelled(t *testing.T) {
+	s, realm := newTestServer(t)
+	for _, group := range []string{"/a", "/b", "/c"} {
+		realm.AddGroup(group)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := testConfig(s)
+	// Interrupted once the first group is mapped
+	config.GroupDone = func(groupID string) { cancel() }
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	err := m.Apply(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the run to be cancelled, got %v", err)
+	}
+	if roles := strings.Join(realm.Group("/a").RealmRoles, ","); roles != "a" {
+		t.Errorf("expected the first group to be mapped, got %s", roles)
+	}
+	for _, group := range []string{"/b", "/c"} {
+		if roles := realm.Group(group).RealmRoles; len(roles) != 0 {
+			t.Errorf("expected group %s not to be mapped once cancelled, got %v", group, roles)
+		}
+	}
+	if summary := m.Summary(); summary.MappingsCreated != 1 || summary.RolesCreated != 3 {
+		t.Errorf("expected the summary of the changes done so far, got %+v", summary)
+	}
+}
+
+func TestPlanStopsWhenCancelled(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/a")
+	m := newTestMapper(t, s, testConfig(s))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Plan(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the plan to be cancelled, got %v", err)
+	}
+}
+
+func TestCreateRolePayload(t *testing.T) {
+	tests := []struct {
+		name        string
+		client      string
+		clientRole  bool
+		containerID func(realm *keycloaktest.Realm, client *keycloaktest.Client) string
+	}{
+		{"realm role", "", false, func(realm *keycloaktest.Realm, client *keycloaktest.Client) string { return realm.ID }},
+		{"client role", "web", true, func(realm *keycloaktest.Realm, client *keycloaktest.Client) string { return client.ID }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			client := realm.AddClient("web")
+			realm.AddGroup("/admins")
+			config := testConfig(s)
+			config.TargetClient = test.client
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Apply(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			pattern := "roles"
+			if test.client != "" {
+				pattern = "clients/*/roles"
+			}
+			bodies := s.Bodies(http.MethodPost, TEST_REALM, pattern)
+			if len(bodies) != 1 {
+				t.Fatalf("expected a role to be created, got requests %v", s.Requests())
+			}
+			var role keycloak.Role
+			if err := json.Unmarshal([]byte(bodies[0]), &role); err != nil {
+				t.Fatal(err)
+			}
+			if role.ClientRole == nil || *role.ClientRole != test.clientRole {
+				t.Errorf("expected clientRole %v in the payload, got %s", test.clientRole, bodies[0])
+			}
+			if role.ContainerID == nil || *role.ContainerID != test.containerID(realm, client) {
+				t.Errorf("expected containerId %s in the payload, got %s", test.containerID(realm, client), bodies[0])
+			}
+		})
+	}
+}
+
+func TestDefaultRoles(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	realm.AddGroup("/users", "users")
+	realm.AddRole("users")
+	config := testConfig(s)
+	config.DefaultRoles = true
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Only the created roles are added to the default roles
+	if roles := strings.Join(realm.DefaultRoles, ","); roles != "admins" {
+		t.Errorf("expected the default roles admins, got %s", roles)
+	}
+}
@@ -0,0 +1,64 @@
+package mapper
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policies of Config.RoleNameSanitize for the role names that Keycloak would reject
+const SANITIZE_NONE = "none"
+const SANITIZE_REJECT = "reject"
+const SANITIZE_REPLACE = "replace"
+
+// MAX_ROLE_NAME_LENGTH is the size of the role name column of the Keycloak database
+const MAX_ROLE_NAME_LENGTH = 255
+
+// ROLE_NAME_REPLACEMENT replaces the illegal characters of the role names with SANITIZE_REPLACE
+const ROLE_NAME_REPLACEMENT = "_"
+
+// illegalRoleNameChar tells whether the character breaks the role APIs, whose paths include the role name
+func illegalRoleNameChar(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(`/\%`, r)
+}
+
+// sanitizeRoleName replaces the illegal characters and truncates the name to MAX_ROLE_NAME_LENGTH,
+// with SANITIZE_REPLACE
+func (m *Mapper) sanitizeRoleName(name string) string {
+	if m.config.RoleNameSanitize != SANITIZE_REPLACE {
+		return name
+	}
+	var builder strings.Builder
+	for _, r := range name {
+		if illegalRoleNameChar(r) {
+			builder.WriteString(ROLE_NAME_REPLACEMENT)
+		} else {
+			builder.WriteRune(r)
+		}
+	}
+	sanitized := builder.String()
+	for len(sanitized) > MAX_ROLE_NAME_LENGTH {
+		_, size := utf8.DecodeLastRuneInString(sanitized)
+		sanitized = sanitized[:len(sanitized)-size]
+	}
+	if _, reported := m.sanitizedNames.LoadOrStore(name, true); sanitized != name && !reported {
+		m.warn("Sanitized role name", "name", name, "sanitized", sanitized)
+	}
+	return sanitized
+}
+
+// checkRoleName rejects the role name of the group when it has illegal characters or is too long,
+// with SANITIZE_REJECT
+func (m *Mapper) checkRoleName(name string, groupPath string) error {
+	if m.config.RoleNameSanitize != SANITIZE_REJECT {
+		return nil
+	}
+	if strings.IndexFunc(name, illegalRoleNameChar) >= 0 {
+		return fmt.Errorf("invalid role name %q for group %v: spaces, control characters, / \\ and %% are not allowed", name, groupPath)
+	}
+	if len(name) > MAX_ROLE_NAME_LENGTH {
+		return fmt.Errorf("invalid role name %q for group %v: longer than %d bytes", name, groupPath, MAX_ROLE_NAME_LENGTH)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package mapper
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeRoleName(t *testing.T) {
+	long := strings.Repeat("a", MAX_ROLE_NAME_LENGTH+10)
+	// A multi-byte character across the limit is removed, not cut
+	longUnicode := strings.Repeat("a", MAX_ROLE_NAME_LENGTH-1) + "é"
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"admins", "admins"},
+		{"Sales Team", "Sales_Team"},
+		{"apps/web", "apps_web"},
+		{`a\b%c`, "a_b_c"},
+		{"tab\tnew\nline", "tab_new_line"},
+		{long, long[:MAX_ROLE_NAME_LENGTH]},
+		{longUnicode, longUnicode[:MAX_ROLE_NAME_LENGTH-1]},
+	}
+	for _, test := range tests {
+		m := New(nil, Config{RoleNameSanitize: SANITIZE_REPLACE, Logger: testLogger})
+		actual := m.sanitizeRoleName(test.name)
+		if actual != test.expected {
+			t.Errorf("sanitizeRoleName(%q): expected %q, got %q", test.name, test.expected, actual)
+		}
+		if !utf8.ValidString(actual) {
+			t.Errorf("sanitizeRoleName(%q): invalid UTF-8 %q", test.name, actual)
+		}
+		if none := New(nil, Config{Logger: testLogger}).sanitizeRoleName(test.name); none != test.name {
+			t.Errorf("expected %q to be kept as is without policy, got %q", test.name, none)
+		}
+	}
+}
+
+func TestCheckRoleName(t *testing.T) {
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{"admins", ""},
+		{"Sales Team", "spaces, control characters"},
+		{"apps/web", "spaces, control characters"},
+		{strings.Repeat("a", MAX_ROLE_NAME_LENGTH), ""},
+		{strings.Repeat("a", MAX_ROLE_NAME_LENGTH+1), "longer than 255 bytes"},
+	}
+	for _, test := range tests {
+		m := New(nil, Config{RoleNameSanitize: SANITIZE_REJECT, Logger: testLogger})
+		err := m.checkRoleName(test.name, "/group")
+		if test.err == "" && err != nil {
+			t.Errorf("%q: unexpected error %v", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err) || !strings.Contains(err.Error(), "/group")) {
+			t.Errorf("%q: expected error %q with the group, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestRoleNameSanitizePolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		role   string
+		err    string
+	}{
+		{SANITIZE_NONE, "Sales Team", ""},
+		{SANITIZE_REPLACE, "Sales_Team", ""},
+		{SANITIZE_REJECT, "", `invalid role name "Sales Team" for group /Sales Team`},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/Sales Team")
+			config := testConfig(s)
+			config.RoleNameSanitize = test.policy
+			m := newTestMapper(t, s, config)
+			err := m.Plan(context.Background())
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if missing := strings.Join(m.Report().MissingRoles, ","); missing != test.role {
+				t.Errorf("expected role %q to be planned, got %q", test.role, missing)
+			}
+		})
+	}
+}
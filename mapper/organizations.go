@@ -0,0 +1,68 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/zemirco/keycloak"
+)
+
+// organizationRepresentation is the subset of the Keycloak organization used by the mapper
+type organizationRepresentation struct {
+	ID    *string `json:"id,omitempty"`
+	Name  *string `json:"name,omitempty"`
+	Alias *string `json:"alias,omitempty"`
+}
+
+// loadOrganizationGroups reads the paths of the groups of Config.Organization, the roots of its group tree:
+// their sub-groups belong to the organization too. The organization is found by name or alias
+func (m *Mapper) loadOrganizationGroups(ctx context.Context) error {
+	var found []*organizationRepresentation
+	apiPath := fmt.Sprintf("admin/realms/%s/organizations?search=%s&exact=true", m.config.Realm, url.QueryEscape(m.config.Organization))
+	if _, err := m.apiCall(ctx, http.MethodGet, apiPath, nil, &found); err != nil {
+		return fmt.Errorf("cannot read organization %v: %w", m.config.Organization, err)
+	}
+	organizationID := ""
+	for _, organization := range found {
+		if organization.ID == nil {
+			continue
+		}
+		if (organization.Name != nil && *organization.Name == m.config.Organization) ||
+			(organization.Alias != nil && *organization.Alias == m.config.Organization) {
+			organizationID = *organization.ID
+			break
+		}
+	}
+	if organizationID == "" {
+		return fmt.Errorf("cannot find organization %v in realm %v", m.config.Organization, m.config.Realm)
+	}
+
+	groups, _, err := listPages[*keycloak.Group](ctx, m, fmt.Sprintf("admin/realms/%s/organizations/%s/groups?briefRepresentation=true",
+		m.config.Realm, url.PathEscape(organizationID)))
+	if err != nil {
+		return fmt.Errorf("cannot list groups of organization %v: %w", m.config.Organization, err)
+	}
+	m.organizationGroups = map[string]bool{}
+	for _, group := range groups {
+		if group != nil && group.Path != nil {
+			m.organizationGroups[*group.Path] = true
+		}
+	}
+	m.logger.Info("Restricting the run to the groups of organization", "organization", m.config.Organization,
+		"groups", len(m.organizationGroups))
+	return nil
+}
+
+// inOrganization tells whether the group with the given path is a group of Config.Organization or one of
+// their sub-groups
+func (m *Mapper) inOrganization(groupPath string) bool {
+	for parent := groupPath; parent != "/" && parent != "."; parent = path.Dir(parent) {
+		if m.organizationGroups[parent] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package mapper
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestOrganizationGroups(t *testing.T) {
+	tests := []struct {
+		organization string
+		expected     string
+	}{
+		{"", "/acme,/acme/devs,/acme/devs/backend,/globex,/staff"},
+		{"Acme Corp", "/acme,/acme/devs,/acme/devs/backend"},
+		{"acme", "/acme,/acme/devs,/acme/devs/backend"},
+		{"globex", "/globex"},
+	}
+	for _, test := range tests {
+		t.Run("organization "+test.organization, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/acme/devs/backend").Members = []string{"alice"}
+			realm.AddGroup("/globex").Members = []string{"bob"}
+			// alice is a member of acme, and of a group of the realm outside of acme
+			realm.AddGroup("/staff").Members = []string{"alice", "bob"}
+			realm.AddOrganization("Acme Corp", "acme", "alice").Groups = []string{"/acme"}
+			realm.AddOrganization("Globex", "globex", "bob").Groups = []string{"/globex"}
+			config := testConfig(s)
+			config.Organization = test.organization
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if paths := plannedPaths(m); paths != test.expected {
+				t.Errorf("expected the groups %s, got %s", test.expected, paths)
+			}
+		})
+	}
+}
+
+func TestMissingOrganization(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddOrganization("Acme Corp", "acme")
+	config := testConfig(s)
+	config.Organization = "initech"
+	m := newTestMapper(t, s, config)
+	err := m.Plan(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cannot find organization initech in realm test") {
+		t.Errorf("expected the missing organization to fail the plan, got %v", err)
+	}
+}
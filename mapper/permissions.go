@@ -0,0 +1,67 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// Admin roles of the realm-management client needed to apply the changes
+const PERMISSION_MANAGE_REALM = "manage-realm"
+const PERMISSION_MANAGE_CLIENTS = "manage-clients"
+const PERMISSION_MANAGE_USERS = "manage-users"
+
+// whoAmI is the answer of the whoami endpoint of the admin console, with the admin roles of the login
+// by realm name
+type whoAmI struct {
+	RealmAccess map[string][]string `json:"realm_access"`
+}
+
+// requiredPermissions are the admin roles needed by the changes of the configuration: the roles of the
+// realm or of the target client are managed with manage-realm or manage-clients, the group mappings
+// with manage-users
+func (m *Mapper) requiredPermissions() []string {
+	required := []string{}
+	if !m.config.SkipRoles || m.config.CompositeRoles || m.config.PruneRoles {
+		if m.config.TargetClient != "" {
+			required = append(required, PERMISSION_MANAGE_CLIENTS)
+		} else {
+			required = append(required, PERMISSION_MANAGE_REALM)
+		}
+	}
+	if m.config.DefaultRoles && m.config.TargetClient != "" {
+		required = append(required, PERMISSION_MANAGE_REALM)
+	}
+	if !m.config.SkipMappings || m.config.Prune || m.config.MappingMode == MAPPING_MODE_EXACT {
+		required = append(required, PERMISSION_MANAGE_USERS)
+	}
+	return required
+}
+
+// MissingPermissions returns the admin roles needed to apply the changes that the login lacks in the realm,
+// sorted by name. The roles are read with the whoami endpoint of the admin console, without changing anything
+func (m *Mapper) MissingPermissions(ctx context.Context) ([]string, error) {
+	authRealm := m.config.AuthRealm
+	if authRealm == "" {
+		authRealm = MASTER_REALM
+	}
+	var whoami whoAmI
+	path := fmt.Sprintf("admin/%s/console/whoami?currentRealm=%s", url.PathEscape(authRealm), url.QueryEscape(m.config.Realm))
+	if _, err := m.apiCall(ctx, http.MethodGet, path, nil, &whoami); err != nil {
+		return nil, fmt.Errorf("cannot read the permissions of the login: %w", err)
+	}
+	granted := map[string]bool{}
+	for _, role := range whoami.RealmAccess[m.config.Realm] {
+		granted[role] = true
+	}
+	missing := []string{}
+	for _, role := range m.requiredPermissions() {
+		if !granted[role] {
+			missing = append(missing, role)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
@@ -0,0 +1,41 @@
+package mapper
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestMissingPermissions(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		change      func(config *Config)
+		missing     string
+	}{
+		{"all granted", []string{PERMISSION_MANAGE_REALM, PERMISSION_MANAGE_USERS}, func(config *Config) {}, ""},
+		{"none granted", nil, func(config *Config) {}, "manage-realm,manage-users"},
+		{"roles only", []string{PERMISSION_MANAGE_USERS}, func(config *Config) {}, "manage-realm"},
+		{"mappings only", []string{PERMISSION_MANAGE_REALM}, func(config *Config) {}, "manage-users"},
+		{"no role creation", []string{PERMISSION_MANAGE_USERS}, func(config *Config) { config.SkipRoles = true }, ""},
+		{"client roles", []string{PERMISSION_MANAGE_REALM, PERMISSION_MANAGE_USERS}, func(config *Config) { config.TargetClient = "web" },
+			"manage-clients"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddClient("web")
+			realm.Permissions = test.permissions
+			config := testConfig(s)
+			test.change(&config)
+			m := newTestMapper(t, s, config)
+			missing, err := m.MissingPermissions(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := strings.Join(missing, ","); actual != test.missing {
+				t.Errorf("expected the missing permissions %q, got %q", test.missing, actual)
+			}
+		})
+	}
+}
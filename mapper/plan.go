@@ -0,0 +1,96 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/zemirco/keycloak"
+)
+
+// LoadPlan replaces the plan with the one of an earlier run, as returned by Report, instead of computing
+// it again. The plan must be for the same realm and target client, and it is rejected when the realm drifted
+// since then: a missing role was created, or the roles mapped to a group changed
+func (m *Mapper) LoadPlan(ctx context.Context, plan Report) error {
+	m.reset()
+	if plan.Realm != m.config.Realm {
+		return fmt.Errorf("the plan is for realm %s, not %s", plan.Realm, m.config.Realm)
+	}
+	if plan.Client != m.config.TargetClient {
+		return fmt.Errorf("the plan is for the roles of client %q, not %q", plan.Client, m.config.TargetClient)
+	}
+	if err := m.validateRealm(ctx); err != nil {
+		return err
+	}
+	if err := m.resolveTargetClient(ctx); err != nil {
+		return err
+	}
+	m.missingRoles = append(m.missingRoles, plan.MissingRoles...)
+	for _, roleName := range m.missingRoles {
+		m.roleSourceGroups[roleName] = ""
+	}
+	sortMappings(plan.Mappings)
+	for i := range plan.Mappings {
+		mapping := plan.Mappings[i]
+		m.groupsWithMissingRole[mappingKey(mapping.GroupID, mapping.Role)] = &mapping
+		if source, found := m.roleSourceGroups[mapping.Role]; found && source == "" {
+			m.roleSourceGroups[mapping.Role] = mapping.Path
+		}
+	}
+	for i := range plan.ExistingMappings {
+		mapping := plan.ExistingMappings[i]
+		m.existingMappings = append(m.existingMappings, &mapping)
+	}
+	for i := range plan.OrphanedMappings {
+		mapping := plan.OrphanedMappings[i]
+		m.orphanedMappings = append(m.orphanedMappings, &mapping)
+	}
+	m.orphanedRoles = append(m.orphanedRoles, plan.OrphanedRoles...)
+	for parentRole, children := range plan.MissingComposites {
+		m.missingComposites[parentRole] = append([]string{}, children...)
+	}
+	if err := m.checkPlan(ctx); err != nil {
+		return fmt.Errorf("realm %s drifted since the plan was computed: %w", m.config.Realm, err)
+	}
+	if !m.config.SkipRoles {
+		m.summary.PlannedRoles = len(m.missingRoles)
+	}
+	if !m.config.SkipMappings {
+		m.summary.PlannedMappings = len(m.groupsWithMissingRole)
+	}
+	return nil
+}
+
+// checkPlan verifies that the missing roles are still missing, and that the groups are still mapped
+// to the roles they had when the plan was computed
+func (m *Mapper) checkPlan(ctx context.Context) error {
+	for _, roleName := range m.missingRoles {
+		role, err := m.readRole(ctx, roleName)
+		if err != nil {
+			return err
+		}
+		if role != nil {
+			return fmt.Errorf("role %v was created", roleName)
+		}
+	}
+	for _, mapping := range m.sortedMappings() {
+		var g *keycloak.Group
+		_, err := m.retry(ctx, func() (res *http.Response, err error) {
+			g, res, err = m.client.Groups.Get(ctx, m.config.Realm, mapping.GroupID)
+			return res, err
+		})
+		if err != nil {
+			return fmt.Errorf("cannot read group %v: %w", mapping.Path, err)
+		}
+		current := append([]string{}, m.currentRoles(g)...)
+		planned := append([]string{}, mapping.CurrentRoles...)
+		sort.Strings(current)
+		sort.Strings(planned)
+		if strings.Join(current, ",") != strings.Join(planned, ",") {
+			return fmt.Errorf("group %v is mapped to %v instead of %v", mapping.Path, current, planned)
+		}
+	}
+	return nil
+}
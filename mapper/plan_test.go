@@ -0,0 +1,147 @@
+package mapper
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+// plannedReport returns the plan of the realm for the config
+func plannedReport(t *testing.T, s *keycloaktest.Server, config Config) Report {
+	t.Helper()
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return m.Report()
+}
+
+func TestLoadPlan(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	realm.AddGroup("/users", "users")
+	realm.AddRole("users")
+	plan := plannedReport(t, s, testConfig(s))
+
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.LoadPlan(context.Background(), plan); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if roles := strings.Join(realm.Group("/admins").RealmRoles, ","); roles != "admins" {
+		t.Errorf("expected group /admins to be mapped to admins, got %s", roles)
+	}
+}
+
+func TestLoadPlanRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(realm *keycloaktest.Realm, config *Config)
+		err    string
+	}{
+		{"other realm", func(realm *keycloaktest.Realm, config *Config) { config.Realm = "other" },
+			"the plan is for realm test, not other"},
+		{"other client", func(realm *keycloaktest.Realm, config *Config) { config.TargetClient = "app" },
+			`the plan is for the roles of client "", not "app"`},
+		{"role created", func(realm *keycloaktest.Realm, config *Config) { realm.AddRole("admins") },
+			"realm test drifted since the plan was computed"},
+		{"group mapped", func(realm *keycloaktest.Realm, config *Config) {
+			realm.Group("/admins").RealmRoles = append(realm.Group("/admins").RealmRoles, "users")
+		}, "realm test drifted since the plan was computed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			s.AddRealm("other")
+			realm.AddGroup("/admins")
+			realm.AddGroup("/users", "users")
+			realm.AddRole("users")
+			realm.AddClient("app")
+			plan := plannedReport(t, s, testConfig(s))
+
+			config := testConfig(s)
+			test.change(realm, &config)
+			m := newTestMapper(t, s, config)
+			if err := m.LoadPlan(context.Background(), plan); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadPlanOfClient(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	realm.AddClient("app")
+	config := testConfig(s)
+	config.TargetClient = "app"
+	plan := plannedReport(t, s, config)
+	if plan.Client != "app" {
+		t.Fatalf("expected the plan of client app, got %q", plan.Client)
+	}
+	if err := newTestMapper(t, s, testConfig(s)).LoadPlan(context.Background(), plan); err == nil ||
+		!strings.Contains(err.Error(), `the plan is for the roles of client "app", not ""`) {
+		t.Errorf("expected the plan of the client roles to be rejected for the realm roles, got %v", err)
+	}
+	m := newTestMapper(t, s, config)
+	if err := m.LoadPlan(context.Background(), plan); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlanOrder(t *testing.T) {
+	s, realm := newTestServer(t)
+	for _, groupPath := range []string{"/zeta", "/alpha/beta", "/mid", "/alpha", "/beta", "/alpha/aardvark"} {
+		realm.AddGroup(groupPath)
+	}
+	// Two groups of the same path are ordered by ID
+	realm.AddGroup("/mid/dup")
+	realm.AddGroup("/mid").Children = append(realm.Group("/mid").Children, &keycloaktest.Group{ID: "group-0", Name: "dup",
+		Path: "/mid/dup"})
+	var first string
+	for i := 0; i < 5; i++ {
+		m := newTestMapper(t, s, testConfig(s))
+		if err := m.Plan(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		paths := []string{}
+		for _, mapping := range m.Report().Mappings {
+			paths = append(paths, mapping.Path+"#"+mapping.GroupID)
+		}
+		if !sort.StringsAreSorted(paths) {
+			t.Errorf("expected the mappings sorted by path and ID, got %v", paths)
+		}
+		var out strings.Builder
+		m.PrintPlan(&out)
+		if i == 0 {
+			first = out.String()
+		} else if out.String() != first {
+			t.Fatalf("expected the same plan on each run, got %s and %s", first, out.String())
+		}
+	}
+}
+
+func TestRoleCounts(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins", "legacy", "auditor")
+	realm.AddRole("legacy")
+	realm.AddRole("auditor")
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mappings := m.Report().Mappings
+	if len(mappings) != 1 || mappings[0].Role != "admins" || mappings[0].RolesBefore != 2 || mappings[0].RolesAfter != 3 {
+		t.Fatalf("expected group /admins to go from 2 to 3 roles, got %+v", mappings)
+	}
+	var out strings.Builder
+	m.PrintPlan(&out)
+	if expected := "Group /admins to Role admins (2 roles → 3 roles)\n"; !strings.Contains(out.String(), expected) {
+		t.Errorf("expected %q in the plan, got %s", expected, out.String())
+	}
+}
@@ -0,0 +1,92 @@
+package mapper
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// newTestProxy returns a forward proxy recording the URLs of the requests it relays
+func newTestProxy(t *testing.T) (*httptest.Server, func() []string) {
+	var lock sync.Mutex
+	urls := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		urls = append(urls, req.URL.String())
+		lock.Unlock()
+		forwarded := req.Clone(req.Context())
+		forwarded.RequestURI = ""
+		res, err := http.DefaultTransport.RoundTrip(forwarded)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		for key, values := range res.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, urls...)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	proxy, proxied := newTestProxy(t)
+	config := testConfig(s)
+	config.Proxy = proxy.URL
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	urls := proxied()
+	if len(urls) != len(s.Requests()) {
+		t.Errorf("expected all the %d requests to go through the proxy, got %v", len(s.Requests()), urls)
+	}
+	// Both the token exchange and the admin API go through the proxy
+	token, admin := false, false
+	for _, url := range urls {
+		token = token || url == s.URL+"/realms/master/protocol/openid-connect/token"
+		admin = admin || url == s.URL+"/admin/realms/test"
+	}
+	if !token || !admin {
+		t.Errorf("expected the login and the admin API through the proxy, got %v", urls)
+	}
+}
+
+func TestInvalidProxy(t *testing.T) {
+	s, _ := newTestServer(t)
+	config := testConfig(s)
+	config.Proxy = "http://proxy:port"
+	if _, err := Connect(context.Background(), config); err == nil {
+		t.Error("expected the invalid proxy URL to be rejected")
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	// Without Config.Proxy, the default transport honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	client, err := newHTTPClient(Config{}, testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Transport != nil {
+		t.Errorf("expected the default transport, got %v", client.Transport)
+	}
+	client, err = newHTTPClient(Config{TLSInsecure: true}, testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport := client.Transport.(*http.Transport); transport.Proxy == nil {
+		t.Error("expected the proxy of the environment to be kept with a custom TLS config")
+	}
+}
@@ -0,0 +1,187 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/zemirco/keycloak"
+)
+
+// DEFAULT_MANAGED_ATTRIBUTE marks the roles created by this tool, the only ones that can be pruned
+const DEFAULT_MANAGED_ATTRIBUTE = "managed-by"
+const MANAGED_VALUE = "group2role"
+
+// SOURCE_GROUP_ATTRIBUTE is the path of the group a role was created for
+const SOURCE_GROUP_ATTRIBUTE = "source-group"
+
+// preparePrune looks for the roles created by this tool that no longer match any group, and for
+// the groups still mapped to them
+func (m *Mapper) preparePrune(ctx context.Context) error {
+	if m.config.MaxDepth > 0 {
+		return fmt.Errorf("pruning needs all the groups, it cannot be combined with a maximum group depth")
+	}
+	if m.config.GroupPath != "" || m.config.GroupID != "" || len(m.config.GroupPaths) > 0 {
+		return fmt.Errorf("pruning needs all the groups, it cannot be restricted to some groups")
+	}
+	planned := map[string]bool{}
+	for _, mapping := range m.orphanedMappings {
+		planned[mappingKey(mapping.GroupID, mapping.Role)] = true
+	}
+	// The cached roles are read with their attributes
+	names := []string{}
+	for name := range m.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		role := m.roles[name]
+		if !m.isManaged(role) || m.desiredRoles[*role.Name] {
+			continue
+		}
+		m.logger.Debug("Found orphaned role", "role", *role.Name)
+		groups, _, err := listPages[*keycloak.Group](ctx, m, m.rolesPath()+"/"+url.PathEscape(*role.Name)+"/groups")
+		if err != nil {
+			return fmt.Errorf("cannot list groups of role %v: %w", *role.Name, err)
+		}
+		for _, g := range groups {
+			if !m.validGroup(g) {
+				continue
+			}
+			// The mapping may be planned for removal already by MAPPING_MODE_EXACT
+			if planned[mappingKey(*g.ID, *role.Name)] {
+				continue
+			}
+			mapping := &GroupRoleMapping{GroupID: *g.ID, Group: *g.Name, Role: *role.Name}
+			if g.Path != nil {
+				mapping.Path = *g.Path
+			}
+			m.orphanedMappings = append(m.orphanedMappings, mapping)
+			m.stream(AUDIT_REMOVE_MAPPING, mapping.Path, mapping.Role)
+		}
+		if m.config.PruneRoles {
+			m.orphanedRoles = append(m.orphanedRoles, *role.Name)
+			m.stream(AUDIT_DELETE_ROLE, "", *role.Name)
+		}
+	}
+	return nil
+}
+
+// provenanceAttributes are the attributes set on the roles created for the group with the given path
+func (m *Mapper) provenanceAttributes(groupPath string) map[string][]string {
+	attributes := map[string][]string{m.config.ManagedAttribute: {MANAGED_VALUE}}
+	if groupPath != "" {
+		attributes[SOURCE_GROUP_ATTRIBUTE] = []string{groupPath}
+	}
+	return attributes
+}
+
+func (m *Mapper) isManaged(role *keycloak.Role) bool {
+	for _, value := range role.Attributes[m.config.ManagedAttribute] {
+		if value == MANAGED_VALUE {
+			return true
+		}
+	}
+	return false
+}
+
+func sortOrphanedMappings(mappings []*GroupRoleMapping) {
+	sort.Slice(mappings, func(i, j int) bool {
+		if mappings[i].Path != mappings[j].Path {
+			return mappings[i].Path < mappings[j].Path
+		}
+		if mappings[i].GroupID != mappings[j].GroupID {
+			return mappings[i].GroupID < mappings[j].GroupID
+		}
+		return mappings[i].Role < mappings[j].Role
+	})
+}
+
+// PruneNeeded tells whether the plan has orphaned mappings or roles to remove
+func (m *Mapper) PruneNeeded() bool {
+	return len(m.orphanedMappings) > 0 || len(m.orphanedRoles) > 0
+}
+
+func (m *Mapper) printPrune(w io.Writer) {
+	if len(m.orphanedMappings) > 0 {
+		fmt.Fprintln(w, "*** The following orphaned mappings will be removed ***")
+		for _, mapping := range m.orphanedMappings {
+			fmt.Fprintf(w, "Group %v from Role %v\n", mapping.Path, mapping.Role)
+		}
+	}
+	if len(m.orphanedRoles) > 0 {
+		fmt.Fprintln(w, "*** The following orphaned roles will be deleted ***")
+		for _, roleName := range m.orphanedRoles {
+			fmt.Fprintf(w, "Role %v\n", roleName)
+		}
+	}
+}
+
+// Prune removes the orphaned mappings, then the orphaned roles when Config.PruneRoles is set
+func (m *Mapper) Prune(ctx context.Context) error {
+	defer m.timed("prune", time.Now())
+	if !m.PruneNeeded() {
+		return nil
+	}
+	m.applied = true
+	m.logger.Info("Removing orphaned mappings", "realm", m.config.Realm, "count", len(m.orphanedMappings))
+	for _, mapping := range m.orphanedMappings {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		removed, err := m.removeRoleFromGroup(ctx, mapping)
+		if err != nil {
+			m.audit(AUDIT_REMOVE_MAPPING, mapping.Path, mapping.Role, AUDIT_FAILURE, err)
+			m.summary.Errors++
+			mapping.Status = MAPPING_FAILED
+			mapping.Error = err.Error()
+			return err
+		}
+		if !removed {
+			m.audit(AUDIT_REMOVE_MAPPING, mapping.Path, mapping.Role, AUDIT_SKIPPED, nil)
+			mapping.Status = MAPPING_SKIPPED
+			continue
+		}
+		m.audit(AUDIT_REMOVE_MAPPING, mapping.Path, mapping.Role, AUDIT_SUCCESS, nil)
+		mapping.Status = MAPPING_REMOVED
+	}
+	m.logger.Info("Deleting orphaned roles", "realm", m.config.Realm, "count", len(m.orphanedRoles))
+	for _, roleName := range m.orphanedRoles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m.logger.Info("Deleting orphaned role", "role", roleName)
+		_, err := m.apiCall(ctx, http.MethodDelete, m.rolesPath()+"/"+url.PathEscape(roleName), nil, nil)
+		m.audit(AUDIT_DELETE_ROLE, "", roleName, AUDIT_SUCCESS, err)
+		if err != nil {
+			m.summary.Errors++
+			m.failedRoles = append(m.failedRoles, roleName)
+			return fmt.Errorf("cannot delete role %v: %w", roleName, err)
+		}
+		delete(m.roles, roleName)
+		m.deletedRoles = append(m.deletedRoles, roleName)
+	}
+	return nil
+}
+
+// removeRoleFromGroup removes the mapping, and returns false when its role no longer exists: deleting a role
+// removes its mappings, there is nothing left to remove
+func (m *Mapper) removeRoleFromGroup(ctx context.Context, mapping *GroupRoleMapping) (bool, error) {
+	role, err := m.getRoleGyName(ctx, mapping.Role)
+	if err != nil {
+		return false, fmt.Errorf("cannot remove role %v from group %v: %w", mapping.Role, mapping.Path, err)
+	}
+	if role == nil {
+		m.logger.Warn("Role of the mapping to remove no longer exists, skipping it", "group", mapping.Path, "role", mapping.Role)
+		return false, nil
+	}
+	m.logger.Info("Removing mapping", "group", mapping.Path, "role", mapping.Role)
+	if _, err := m.apiCall(ctx, http.MethodDelete, m.roleMappingsPath(mapping.GroupID), []*keycloak.Role{role}, nil); err != nil {
+		return false, fmt.Errorf("cannot remove role %v from group %v: %w", mapping.Role, mapping.Path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,180 @@
+package mapper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+// addManagedRole creates a role as if created by the tool for the group with the given path
+func addManagedRole(realm *keycloaktest.Realm, name string, groupPath string) {
+	role := realm.AddRole(name)
+	role.Attributes[DEFAULT_MANAGED_ATTRIBUTE] = []string{MANAGED_VALUE}
+	role.Attributes[SOURCE_GROUP_ATTRIBUTE] = []string{groupPath}
+}
+
+// auditEntries parses the audit lines
+func auditEntries(t *testing.T, audit *bytes.Buffer) []AuditEntry {
+	t.Helper()
+	entries := []AuditEntry{}
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid audit line %s: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestPrune(t *testing.T) {
+	for _, pruneRoles := range []bool{false, true} {
+		s, realm := newTestServer(t)
+		realm.AddGroup("/admins", "admins", "legacy", "manual")
+		realm.AddRole("admins")
+		realm.AddRole("manual")
+		// The group of role legacy was deleted, the unmanaged role manual is never pruned
+		addManagedRole(realm, "legacy", "/old")
+		config := testConfig(s)
+		config.Prune = true
+		config.PruneRoles = pruneRoles
+		m := newTestMapper(t, s, config)
+		if err := m.Plan(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		report := m.Report()
+		if len(report.OrphanedMappings) != 1 || report.OrphanedMappings[0].Role != "legacy" || report.OrphanedMappings[0].Path != "/admins" {
+			t.Fatalf("expected the orphaned mapping of /admins to legacy, got %+v", report.OrphanedMappings)
+		}
+		if err := m.Prune(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if roles := strings.Join(realm.Group("/admins").RealmRoles, ","); roles != "admins,manual" {
+			t.Errorf("expected /admins to keep admins and manual, got %s", roles)
+		}
+		if deleted := realm.Role("legacy") == nil; deleted != pruneRoles {
+			t.Errorf("expected role legacy to be deleted %v, got %v", pruneRoles, deleted)
+		}
+		if status := m.Report().OrphanedMappings[0].Status; status != MAPPING_REMOVED {
+			t.Errorf("expected the mapping to be removed, got %s", status)
+		}
+	}
+}
+
+func TestPruneSkipsDeletedRole(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins", "legacy")
+	addManagedRole(realm, "legacy", "/old")
+	audit := &bytes.Buffer{}
+	config := testConfig(s)
+	config.Prune = true
+	config.Audit = audit
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Another admin deletes the role, and its mappings, between the plan and the prune
+	if _, err := m.apiCall(context.Background(), http.MethodDelete, "admin/realms/"+TEST_REALM+"/roles/legacy", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	delete(m.roles, "legacy")
+	if err := m.Prune(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mapping := m.Report().OrphanedMappings[0]
+	if mapping.Status != MAPPING_SKIPPED {
+		t.Errorf("expected the mapping of the deleted role to be skipped, got %s", mapping.Status)
+	}
+	entries := auditEntries(t, audit)
+	if len(entries) != 1 || entries[0].Result != AUDIT_SKIPPED {
+		t.Errorf("expected the removal to be audited as skipped, got %+v", entries)
+	}
+	if s.Count(http.MethodDelete, TEST_REALM, "groups/*/role-mappings/realm") != 0 {
+		t.Error("expected no removal of the deleted role")
+	}
+}
+
+func TestPruneFailure(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins", "legacy")
+	addManagedRole(realm, "legacy", "/old")
+	audit := &bytes.Buffer{}
+	config := testConfig(s)
+	config.Prune = true
+	config.Audit = audit
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Fail = failOn(http.MethodDelete, "groups/*/role-mappings/realm", http.StatusForbidden)
+	if err := m.Prune(context.Background()); err == nil || !strings.Contains(err.Error(), "cannot remove role legacy from group /admins") {
+		t.Fatalf("expected the failed removal to be returned, got %v", err)
+	}
+	if mapping := m.Report().OrphanedMappings[0]; mapping.Status != MAPPING_FAILED {
+		t.Errorf("expected the mapping to fail, got %s", mapping.Status)
+	}
+	if entries := auditEntries(t, audit); len(entries) != 1 || entries[0].Result != AUDIT_FAILURE {
+		t.Errorf("expected the removal to be audited as failed, got %+v", entries)
+	}
+}
+
+func TestCreatedRolesProvenance(t *testing.T) {
+	for _, attribute := range []string{"", "owner"} {
+		s, realm := newTestServer(t)
+		realm.AddGroup("/eng/admins")
+		config := testConfig(s)
+		config.ManagedAttribute = attribute
+		m := newTestMapper(t, s, config)
+		if err := m.Plan(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Apply(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		key := attribute
+		if key == "" {
+			key = DEFAULT_MANAGED_ATTRIBUTE
+		}
+		role := realm.Role("admins")
+		if role == nil {
+			t.Fatal("expected role admins to be created")
+		}
+		if value := strings.Join(role.Attributes[key], ","); value != MANAGED_VALUE {
+			t.Errorf("expected attribute %s=%s, got %v", key, MANAGED_VALUE, role.Attributes)
+		}
+		if value := strings.Join(role.Attributes[SOURCE_GROUP_ATTRIBUTE], ","); value != "/eng/admins" {
+			t.Errorf("expected attribute %s=/eng/admins, got %v", SOURCE_GROUP_ATTRIBUTE, role.Attributes)
+		}
+	}
+}
+
+func TestPlanPruneSendsNoDelete(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins", "legacy")
+	addManagedRole(realm, "legacy", "/old")
+	config := testConfig(s)
+	config.Prune = true
+	config.PruneRoles = true
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m.AuditPlan()
+	report := m.Report()
+	if len(report.OrphanedMappings) != 1 || strings.Join(report.OrphanedRoles, ",") != "legacy" {
+		t.Fatalf("expected the orphaned mapping and role to be planned, got %+v", report)
+	}
+	for _, request := range s.Requests() {
+		if strings.HasPrefix(request, http.MethodDelete+" ") {
+			t.Errorf("expected no delete call before Prune, got %s", request)
+		}
+	}
+}
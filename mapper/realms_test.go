@@ -0,0 +1,61 @@
+package mapper
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRealmAllowed(t *testing.T) {
+	tests := []struct {
+		allowed  []string
+		realm    string
+		expected bool
+	}{
+		{nil, "prod", true},
+		{[]string{}, "prod", true},
+		{[]string{"test"}, "test", true},
+		{[]string{"test"}, "prod", false},
+		{[]string{"dev-*", "staging"}, "dev-eu", true},
+		{[]string{"dev-*", "staging"}, "staging", true},
+		{[]string{"dev-*", "staging"}, "prod", false},
+	}
+	for _, test := range tests {
+		if actual := (Config{AllowedRealms: test.allowed}).RealmAllowed(test.realm); actual != test.expected {
+			t.Errorf("realm %s with allowlist %v: expected %v, got %v", test.realm, test.allowed, test.expected, actual)
+		}
+	}
+}
+
+func TestPlanRefusesRealmNotAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		err     string
+	}{
+		{"allowed", []string{"test"}, ""},
+		{"empty list", nil, ""},
+		{"not allowed", []string{"prod", "dev-*"}, "realm test is not in the allowed realms [prod dev-*]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/admins")
+			config := testConfig(s)
+			config.AllowedRealms = test.allowed
+			m := newTestMapper(t, s, config)
+			err := m.Plan(context.Background())
+			if test.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				if count := s.Count("GET", TEST_REALM, "groups"); count != 0 {
+					t.Errorf("expected no group to be read, got %d calls", count)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package mapper
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// reloginTransport authenticates the requests with the tokens of source. When Keycloak rejects a token,
+// e.g. because it expired and could not be refreshed, it logs in again and resends the request once
+type reloginTransport struct {
+	base   http.RoundTripper
+	login  func() (oauth2.TokenSource, error)
+	logger *slog.Logger
+
+	// lock protects transport, which is replaced at every login
+	lock      sync.Mutex
+	transport *oauth2.Transport
+}
+
+func newReloginTransport(base http.RoundTripper, login func() (oauth2.TokenSource, error), logger *slog.Logger) (*reloginTransport, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	source, err := login()
+	if err != nil {
+		return nil, err
+	}
+	return &reloginTransport{base: base, login: login, logger: logger,
+		transport: &oauth2.Transport{Source: source, Base: base}}, nil
+}
+
+func (t *reloginTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	transport := t.transport
+	t.lock.Unlock()
+	res, err := transport.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	// The body of the first attempt was consumed, it can only be sent again if it can be recreated
+	if req.Body != nil && req.GetBody == nil {
+		return res, nil
+	}
+
+	transport, err = t.relogin(transport)
+	if err != nil {
+		t.logger.Warn("Cannot login again after an unauthorized response", "error", err)
+		return res, nil
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return transport.RoundTrip(retry)
+}
+
+// relogin logs in again, unless a concurrent request already did since the failed one was sent
+func (t *reloginTransport) relogin(failed *oauth2.Transport) (*oauth2.Transport, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.transport != failed {
+		return t.transport, nil
+	}
+	t.logger.Info("Token rejected, logging in again")
+	source, err := t.login()
+	if err != nil {
+		return nil, err
+	}
+	t.transport = &oauth2.Transport{Source: source, Base: t.base}
+	return t.transport, nil
+}
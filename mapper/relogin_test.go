@@ -0,0 +1,53 @@
+package mapper
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+func TestReloginOnExpiredToken(t *testing.T) {
+	for _, serviceAccount := range []bool{false, true} {
+		s, realm := newTestServer(t)
+		realm.AddGroup("/admins")
+		config := testConfig(s)
+		if serviceAccount {
+			config.ClientID = keycloaktest.SERVICE_CLIENT
+			config.ClientSecret = keycloaktest.SERVICE_SECRET
+		}
+		m := newTestMapper(t, s, config)
+		if err := m.Plan(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// The token expires between the plan and the apply: the POST of the role is sent again
+		s.RevokeTokens()
+		if err := m.Apply(context.Background()); err != nil {
+			t.Fatalf("service account %v: %v", serviceAccount, err)
+		}
+		if issued := s.TokensIssued(); issued != 2 {
+			t.Errorf("service account %v: expected a second login, got %d", serviceAccount, issued)
+		}
+		if roles := strings.Join(realm.Group("/admins").RealmRoles, ","); roles != "admins" {
+			t.Errorf("service account %v: expected group /admins to be mapped to admins, got %s", serviceAccount, roles)
+		}
+	}
+}
+
+func TestReloginFailure(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	m := newTestMapper(t, s, testConfig(s))
+	// The password changed since the first login: the unauthorized response is returned
+	s.RevokeTokens()
+	s.Users[keycloaktest.ADMIN_USER] = "changed"
+	err := m.Plan(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected the unauthorized response, got %v", err)
+	}
+	if count := s.Count(http.MethodPost, "", "realms/master/protocol/openid-connect/token"); count != 2 {
+		t.Errorf("expected a single attempt to login again, got %d", count)
+	}
+}
@@ -0,0 +1,169 @@
+package mapper
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+const MAPPING_CREATED = "created"
+const MAPPING_FAILED = "failed"
+const MAPPING_REMOVED = "removed"
+const MAPPING_EXISTING = "existing"
+const MAPPING_SKIPPED = "skipped"
+
+// GroupRoleMapping is a missing or orphaned mapping between a group and its role
+type GroupRoleMapping struct {
+	GroupID string `json:"groupId"`
+	Group   string `json:"group"`
+	Path    string `json:"path"`
+	Role    string `json:"role"`
+	// CurrentRoles are the roles mapped to the group before applying the mapping
+	CurrentRoles []string `json:"currentRoles,omitempty"`
+	// RolesBefore and RolesAfter count the roles mapped to the group before and after the mapping
+	RolesBefore int `json:"rolesBefore"`
+	RolesAfter  int `json:"rolesAfter"`
+	// Status is only set once the mapping is applied: created, existing when found already in place,
+	// removed, skipped when its role no longer exists, or failed
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the machine-readable view of the planned and applied changes
+type Report struct {
+	Realm string `json:"realm"`
+	// Client is the target client of the roles, empty for the realm roles
+	Client       string             `json:"client,omitempty"`
+	MissingRoles []string           `json:"missingRoles"`
+	Mappings     []GroupRoleMapping `json:"mappings"`
+	// ExistingMappings are the mappings of the selected groups already in place
+	ExistingMappings []GroupRoleMapping `json:"existingMappings,omitempty"`
+	Applied          bool               `json:"applied"`
+	CreatedRoles     []string           `json:"createdRoles,omitempty"`
+	FailedRoles      []string           `json:"failedRoles,omitempty"`
+	// The orphaned mappings and roles are only computed with Config.Prune
+	OrphanedMappings []GroupRoleMapping `json:"orphanedMappings,omitempty"`
+	OrphanedRoles    []string           `json:"orphanedRoles,omitempty"`
+	DeletedRoles     []string           `json:"deletedRoles,omitempty"`
+	// MissingComposites are the roles of the sub-groups to add to the role of their parent group,
+	// by parent role. Only computed with Config.CompositeRoles
+	MissingComposites map[string][]string `json:"missingComposites,omitempty"`
+	// GroupsWithUnexpectedRoles are only computed with Config.CheckUnexpectedRoles
+	GroupsWithUnexpectedRoles []UnexpectedRoles `json:"groupsWithUnexpectedRoles,omitempty"`
+	// RoleConflicts are only computed with Config.ValidateRoles
+	RoleConflicts []RoleConflict `json:"roleConflicts,omitempty"`
+}
+
+// Report returns the planned changes, and their outcome once applied
+func (m *Mapper) Report() Report {
+	report := Report{
+		Realm:             m.config.Realm,
+		Client:            m.config.TargetClient,
+		MissingRoles:      m.missingRoles,
+		Mappings:          []GroupRoleMapping{},
+		Applied:           m.applied,
+		CreatedRoles:      m.createdRoles,
+		FailedRoles:       m.failedRoles,
+		OrphanedRoles:     m.orphanedRoles,
+		DeletedRoles:      m.deletedRoles,
+		MissingComposites: m.missingComposites,
+	}
+	if len(m.groupsWithUnexpectedRoles) > 0 {
+		report.GroupsWithUnexpectedRoles = m.sortedUnexpectedRoles()
+	}
+	if len(m.roleConflicts) > 0 {
+		report.RoleConflicts = m.roleConflicts
+	}
+	for _, mapping := range m.groupsWithMissingRole {
+		report.Mappings = append(report.Mappings, *mapping)
+	}
+	for _, mapping := range m.existingMappings {
+		report.ExistingMappings = append(report.ExistingMappings, *mapping)
+	}
+	for _, mapping := range m.orphanedMappings {
+		report.OrphanedMappings = append(report.OrphanedMappings, *mapping)
+	}
+	// Sorted to let CI jobs diff the reports of different runs
+	sortMappings(report.Mappings)
+	sortMappings(report.ExistingMappings)
+	sortMappings(report.OrphanedMappings)
+	return report
+}
+
+func sortMappings(mappings []GroupRoleMapping) {
+	sort.Slice(mappings, func(i, j int) bool {
+		if mappings[i].Path != mappings[j].Path {
+			return mappings[i].Path < mappings[j].Path
+		}
+		if mappings[i].GroupID != mappings[j].GroupID {
+			return mappings[i].GroupID < mappings[j].GroupID
+		}
+		return mappings[i].Role < mappings[j].Role
+	})
+}
+
+// PrintPlan prints the planned changes as text
+func (m *Mapper) PrintPlan(w io.Writer) {
+	if m.config.TargetClient != "" {
+		fmt.Fprintf(w, "*** Realm %v, client %v ***\n", m.config.Realm, m.config.TargetClient)
+	} else {
+		fmt.Fprintf(w, "*** Realm %v ***\n", m.config.Realm)
+	}
+	if m.PruneNeeded() {
+		m.printPrune(w)
+	}
+	if m.ChangesNeeded() {
+		if m.config.SkipRoles {
+			fmt.Fprintln(w, "*** The following roles are missing, they will not be created ***")
+		} else {
+			fmt.Fprintln(w, "*** The following missing roles will be created ***")
+		}
+		for _, roleName := range m.missingRoles {
+			fmt.Fprintf(w, "Role %v\n", roleName)
+		}
+		if m.config.SkipMappings {
+			fmt.Fprintln(w, "*** The following mappings are missing, they will not be created ***")
+		} else {
+			fmt.Fprintln(w, "*** The following mappings will be created ***")
+		}
+		for _, mapping := range m.sortedMappings() {
+			fmt.Fprintf(w, "Group %v to Role %v (%d roles → %d roles)\n", mapping.Path, mapping.Role, mapping.RolesBefore,
+				mapping.RolesAfter)
+		}
+		m.printComposites(w)
+	} else if !m.PruneNeeded() {
+		fmt.Fprintln(w, "*** All roles and mappings are already set, no changes needed ***")
+	}
+	m.printUnexpectedRoles(w)
+	m.printRoleConflicts(w)
+}
+
+// PrintDiff prints the planned changes as a unified diff between the roles currently mapped to each
+// group and the desired ones
+func (m *Mapper) PrintDiff(w io.Writer) {
+	report := m.Report()
+	fmt.Fprintf(w, "--- %s (current)\n", report.Realm)
+	fmt.Fprintf(w, "+++ %s (desired)\n", report.Realm)
+	if len(report.MissingRoles) > 0 || len(report.OrphanedRoles) > 0 {
+		fmt.Fprintln(w, "@@ roles @@")
+		for _, roleName := range report.OrphanedRoles {
+			fmt.Fprintf(w, "-%s\n", roleName)
+		}
+		for _, roleName := range report.MissingRoles {
+			fmt.Fprintf(w, "+%s\n", roleName)
+		}
+	}
+	for _, mapping := range report.OrphanedMappings {
+		fmt.Fprintf(w, "@@ group %s @@\n", mapping.Path)
+		fmt.Fprintf(w, "-%s\n", mapping.Role)
+	}
+	for _, mapping := range report.Mappings {
+		fmt.Fprintf(w, "@@ group %s @@\n", mapping.Path)
+		roles := append([]string{}, mapping.CurrentRoles...)
+		sort.Strings(roles)
+		for _, roleName := range roles {
+			fmt.Fprintf(w, " %s\n", roleName)
+		}
+		fmt.Fprintf(w, "+%s\n", mapping.Role)
+	}
+}
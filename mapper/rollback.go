@@ -0,0 +1,49 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+)
+
+// Rollback lists the changes actually applied to a realm, to undo them later. The roles and mappings
+// found already in place are not listed
+type Rollback struct {
+	Realm           string             `json:"realm"`
+	CreatedRoles    []string           `json:"createdRoles"`
+	CreatedMappings []GroupRoleMapping `json:"createdMappings"`
+}
+
+// Rollback returns the changes applied by Apply
+func (m *Mapper) Rollback() Rollback {
+	rollback := Rollback{Realm: m.config.Realm, CreatedRoles: append([]string{}, m.createdRoles...),
+		CreatedMappings: []GroupRoleMapping{}}
+	for _, mapping := range m.sortedMappings() {
+		if mapping.Status == MAPPING_CREATED {
+			rollback.CreatedMappings = append(rollback.CreatedMappings, *mapping)
+		}
+	}
+	return rollback
+}
+
+// LoadRollback replaces the plan with the inverse of the changes of the rollback: the created mappings
+// are planned for removal and the created roles for deletion, as orphans. They are printed by PrintPlan
+// and undone by Prune
+func (m *Mapper) LoadRollback(ctx context.Context, rollback Rollback) error {
+	m.reset()
+	if rollback.Realm != m.config.Realm {
+		return fmt.Errorf("the rollback is for realm %s, not %s", rollback.Realm, m.config.Realm)
+	}
+	if err := m.validateRealm(ctx); err != nil {
+		return err
+	}
+	if err := m.resolveTargetClient(ctx); err != nil {
+		return err
+	}
+	for i := range rollback.CreatedMappings {
+		mapping := rollback.CreatedMappings[i]
+		mapping.Status = ""
+		m.orphanedMappings = append(m.orphanedMappings, &mapping)
+	}
+	m.orphanedRoles = append(m.orphanedRoles, rollback.CreatedRoles...)
+	return nil
+}
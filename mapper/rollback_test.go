@@ -0,0 +1,61 @@
+package mapper
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRollbackRecordsOnlyAppliedChanges(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	realm.AddGroup("/ops")
+	realm.AddRole("ops")
+	realm.AddGroup("/users", "users")
+	realm.AddRole("users")
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The role ops and the mapping of /users were already in place
+	rollback := m.Rollback()
+	if roles := strings.Join(rollback.CreatedRoles, ","); roles != "admins" {
+		t.Errorf("expected the created role admins, got %s", roles)
+	}
+	mappings := []string{}
+	for _, mapping := range rollback.CreatedMappings {
+		mappings = append(mappings, mapping.Path+"="+mapping.Role)
+	}
+	if strings.Join(mappings, ",") != "/admins=admins,/ops=ops" {
+		t.Errorf("expected the created mappings of /admins and /ops, got %v", mappings)
+	}
+
+	m = newTestMapper(t, s, testConfig(s))
+	if err := m.LoadRollback(context.Background(), rollback); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Prune(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if roles := strings.Join(realm.RoleNames(), ","); roles != "ops,users" {
+		t.Errorf("expected the roles ops and users to be left, got %s", roles)
+	}
+	for groupPath, expected := range map[string]string{"/admins": "", "/ops": "", "/users": "users"} {
+		if roles := strings.Join(realm.Group(groupPath).RealmRoles, ","); roles != expected {
+			t.Errorf("expected group %s to be mapped to %q after the rollback, got %q", groupPath, expected, roles)
+		}
+	}
+}
+
+func TestLoadRollbackOfOtherRealm(t *testing.T) {
+	s, _ := newTestServer(t)
+	m := newTestMapper(t, s, testConfig(s))
+	err := m.LoadRollback(context.Background(), Rollback{Realm: "other"})
+	if err == nil || !strings.Contains(err.Error(), "the rollback is for realm other, not test") {
+		t.Errorf("expected the rollback of another realm to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package mapper
+
+import "fmt"
+
+// Select keeps only the planned roles and mappings approved by the approve function, which is called
+// with the description of each change: the missing roles first, then the mappings sorted by group path.
+// The mappings and composites of the rejected roles are dropped without asking, as they cannot be created.
+// An error of the approve function stops the selection and is returned
+func (m *Mapper) Select(approve func(change string) (bool, error)) error {
+	rejectedRoles := map[string]bool{}
+	if !m.config.SkipRoles {
+		approvedRoles := []string{}
+		for _, roleName := range m.missingRoles {
+			approved, err := approve(fmt.Sprintf("Create role %v", roleName))
+			if err != nil {
+				return err
+			}
+			if approved {
+				approvedRoles = append(approvedRoles, roleName)
+			} else {
+				rejectedRoles[roleName] = true
+				delete(m.roleSourceGroups, roleName)
+			}
+		}
+		m.missingRoles = approvedRoles
+		m.summary.PlannedRoles = len(m.missingRoles)
+	}
+
+	if !m.config.SkipMappings {
+		for _, mapping := range m.sortedMappings() {
+			approved := false
+			if !rejectedRoles[mapping.Role] {
+				var err error
+				approved, err = approve(fmt.Sprintf("Map group %v to role %v", mapping.Path, mapping.Role))
+				if err != nil {
+					return err
+				}
+			}
+			if !approved {
+				delete(m.groupsWithMissingRole, mappingKey(mapping.GroupID, mapping.Role))
+			}
+		}
+		m.summary.PlannedMappings = len(m.groupsWithMissingRole)
+	}
+
+	for parentRole, children := range m.missingComposites {
+		if rejectedRoles[parentRole] {
+			delete(m.missingComposites, parentRole)
+			continue
+		}
+		kept := []string{}
+		for _, childRole := range children {
+			if !rejectedRoles[childRole] {
+				kept = append(kept, childRole)
+			}
+		}
+		if len(kept) == 0 {
+			delete(m.missingComposites, parentRole)
+		} else {
+			m.missingComposites[parentRole] = kept
+		}
+	}
+	return nil
+}
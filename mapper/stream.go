@@ -0,0 +1,27 @@
+package mapper
+
+import (
+	"encoding/json"
+)
+
+// PlannedChange is a change written as a JSON line to Config.Stream as soon as it is planned, with the
+// actions of the audit entries
+type PlannedChange struct {
+	Realm  string `json:"realm"`
+	Client string `json:"client,omitempty"`
+	Group  string `json:"group,omitempty"`
+	Role   string `json:"role"`
+	Action string `json:"action"`
+}
+
+// stream writes the planned change to Config.Stream. The callers serialize the calls
+func (m *Mapper) stream(action string, groupPath string, roleName string) {
+	if m.config.Stream == nil {
+		return
+	}
+	change := PlannedChange{Realm: m.config.Realm, Client: m.config.TargetClient, Group: groupPath, Role: roleName, Action: action}
+	line, _ := json.Marshal(change)
+	if _, err := m.config.Stream.Write(append(line, '\n')); err != nil {
+		m.logger.Error("Cannot stream planned change", "change", string(line), "error", err)
+	}
+}
@@ -0,0 +1,55 @@
+package mapper
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// lineWriter records each write, to check that every line is written on its own
+type lineWriter struct {
+	writes []string
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestStream(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins")
+	realm.AddGroup("/users")
+	realm.AddRole("users")
+	realm.AddGroup("/ops", "ops")
+	realm.AddRole("ops")
+	stream := &lineWriter{}
+	config := testConfig(s)
+	config.Stream = stream
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	changes := []string{}
+	for _, write := range stream.writes {
+		if !strings.HasSuffix(write, "\n") || strings.Count(write, "\n") != 1 {
+			t.Errorf("expected a single line per write, got %q", write)
+		}
+		var change PlannedChange
+		if err := json.Unmarshal([]byte(write), &change); err != nil {
+			t.Fatalf("cannot parse streamed line %q: %v", write, err)
+		}
+		if change.Realm != TEST_REALM || change.Client != "" {
+			t.Errorf("expected a change of realm %s, got %+v", TEST_REALM, change)
+		}
+		changes = append(changes, change.Action+" "+change.Group+" "+change.Role)
+	}
+	sort.Strings(changes)
+	// The mapping already in place is not streamed
+	expected := []string{"create-mapping /admins admins", "create-mapping /users users", "create-role /admins admins"}
+	if strings.Join(changes, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected the changes %v, got %v", expected, changes)
+	}
+}
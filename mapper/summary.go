@@ -0,0 +1,27 @@
+package mapper
+
+// Summary counts the planned and applied changes
+type Summary struct {
+	GroupsProcessed int
+	PlannedRoles    int
+	PlannedMappings int
+	RolesCreated    int
+	MappingsCreated int
+	// SkippedExisting are the mappings and roles found already in place
+	SkippedExisting int
+	Errors          int
+	// Warnings are the anomalies found while planning, like malformed groups or unexpected roles
+	Warnings int
+}
+
+// Add adds the counters of another summary, e.g. to sum up the realms of a run
+func (s *Summary) Add(other Summary) {
+	s.GroupsProcessed += other.GroupsProcessed
+	s.PlannedRoles += other.PlannedRoles
+	s.PlannedMappings += other.PlannedMappings
+	s.RolesCreated += other.RolesCreated
+	s.MappingsCreated += other.MappingsCreated
+	s.SkippedExisting += other.SkippedExisting
+	s.Errors += other.Errors
+	s.Warnings += other.Warnings
+}
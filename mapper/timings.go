@@ -0,0 +1,28 @@
+package mapper
+
+import "time"
+
+// timed logs the duration of a phase of the run at debug level, to spot slow Keycloak servers. It is
+// meant to be deferred at the start of the phase
+func (m *Mapper) timed(phase string, start time.Time) {
+	m.logger.Debug("Phase completed", "realm", m.config.Realm, "phase", phase, "elapsed", time.Since(start))
+}
+
+// recordRoleLookup accounts for a role read from Keycloak since start
+func (m *Mapper) recordRoleLookup(start time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.roleLookups++
+	m.roleLookupTime += time.Since(start)
+}
+
+// logRoleLookups logs the count and the average latency of the role lookups at debug level
+func (m *Mapper) logRoleLookups() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.roleLookups == 0 {
+		return
+	}
+	m.logger.Debug("Role lookups", "realm", m.config.Realm, "count", m.roleLookups,
+		"average", m.roleLookupTime/time.Duration(m.roleLookups))
+}
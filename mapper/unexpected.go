@@ -0,0 +1,95 @@
+package mapper
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/zemirco/keycloak"
+)
+
+// Built-in roles of every realm, granted to the users by Keycloak: they are never unexpected
+const OFFLINE_ACCESS_ROLE = "offline_access"
+const UMA_AUTHORIZATION_ROLE = "uma_authorization"
+
+// DEFAULT_ROLES_PREFIX is followed by the realm name, in lower case, in the name of the default role of the realm
+const DEFAULT_ROLES_PREFIX = "default-roles-"
+
+// UnexpectedRoles are the roles mapped to a group other than its own role and the allowed roles
+type UnexpectedRoles struct {
+	GroupID string   `json:"groupId"`
+	Path    string   `json:"path"`
+	Roles   []string `json:"roles"`
+}
+
+// checkUnexpectedRoles records the roles of the group that are neither its own roles nor allowed by
+// Config.AllowedRoles
+func (m *Mapper) checkUnexpectedRoles(g *keycloak.Group, groupPath string, roleNames []string) {
+	unexpected := m.unexpectedRoles(g, roleNames)
+	if len(unexpected) == 0 {
+		return
+	}
+	m.warn("Found unexpected roles", "group", groupPath, "roles", unexpected)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.groupsWithUnexpectedRoles = append(m.groupsWithUnexpectedRoles, UnexpectedRoles{GroupID: *g.ID, Path: groupPath, Roles: unexpected})
+}
+
+// unexpectedRoles returns the roles of the group, sorted by name, that are neither in roleNames, allowed
+// by Config.AllowedRoles nor built in the realm. MAPPING_MODE_EXACT removes them from the group
+func (m *Mapper) unexpectedRoles(g *keycloak.Group, roleNames []string) []string {
+	own := map[string]bool{}
+	for _, roleName := range roleNames {
+		own[roleName] = true
+	}
+	unexpected := []string{}
+	for _, r := range m.currentRoles(g) {
+		if !own[r] && !m.roleAllowed(r) && !m.builtInRole(r) {
+			unexpected = append(unexpected, r)
+		}
+	}
+	sort.Strings(unexpected)
+	return unexpected
+}
+
+func (m *Mapper) roleAllowed(roleName string) bool {
+	for _, pattern := range m.config.AllowedRoles {
+		if matched, _ := path.Match(pattern, roleName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// builtInRole tells whether the role is a built-in realm role, like offline_access or the default role of the realm
+func (m *Mapper) builtInRole(roleName string) bool {
+	if m.config.TargetClient != "" {
+		return false
+	}
+	return roleName == OFFLINE_ACCESS_ROLE || roleName == UMA_AUTHORIZATION_ROLE ||
+		roleName == DEFAULT_ROLES_PREFIX+strings.ToLower(m.config.Realm)
+}
+
+// sortedUnexpectedRoles returns the groups with unexpected roles sorted by group path
+func (m *Mapper) sortedUnexpectedRoles() []UnexpectedRoles {
+	groups := append([]UnexpectedRoles{}, m.groupsWithUnexpectedRoles...)
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Path != groups[j].Path {
+			return groups[i].Path < groups[j].Path
+		}
+		return groups[i].GroupID < groups[j].GroupID
+	})
+	return groups
+}
+
+func (m *Mapper) printUnexpectedRoles(w io.Writer) {
+	if len(m.groupsWithUnexpectedRoles) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "*** The following groups are mapped to unexpected roles ***")
+	for _, group := range m.sortedUnexpectedRoles() {
+		fmt.Fprintf(w, "Group %v to Roles %v\n", group.Path, group.Roles)
+	}
+}
@@ -0,0 +1,110 @@
+package mapper
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// builtInRoles are the built-in roles of TEST_REALM
+var builtInRoles = []string{"default-roles-test", "offline_access", "uma_authorization"}
+
+func TestMappingModes(t *testing.T) {
+	tests := []struct {
+		mode     string
+		expected string
+		removed  string
+	}{
+		{MAPPING_MODE_ENSURE_PRESENT, "admins,auditor,default-roles-test,legacy,offline_access,uma_authorization", ""},
+		// The allowed and the built-in roles are kept
+		{MAPPING_MODE_EXACT, "admins,auditor,default-roles-test,offline_access,uma_authorization", "/admins legacy"},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddGroup("/admins", append([]string{"legacy", "auditor"}, builtInRoles...)...)
+			for _, role := range append([]string{"legacy", "auditor"}, builtInRoles...) {
+				realm.AddRole(role)
+			}
+			config := testConfig(s)
+			config.MappingMode = test.mode
+			config.AllowedRoles = []string{"audit*"}
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			removed := []string{}
+			for _, mapping := range m.Report().OrphanedMappings {
+				removed = append(removed, mapping.Path+" "+mapping.Role)
+			}
+			if actual := strings.Join(removed, ","); actual != test.removed {
+				t.Errorf("expected the removal of %q, got %q", test.removed, actual)
+			}
+			if err := m.Apply(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Prune(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			roles := append([]string{}, realm.Group("/admins").RealmRoles...)
+			sort.Strings(roles)
+			if actual := strings.Join(roles, ","); actual != test.expected {
+				t.Errorf("expected group /admins to be mapped to %s, got %s", test.expected, actual)
+			}
+			// The roles themselves are kept
+			if realm.Role("legacy") == nil {
+				t.Error("expected role legacy to be kept")
+			}
+		})
+	}
+}
+
+func TestExtraMappingsOrder(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddRole("legacy")
+	realm.AddRole("old")
+	paths := []string{"/zeta", "/beta", "/delta", "/alpha", "/gamma", "/epsilon", "/eta", "/theta"}
+	for _, groupPath := range paths {
+		realm.AddGroup(groupPath, "old", "legacy")
+	}
+	sort.Strings(paths)
+	expected := ""
+	for _, groupPath := range paths {
+		expected += "Group " + groupPath + " from Role legacy\nGroup " + groupPath + " from Role old\n"
+	}
+	config := testConfig(s)
+	config.MappingMode = MAPPING_MODE_EXACT
+	m := newTestMapper(t, s, config)
+	for i := 0; i < 5; i++ {
+		if err := m.Plan(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		m.printPrune(&out)
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected the extra mappings in the order of the paths, got\n%s", out.String())
+		}
+	}
+}
+
+func TestUnexpectedRoles(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddGroup("/admins", append([]string{"admins", "legacy", "auditor"}, builtInRoles...)...)
+	realm.AddGroup("/users", "users")
+	config := testConfig(s)
+	config.CheckUnexpectedRoles = true
+	config.AllowedRoles = []string{"audit*"}
+	m := newTestMapper(t, s, config)
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	unexpected := m.Report().GroupsWithUnexpectedRoles
+	if len(unexpected) != 1 || unexpected[0].Path != "/admins" || strings.Join(unexpected[0].Roles, ",") != "legacy" {
+		t.Errorf("expected the role legacy of /admins to be unexpected, got %+v", unexpected)
+	}
+	if warnings := m.Summary().Warnings; warnings != 1 {
+		t.Errorf("expected a warning, got %d", warnings)
+	}
+}
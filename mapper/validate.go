@@ -0,0 +1,102 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/zemirco/keycloak"
+)
+
+// RoleConflict is a missing role whose creation may fail or be confusing, found with Config.ValidateRoles
+type RoleConflict struct {
+	Role   string `json:"role"`
+	Reason string `json:"reason"`
+}
+
+// validateMissingRoles looks for the existing roles that the missing roles may conflict with, with
+// read-only calls: the roles differing only by case, which some databases reject, and the roles with the
+// same name in another container, realm or client, which are easily mistaken for one another
+func (m *Mapper) validateMissingRoles(ctx context.Context) error {
+	if len(m.missingRoles) == 0 {
+		return nil
+	}
+	existing := map[string]string{}
+	for name := range m.roles {
+		existing[strings.ToLower(name)] = name
+	}
+	others, err := m.otherContainerRoles(ctx)
+	if err != nil {
+		return err
+	}
+	for _, roleName := range m.missingRoles {
+		if strings.IndexFunc(roleName, illegalRoleNameChar) >= 0 {
+			m.addConflict(roleName, "spaces, control characters, / \\ and % break the role APIs")
+		}
+		if name, found := existing[strings.ToLower(roleName)]; found {
+			m.addConflict(roleName, fmt.Sprintf("role %s differs only by case", name))
+		}
+		if containers := others[roleName]; len(containers) > 0 {
+			m.addConflict(roleName, fmt.Sprintf("a role with the same name exists in %s", strings.Join(containers, ", ")))
+		}
+	}
+	return nil
+}
+
+func (m *Mapper) addConflict(roleName string, reason string) {
+	m.warn("Planned role may conflict with an existing role", "role", roleName, "reason", reason)
+	m.roleConflicts = append(m.roleConflicts, RoleConflict{Role: roleName, Reason: reason})
+}
+
+// otherContainerRoles returns the containers of the roles other than the mapped ones, by role name: the
+// realm, as "realm", when the roles of a target client are mapped, and the clients, as "client <clientId>"
+func (m *Mapper) otherContainerRoles(ctx context.Context) (map[string][]string, error) {
+	others := map[string][]string{}
+	if m.config.TargetClient != "" {
+		roles, _, err := listPages[*keycloak.Role](ctx, m, fmt.Sprintf("admin/realms/%s/roles", m.config.Realm))
+		if err != nil {
+			return nil, fmt.Errorf("cannot list roles of realm %s: %w", m.config.Realm, err)
+		}
+		for _, role := range roles {
+			if role.Name != nil {
+				others[*role.Name] = append(others[*role.Name], "realm")
+			}
+		}
+	}
+	clients, _, err := listPages[*clientRepresentation](ctx, m, fmt.Sprintf("admin/realms/%s/clients", m.config.Realm))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list clients of realm %s: %w", m.config.Realm, err)
+	}
+	for _, c := range clients {
+		if c.ID == nil || c.ClientID == nil || *c.ID == m.targetClientID {
+			continue
+		}
+		path := fmt.Sprintf("admin/realms/%s/clients/%s/roles", m.config.Realm, url.PathEscape(*c.ID))
+		roles, _, err := listPages[*keycloak.Role](ctx, m, path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list roles of client %s: %w", *c.ClientID, err)
+		}
+		for _, role := range roles {
+			if role.Name != nil {
+				others[*role.Name] = append(others[*role.Name], "client "+*c.ClientID)
+			}
+		}
+	}
+	for name := range others {
+		sort.Strings(others[name])
+	}
+	return others, nil
+}
+
+func (m *Mapper) printRoleConflicts(w io.Writer) {
+	if len(m.roleConflicts) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "*** The following planned roles may conflict with existing roles ***")
+	for _, conflict := range m.roleConflicts {
+		fmt.Fprintf(w, "Role %v: %v\n", conflict.Role, conflict.Reason)
+	}
+}
@@ -0,0 +1,79 @@
+package mapper
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+func TestValidateRoles(t *testing.T) {
+	tests := []struct {
+		name      string
+		group     string
+		client    string
+		setup     func(realm *keycloaktest.Realm)
+		conflicts string
+	}{
+		{"no conflict", "/admins", "", func(realm *keycloaktest.Realm) {}, ""},
+		{"client role", "/admins", "", func(realm *keycloaktest.Realm) { realm.AddClient("web").AddRole("admins") },
+			"admins: a role with the same name exists in client web"},
+		{"case", "/admins", "", func(realm *keycloaktest.Realm) { realm.AddRole("Admins") },
+			"admins: role Admins differs only by case"},
+		{"illegal characters", "/my team", "", func(realm *keycloaktest.Realm) {},
+			"my team: spaces, control characters, / \\ and % break the role APIs"},
+		{"realm role of a client role", "/admins", "web", func(realm *keycloaktest.Realm) { realm.AddRole("admins") },
+			"admins: a role with the same name exists in realm"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, realm := newTestServer(t)
+			realm.AddClient("app")
+			if test.client != "" {
+				realm.AddClient(test.client)
+			}
+			test.setup(realm)
+			realm.AddGroup(test.group)
+			config := testConfig(s)
+			config.TargetClient = test.client
+			config.ValidateRoles = true
+			m := newTestMapper(t, s, config)
+			if err := m.Plan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			conflicts := []string{}
+			for _, conflict := range m.Report().RoleConflicts {
+				conflicts = append(conflicts, conflict.Role+": "+conflict.Reason)
+			}
+			if actual := strings.Join(conflicts, "; "); actual != test.conflicts {
+				t.Errorf("expected the conflicts %q, got %q", test.conflicts, actual)
+			}
+			if warnings := m.Summary().Warnings; warnings != len(conflicts) {
+				t.Errorf("expected a warning per conflict, got %d", warnings)
+			}
+			for _, request := range s.Requests() {
+				if !strings.HasPrefix(request, http.MethodGet+" ") && !strings.HasSuffix(request, "/token") {
+					t.Errorf("expected read-only validation calls, got %s", request)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRolesDisabled(t *testing.T) {
+	s, realm := newTestServer(t)
+	realm.AddClient("web").AddRole("admins")
+	realm.AddGroup("/admins")
+	m := newTestMapper(t, s, testConfig(s))
+	if err := m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts := m.Report().RoleConflicts; len(conflicts) != 0 {
+		t.Errorf("expected no validation without Config.ValidateRoles, got %v", conflicts)
+	}
+	if count := s.Count(http.MethodGet, TEST_REALM, "clients"); count != 0 {
+		t.Errorf("expected the clients not to be listed, got %d calls", count)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// TEST_PROPS are the required properties
+const TEST_PROPS = `keycloak.url=http://localhost:8080
+keycloak.user=admin
+keycloak.password=secret
+keycloak.realm=test
+`
+
+func TestMain(m *testing.M) {
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	os.Exit(m.Run())
+}
+
+// writeTestFile writes the content to a file of a temporary directory and returns its path
+func writeTestFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// setFlag sets a flag variable for the duration of the test
+func setFlag[T any](t *testing.T, flag *T, value T) {
+	previous := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = previous })
+}
+
+// loadTestProps reads the configuration file with the given name and content into a new runner
+func loadTestProps(t *testing.T, name string, content string) (*runner, error) {
+	t.Helper()
+	setFlag(t, &propsFile, writeTestFile(t, name, content))
+	r := newRunner()
+	r.console = io.Discard
+	return r, r.initProps()
+}
+
+func TestNormalizeBasePath(t *testing.T) {
+	tests := map[string]string{
+		"":           "",
+		"/":          "",
+		" ":          "",
+		"auth":       "/auth",
+		"/auth":      "/auth",
+		"auth/":      "/auth",
+		"/identity/": "/identity",
+		"/a/b/":      "/a/b",
+	}
+	for basePath, expected := range tests {
+		if actual := normalizeBasePath(basePath); actual != expected {
+			t.Errorf("normalizeBasePath(%q): expected %q, got %q", basePath, expected, actual)
+		}
+	}
+}
+
+func TestBasePathProperties(t *testing.T) {
+	tests := []struct {
+		props    string
+		expected string
+		err      string
+	}{
+		{"", "", ""},
+		{"keycloak.base.path=/auth\n", "/auth", ""},
+		{"keycloak.base.path=auth/\n", "/auth", ""},
+		{"keycloak.context.path=/identity\n", "/identity", ""},
+		{"keycloak.base.path=/auth\nkeycloak.context.path=auth\n", "/auth", ""},
+		{"keycloak.base.path=/auth\nkeycloak.context.path=/identity\n", "", "cannot be combined"},
+	}
+	for _, test := range tests {
+		r, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS+test.props)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error %q, got %v", test.props, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.props, err)
+		} else if r.config.BasePath != test.expected {
+			t.Errorf("%q: expected base path %q, got %q", test.props, test.expected, r.config.BasePath)
+		}
+	}
+}
+
+// TestConfigProperties checks the mapper settings read from the properties
+func TestConfigProperties(t *testing.T) {
+	tests := []struct {
+		props string
+		check func(config mapper.Config) bool
+	}{
+		{"", func(c mapper.Config) bool { return c.RolePrefix == "" && c.RoleSuffix == "" }},
+		{"role.name.prefix=grp_\nrole.name.suffix=_role\n", func(c mapper.Config) bool {
+			return c.RolePrefix == "grp_" && c.RoleSuffix == "_role"
+		}},
+		{"", func(c mapper.Config) bool { return c.RoleNameFrom == mapper.ROLE_NAME_FROM_NAME }},
+		{"role.name.from=path\n", func(c mapper.Config) bool { return c.RoleNameFrom == mapper.ROLE_NAME_FROM_PATH }},
+		{"", func(c mapper.Config) bool { return c.ManagedAttribute == mapper.DEFAULT_MANAGED_ATTRIBUTE }},
+		{"role.managed.attribute=owner\n", func(c mapper.Config) bool { return c.ManagedAttribute == "owner" }},
+		{"", func(c mapper.Config) bool { return c.TokenClientID == mapper.DEFAULT_TOKEN_CLIENT_ID }},
+		{"keycloak.token.client.id=automation\n", func(c mapper.Config) bool { return c.TokenClientID == "automation" }},
+		{"", func(c mapper.Config) bool { return c.AuthRealm == mapper.MASTER_REALM }},
+		{"", func(c mapper.Config) bool { return c.RoleDescription == mapper.DEFAULT_ROLE_DESCRIPTION }},
+		{"role.description.template=Role of {group}\n", func(c mapper.Config) bool { return c.RoleDescription == "Role of {group}" }},
+		{"keycloak.auth.realm=ops\n", func(c mapper.Config) bool { return c.AuthRealm == "ops" }},
+		{"", func(c mapper.Config) bool { return !c.SkipRoles && !c.SkipMappings }},
+		{"create.roles=false\n", func(c mapper.Config) bool { return c.SkipRoles && !c.SkipMappings }},
+		{"create.mappings=false\n", func(c mapper.Config) bool { return !c.SkipRoles && c.SkipMappings }},
+		{"role.attributes.team={name}\nrole.attributes.cost-center=cc-42\n", func(c mapper.Config) bool {
+			return len(c.RoleAttributes) == 2 && c.RoleAttributes["team"] == "{name}" && c.RoleAttributes["cost-center"] == "cc-42"
+		}},
+		{"dry.run.only=true\n", func(c mapper.Config) bool { return !c.ValidateRoles }},
+		{"dry.run.only=true\ndry.run.validate=true\n", func(c mapper.Config) bool { return c.ValidateRoles }},
+		// The validation only applies to the dry runs
+		{"dry.run.validate=true\n", func(c mapper.Config) bool { return !c.ValidateRoles }},
+		{"", func(c mapper.Config) bool { return c.MappingMode == mapper.MAPPING_MODE_ENSURE_PRESENT }},
+		{"mapping.mode=exact\n", func(c mapper.Config) bool { return c.MappingMode == mapper.MAPPING_MODE_EXACT }},
+		{"", func(c mapper.Config) bool { return len(c.AlwaysAddRoles) == 0 }},
+		{"role.always.add=default-user, audit\n", func(c mapper.Config) bool {
+			return strings.Join(c.AlwaysAddRoles, ",") == "default-user,audit"
+		}},
+		{"", func(c mapper.Config) bool { return !c.DefaultRoles }},
+		{"role.default=true\n", func(c mapper.Config) bool { return c.DefaultRoles }},
+		{"", func(c mapper.Config) bool { return !c.CompositeRoles }},
+		{"role.composite=true\n", func(c mapper.Config) bool { return c.CompositeRoles }},
+		{"role.inherit.parent=true\n", func(c mapper.Config) bool { return c.CompositeRoles }},
+		{"", func(c mapper.Config) bool { return !c.SkipEmptyGroups }},
+		{"skip.empty.groups=true\n", func(c mapper.Config) bool { return c.SkipEmptyGroups }},
+	}
+	for _, test := range tests {
+		r, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS+test.props)
+		if err != nil {
+			t.Errorf("%q: %v", test.props, err)
+		} else if !test.check(r.config) {
+			t.Errorf("%q: unexpected config %v", test.props, r.config)
+		}
+	}
+}
+
+// TestInvalidProperties checks that the invalid properties are rejected with an explicit error
+func TestInvalidProperties(t *testing.T) {
+	tests := []struct {
+		props string
+		err   string
+	}{
+		{"role.name.from=id\n", "invalid role.name.from id: must be name or path"},
+		{"role.managed.attribute=\n", "invalid role.managed.attribute: must not be empty"},
+		{"role.attributes.managed-by=x\n", "the managed-by attribute is reserved"},
+		{"role.attributes.source-group=x\n", "the source-group attribute is reserved"},
+		{"create.roles=false\nrole.default=true\n", "cannot be combined with create.roles=false"},
+		{"mapping.mode=sync\n", "invalid mapping.mode sync: must be ensure-present or exact"},
+		{"role.name.sanitize=strip\n", "invalid role.name.sanitize strip: must be none, reject or replace"},
+	}
+	for _, test := range tests {
+		_, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS+test.props)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expected error %q, got %v", test.props, test.err, err)
+		}
+	}
+}
+
+// TestPropertiesFromEnv checks that the environment variables take precedence over the properties
+func TestPropertiesFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		props string
+		check func(r *runner) bool
+	}{
+		{"url", map[string]string{"KEYCLOAK_URL": "https://sso.example.com"}, "",
+			func(r *runner) bool { return r.config.Server == "https://sso.example.com" }},
+		{"user", map[string]string{"KEYCLOAK_USER": "ci"}, "",
+			func(r *runner) bool { return r.config.User == "ci" }},
+		{"password", map[string]string{"KEYCLOAK_PASSWORD": "from-env"}, "",
+			func(r *runner) bool { return r.config.Password == "from-env" }},
+		{"realm", map[string]string{"KEYCLOAK_REALM": "prod"}, "",
+			func(r *runner) bool { return strings.Join(r.realms, ",") == "prod" }},
+		{"realm over realms", map[string]string{"KEYCLOAK_REALM": "prod"}, "keycloak.realms=dev,staging\n",
+			func(r *runner) bool { return strings.Join(r.realms, ",") == "prod" }},
+		{"realms without env", nil, "keycloak.realms=dev,staging\n",
+			func(r *runner) bool { return strings.Join(r.realms, ",") == "dev,staging" }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for env, value := range test.env {
+				t.Setenv(env, value)
+			}
+			r, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS+test.props)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.check(r) {
+				t.Errorf("unexpected config %+v and realms %v", r.config, r.realms)
+			}
+		})
+	}
+}
+
+func TestPropertiesOnlyFromEnv(t *testing.T) {
+	t.Setenv("KEYCLOAK_URL", "http://localhost:8080")
+	t.Setenv("KEYCLOAK_USER", "admin")
+	t.Setenv("KEYCLOAK_PASSWORD", "secret")
+	t.Setenv("KEYCLOAK_REALM", "test")
+	r, err := loadTestProps(t, PROPS_FILE_NAME, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.config.Server != "http://localhost:8080" || strings.Join(r.realms, ",") != "test" {
+		t.Errorf("unexpected config %+v and realms %v", r.config, r.realms)
+	}
+}
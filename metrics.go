@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// writeMetrics writes the counters of the run in the Prometheus text format, e.g. for the textfile
+// collector of the node exporter. The file is replaced at once, not to be scraped half written
+func (r *runner) writeMetrics(fileName string, duration time.Duration) error {
+	metrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value float64
+	}{
+		{"group2role_groups_processed_total", "counter", "Groups whose mapping was checked", float64(r.summary.GroupsProcessed)},
+		{"group2role_roles_created_total", "counter", "Roles created", float64(r.summary.RolesCreated)},
+		{"group2role_mappings_created_total", "counter", "Group to role mappings created", float64(r.summary.MappingsCreated)},
+		{"group2role_errors_total", "counter", "Roles and mappings that could not be created or removed", float64(r.summary.Errors)},
+		{"group2role_run_duration_seconds", "gauge", "Duration of the run", duration.Seconds()},
+	}
+	var content strings.Builder
+	for _, metric := range metrics {
+		fmt.Fprintf(&content, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(&content, "# TYPE %s %s\n", metric.name, metric.kind)
+		fmt.Fprintf(&content, "%s %g\n", metric.name, metric.value)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot write %s %s: %w", PROPS_METRICS_FILE, fileName, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write %s %s: %w", PROPS_METRICS_FILE, fileName, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write %s %s: %w", PROPS_METRICS_FILE, fileName, err)
+	}
+	// CreateTemp restricts the file to its owner, the collector may run as another user
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("cannot write %s %s: %w", PROPS_METRICS_FILE, fileName, err)
+	}
+	if err := os.Rename(tmp.Name(), fileName); err != nil {
+		return fmt.Errorf("cannot write %s %s: %w", PROPS_METRICS_FILE, fileName, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// metricLine matches the samples of the Prometheus text format
+var metricLine = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]* [-+0-9.eE]+$`)
+
+func TestWriteMetrics(t *testing.T) {
+	r := newRunner()
+	r.summary = RunSummary{Summary: mapper.Summary{GroupsProcessed: 12, RolesCreated: 3, MappingsCreated: 5, Errors: 1}}
+	fileName := filepath.Join(t.TempDir(), "group2role.prom")
+	if err := r.writeMetrics(fileName, 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		if !metricLine.MatchString(line) {
+			t.Errorf("invalid sample %q", line)
+			continue
+		}
+		name, value, _ := strings.Cut(line, " ")
+		samples[name] = value
+	}
+	expected := map[string]string{
+		"group2role_groups_processed_total": "12",
+		"group2role_roles_created_total":    "3",
+		"group2role_mappings_created_total": "5",
+		"group2role_errors_total":           "1",
+		"group2role_run_duration_seconds":   "1.5",
+	}
+	for name, value := range expected {
+		if samples[name] != value {
+			t.Errorf("expected %s %s, got %q", name, value, samples[name])
+		}
+		if !strings.Contains(string(content), "# TYPE "+name+" ") {
+			t.Errorf("expected the type of %s", name)
+		}
+	}
+	if info, err := os.Stat(fileName); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("expected the metrics to be readable by the collector, got %v", info.Mode())
+	}
+}
+
+func TestMetricsFileOfRun(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	s.AddRealm("test").AddGroup("/admins")
+	metricsFile := filepath.Join(t.TempDir(), "group2role.prom")
+	if err := runTest(t, serverProps(s, "test")+"auto.confirm=true\nmetrics.file="+metricsFile+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range []string{"group2role_roles_created_total 1\n", "group2role_mappings_created_total 1\n"} {
+		if !strings.Contains(string(content), sample) {
+			t.Errorf("expected %q in the metrics, got %s", sample, content)
+		}
+	}
+}
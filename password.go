@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/magiconair/properties"
+	"golang.org/x/term"
+)
+
+// readPassword returns the password of keycloak.user, by order of precedence: typed on the terminal with
+// -prompt-password, read from keycloak.password.file, then KEYCLOAK_PASSWORD or keycloak.password
+func (r *runner) readPassword(p *properties.Properties) (string, error) {
+	if promptPassword {
+		return r.promptForPassword()
+	}
+	if file := p.GetString(PROPS_PASSWORD_FILE, ""); file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("cannot read %s %s: %w", PROPS_PASSWORD_FILE, file, err)
+		}
+		// Editors and secret mounts often add a final newline
+		password := strings.TrimRight(string(content), "\r\n")
+		if password == "" {
+			return "", fmt.Errorf("empty password in %s %s", PROPS_PASSWORD_FILE, file)
+		}
+		return password, nil
+	}
+	return requiredProp(p, PROPS_PASSWORD)
+}
+
+// readSecret reads a line typed on the terminal, without echoing it
+var readSecret = func() ([]byte, error) {
+	return term.ReadPassword(int(os.Stdin.Fd()))
+}
+
+// promptForPassword reads the password from the terminal, without echoing it
+func (r *runner) promptForPassword() (string, error) {
+	if !stdinIsTerminal() {
+		return "", fmt.Errorf("cannot prompt for the password, stdin is not a terminal")
+	}
+	fmt.Fprint(r.console, "Password: ")
+	password, err := readSecret()
+	fmt.Fprintln(r.console)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the password: %w", err)
+	}
+	return string(password), nil
+}
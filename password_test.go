@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TEST_PROPS_WITHOUT_PASSWORD are the required properties, except the password
+const TEST_PROPS_WITHOUT_PASSWORD = `keycloak.url=http://localhost:8080
+keycloak.user=admin
+keycloak.realm=test
+`
+
+func TestPasswordFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+		err      string
+	}{
+		{"password", "secret", "secret", ""},
+		{"final newline", "secret\n", "secret", ""},
+		{"windows line ending", "secret\r\n", "secret", ""},
+		{"spaces kept", " secret ", " secret ", ""},
+		{"empty", "\n", "", "empty password in keycloak.password.file"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := writeTestFile(t, "password", test.content)
+			r, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS_WITHOUT_PASSWORD+"keycloak.password.file="+file+"\n")
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.config.Password != test.expected {
+				t.Errorf("expected password %q, got %q", test.expected, r.config.Password)
+			}
+		})
+	}
+}
+
+func TestMissingPasswordFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "missing")
+	_, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS_WITHOUT_PASSWORD+"keycloak.password.file="+file+"\n")
+	if err == nil || !strings.Contains(err.Error(), "cannot read keycloak.password.file") {
+		t.Errorf("expected the missing file to be reported, got %v", err)
+	}
+}
+
+func TestPasswordPrecedence(t *testing.T) {
+	file := writeTestFile(t, "password", "from-file")
+	tests := []struct {
+		name     string
+		prompt   bool
+		env      string
+		props    string
+		expected string
+	}{
+		{"property", false, "", "keycloak.password=from-props\n", "from-props"},
+		{"env over property", false, "from-env", "keycloak.password=from-props\n", "from-env"},
+		{"file over env", false, "from-env", "keycloak.password=from-props\nkeycloak.password.file=" + file + "\n", "from-file"},
+		{"prompt over file", true, "from-env", "keycloak.password=from-props\nkeycloak.password.file=" + file + "\n", "typed"},
+		{"prompt without property", true, "", "", "typed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("KEYCLOAK_PASSWORD", test.env)
+			setFlag(t, &promptPassword, test.prompt)
+			setFlag(t, &stdinIsTerminal, func() bool { return true })
+			setFlag(t, &readSecret, func() ([]byte, error) { return []byte("typed"), nil })
+			r, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS_WITHOUT_PASSWORD+test.props)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.config.Password != test.expected {
+				t.Errorf("expected password %q, got %q", test.expected, r.config.Password)
+			}
+		})
+	}
+}
+
+func TestPromptPasswordErrors(t *testing.T) {
+	setFlag(t, &promptPassword, true)
+	setFlag(t, &stdinIsTerminal, func() bool { return false })
+	if _, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS_WITHOUT_PASSWORD); err == nil ||
+		!strings.Contains(err.Error(), "stdin is not a terminal") {
+		t.Errorf("expected the prompt to need a terminal, got %v", err)
+	}
+	setFlag(t, &stdinIsTerminal, func() bool { return true })
+	setFlag(t, &readSecret, func() ([]byte, error) { return nil, errors.New("interrupted") })
+	if _, err := loadTestProps(t, PROPS_FILE_NAME, TEST_PROPS_WITHOUT_PASSWORD); err == nil ||
+		!strings.Contains(err.Error(), "cannot read the password: interrupted") {
+		t.Errorf("expected the read error, got %v", err)
+	}
+}
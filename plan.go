@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// PLAN_SCHEMA_VERSION is the version of the format of planDocument, increased on incompatible changes
+const PLAN_SCHEMA_VERSION = 1
+
+// planDocument is the plan printed by -plan-only
+type planDocument struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Realms        []mapper.Report `json:"realms"`
+}
+
+// writePlans saves the plans of all the processed realms, to apply them later with -plan-in
+func writePlans(fileName string, plans []mapper.Report) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("cannot create plan file %s: %w", fileName, err)
+	}
+	defer f.Close()
+	if err := printJSONReport(f, plans); err != nil {
+		return fmt.Errorf("cannot write plan file %s: %w", fileName, err)
+	}
+	logger.Info("Saved plan", "file", fileName, "realms", len(plans))
+	return nil
+}
+
+// readPlans loads the plans saved with -plan-out
+func readPlans(fileName string) ([]mapper.Report, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read plan file %s: %w", fileName, err)
+	}
+	var plans []mapper.Report
+	if err := json.Unmarshal(content, &plans); err != nil {
+		return nil, fmt.Errorf("cannot parse plan file %s: %w", fileName, err)
+	}
+	return plans, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+// sortedKeys returns the keys of the JSON object, sorted
+func sortedKeys(object map[string]interface{}) []string {
+	keys := []string{}
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPlanOnlySchema(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	realm.AddGroup("/admins")
+	setFlag(t, &planOnly, true)
+	setFlag(t, &propsFile, writeTestFile(t, PROPS_FILE_NAME, serverProps(s, "test")+"auto.confirm=true\n"))
+	var out bytes.Buffer
+	r := newRunner()
+	r.console = io.Discard
+	r.report = &out
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &document); err != nil {
+		t.Fatalf("expected a JSON plan, got %v: %s", err, out.String())
+	}
+	if keys := sortedKeys(document); !reflect.DeepEqual(keys, []string{"realms", "schemaVersion"}) {
+		t.Errorf("unexpected plan fields %v", keys)
+	}
+	if document["schemaVersion"] != float64(PLAN_SCHEMA_VERSION) {
+		t.Errorf("expected schema version %d, got %v", PLAN_SCHEMA_VERSION, document["schemaVersion"])
+	}
+	realms := document["realms"].([]interface{})
+	if len(realms) != 1 {
+		t.Fatalf("expected the plan of a realm, got %v", realms)
+	}
+	report := realms[0].(map[string]interface{})
+	if keys := sortedKeys(report); !reflect.DeepEqual(keys, []string{"applied", "mappings", "missingRoles", "realm"}) {
+		t.Errorf("unexpected realm fields %v", keys)
+	}
+	if report["realm"] != "test" || report["applied"] != false {
+		t.Errorf("expected the unapplied plan of realm test, got %v", report)
+	}
+	mapping := report["mappings"].([]interface{})[0].(map[string]interface{})
+	if keys := sortedKeys(mapping); !reflect.DeepEqual(keys, []string{"group", "groupId", "path", "role", "rolesAfter", "rolesBefore"}) {
+		t.Errorf("unexpected mapping fields %v", keys)
+	}
+
+	// The plan is never applied, even with auto.confirm
+	if len(realm.RoleNames()) != 0 || len(realm.Group("/admins").RealmRoles) != 0 {
+		t.Errorf("expected no change, got roles %v", realm.RoleNames())
+	}
+}
+
+func TestPlanOnlyOutput(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	s.AddRealm("test")
+	setFlag(t, &planOnly, true)
+	setFlag(t, &outputFormat, OUTPUT_CSV)
+	err := runTest(t, serverProps(s, "test"))
+	if err == nil || !strings.Contains(err.Error(), "always prints JSON") {
+		t.Errorf("expected -plan-only to reject the CSV output, got %v", err)
+	}
+}
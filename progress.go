@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// PROGRESS_INTERVAL throttles the progress counter on a terminal, PROGRESS_LOG_INTERVAL the progress
+// logs otherwise
+const PROGRESS_INTERVAL = 200 * time.Millisecond
+const PROGRESS_LOG_INTERVAL = 10 * time.Second
+
+// newProgress returns the progress callback of the mapper: a counter updated in place when stderr is
+// a terminal, periodic log lines otherwise
+func newProgress() func(done int, total int) {
+	terminal := isTerminal(os.Stderr)
+	interval := PROGRESS_LOG_INTERVAL
+	if terminal {
+		interval = PROGRESS_INTERVAL
+	}
+	last := time.Now()
+	return func(done int, total int) {
+		if done < total && time.Since(last) < interval {
+			return
+		}
+		last = time.Now()
+		if !terminal {
+			logger.Info("Processed groups", "done", done, "total", total)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "\rProcessed %d/%d groups", done, total)
+		if done == total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
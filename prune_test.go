@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// newPruneServer returns a server whose group /admins is mapped to the role legacy of the deleted group /old
+func newPruneServer(t *testing.T) (*keycloaktest.Server, *keycloaktest.Realm) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	realm.AddGroup("/admins", "admins", "legacy")
+	realm.AddRole("admins")
+	role := realm.AddRole("legacy")
+	role.Attributes[mapper.DEFAULT_MANAGED_ATTRIBUTE] = []string{mapper.MANAGED_VALUE}
+	role.Attributes[mapper.SOURCE_GROUP_ATTRIBUTE] = []string{"/old"}
+	return s, realm
+}
+
+func TestPruneDryRun(t *testing.T) {
+	for _, props := range []string{"dry.run.only=true\n", "dry.run.only=true\nauto.confirm=true\n"} {
+		s, realm := newPruneServer(t)
+		setFlag(t, &prune, true)
+		setFlag(t, &pruneRoles, true)
+		if err := runTest(t, serverProps(s, "test")+props); err != nil {
+			t.Fatal(err)
+		}
+		for _, request := range s.Requests() {
+			if strings.HasPrefix(request, http.MethodDelete+" ") {
+				t.Errorf("%q: expected no delete call in a dry run, got %s", props, request)
+			}
+		}
+		if roles := strings.Join(realm.Group("/admins").RealmRoles, ","); roles != "admins,legacy" || realm.Role("legacy") == nil {
+			t.Errorf("%q: expected the mapping and the role to be kept, got %s", props, roles)
+		}
+	}
+}
+
+func TestPruneNeedsConfirmation(t *testing.T) {
+	s, realm := newPruneServer(t)
+	setFlag(t, &prune, true)
+	setFlag(t, &stdinIsTerminal, func() bool { return false })
+	if err := runTest(t, serverProps(s, "test")); err == nil || !strings.Contains(err.Error(), "cannot ask for confirmation") {
+		t.Errorf("expected the prune to need a confirmation, got %v", err)
+	}
+	if roles := strings.Join(realm.Group("/admins").RealmRoles, ","); roles != "admins,legacy" {
+		t.Errorf("expected the mapping to be kept without confirmation, got %s", roles)
+	}
+
+	setStdin(t, "n\n")
+	if err := runTest(t, serverProps(s, "test")); err != nil {
+		t.Fatal(err)
+	}
+	if roles := strings.Join(realm.Group("/admins").RealmRoles, ","); roles != "admins,legacy" {
+		t.Errorf("expected the mapping to be kept when declined, got %s", roles)
+	}
+
+	if err := runTest(t, serverProps(s, "test")+"auto.confirm=true\n"); err != nil {
+		t.Fatal(err)
+	}
+	if roles := strings.Join(realm.Group("/admins").RealmRoles, ","); roles != "admins" {
+		t.Errorf("expected the mapping to be removed once confirmed, got %s", roles)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		orphaned bool
+		missing  bool
+		mappings map[string]string
+		roles    string
+	}{
+		{"add only", false, true, map[string]string{"/admins": "admins", "/users": "users"}, "admins,users"},
+		{"remove only", true, false, map[string]string{"/admins": "admins"}, "admins"},
+		{"mixed", true, true, map[string]string{"/admins": "admins", "/users": "users"}, "admins,users"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := keycloaktest.NewServer(t)
+			realm := s.AddRealm("test")
+			realm.AddGroup("/admins", "admins")
+			realm.AddRole("admins")
+			if test.orphaned {
+				realm.Group("/admins").RealmRoles = append(realm.Group("/admins").RealmRoles, "legacy")
+				role := realm.AddRole("legacy")
+				role.Attributes[mapper.DEFAULT_MANAGED_ATTRIBUTE] = []string{mapper.MANAGED_VALUE}
+				role.Attributes[mapper.SOURCE_GROUP_ATTRIBUTE] = []string{"/old"}
+			}
+			if test.missing {
+				realm.AddGroup("/users")
+			}
+			setFlag(t, &reconcile, true)
+			setFlag(t, &prune, true)
+			setFlag(t, &pruneRoles, true)
+
+			// A single confirmation covers the additions and the removals
+			setStdin(t, "n\n")
+			if err := runTest(t, serverProps(s, "test")); err != nil {
+				t.Fatal(err)
+			}
+			for _, request := range s.Requests() {
+				if !strings.HasPrefix(request, http.MethodGet+" ") && !strings.Contains(request, "/token") {
+					t.Errorf("expected no change when declined, got %s", request)
+				}
+			}
+
+			setStdin(t, "y\n")
+			if err := runTest(t, serverProps(s, "test")); err != nil {
+				t.Fatal(err)
+			}
+			for groupPath, expected := range test.mappings {
+				if roles := strings.Join(realm.Group(groupPath).RealmRoles, ","); roles != expected {
+					t.Errorf("expected group %s to be mapped to %s, got %s", groupPath, expected, roles)
+				}
+			}
+			if roles := strings.Join(realm.RoleNames(), ","); roles != test.roles {
+				t.Errorf("expected the roles %s, got %s", test.roles, roles)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+const CSV_CREATE_ROLE = "create-role"
+const CSV_CREATE_MAPPING = "create-mapping"
+const CSV_NOOP = "noop"
+
+// printJSONReport prints the reports of all the processed realms, or any other value, as indented JSON
+func printJSONReport(w io.Writer, reports interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(reports)
+}
+
+// printCSVReport prints one row per missing role, missing mapping and mapping already in place, for all
+// the processed realms. The missing roles are listed with the path of the first group mapped to them
+func printCSVReport(w io.Writer, reports []mapper.Report) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"realm", "groupPath", "roleName", "action"}); err != nil {
+		return err
+	}
+	for _, r := range reports {
+		for _, roleName := range r.MissingRoles {
+			groupPath := ""
+			for _, mapping := range r.Mappings {
+				if mapping.Role == roleName {
+					groupPath = mapping.Path
+					break
+				}
+			}
+			if err := writer.Write([]string{r.Realm, groupPath, roleName, CSV_CREATE_ROLE}); err != nil {
+				return err
+			}
+		}
+		for _, mapping := range r.Mappings {
+			if err := writer.Write([]string{r.Realm, mapping.Path, mapping.Role, CSV_CREATE_MAPPING}); err != nil {
+				return err
+			}
+		}
+		for _, mapping := range r.ExistingMappings {
+			if err := writer.Write([]string{r.Realm, mapping.Path, mapping.Role, CSV_NOOP}); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
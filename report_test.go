@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+func TestPrintCSVReport(t *testing.T) {
+	reports := []mapper.Report{
+		{Realm: "test", MissingRoles: []string{"read,write"},
+			Mappings: []mapper.GroupRoleMapping{
+				{Path: "/apps/read,write", Role: "read,write"},
+				{Path: `/quoted "group"`, Role: "quoted"},
+			},
+			ExistingMappings: []mapper.GroupRoleMapping{{Path: "/admins", Role: "admins"}}},
+		{Realm: "other"},
+	}
+	var out bytes.Buffer
+	if err := printCSVReport(&out, reports); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"realm", "groupPath", "roleName", "action"},
+		{"test", "/apps/read,write", "read,write", CSV_CREATE_ROLE},
+		{"test", "/apps/read,write", "read,write", CSV_CREATE_MAPPING},
+		{"test", `/quoted "group"`, "quoted", CSV_CREATE_MAPPING},
+		{"test", "/admins", "admins", CSV_NOOP},
+	}
+	rows, err := csv.NewReader(bytes.NewReader(out.Bytes())).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV %s: %v", out.String(), err)
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected rows %q, got %q", expected, rows)
+	}
+	if !bytes.Contains(out.Bytes(), []byte(`"/apps/read,write"`)) || !bytes.Contains(out.Bytes(), []byte(`"/quoted ""group"""`)) {
+		t.Errorf("expected the commas and the quotes to be escaped, got %s", out.String())
+	}
+}
+
+func TestPrintCSVReportWithoutChanges(t *testing.T) {
+	var out bytes.Buffer
+	if err := printCSVReport(&out, []mapper.Report{{Realm: "test"}}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "realm,groupPath,roleName,action\n" {
+		t.Errorf("expected the header only, got %q", out.String())
+	}
+}
+
+func TestJSONLinesOutput(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	realm.AddGroup("/admins")
+	realm.AddGroup("/eng/backend")
+	setFlag(t, &outputFormat, OUTPUT_JSONL)
+	setFlag(t, &propsFile, writeTestFile(t, PROPS_FILE_NAME, serverProps(s, "test")+"dry.run.only=true\n"))
+	var out strings.Builder
+	r := newRunner()
+	r.console = io.Discard
+	r.report = &out
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+	roles := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		var change mapper.PlannedChange
+		if err := json.Unmarshal([]byte(line), &change); err != nil {
+			t.Fatalf("cannot parse line %q: %v", line, err)
+		}
+		if change.Realm != "test" {
+			t.Errorf("expected the realm in each line, got %q", line)
+		}
+		if change.Action == "create-mapping" {
+			roles[change.Group] = change.Role
+		}
+	}
+	expected := map[string]string{"/admins": "admins", "/eng": "eng", "/eng/backend": "backend"}
+	if !reflect.DeepEqual(roles, expected) {
+		t.Errorf("expected the mappings %v, got %v", expected, roles)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// writeRollbacks saves the changes applied to all the processed realms, to undo them with -rollback-in
+func writeRollbacks(fileName string, rollbacks []mapper.Rollback) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("cannot create rollback file %s: %w", fileName, err)
+	}
+	defer f.Close()
+	if err := printJSONReport(f, rollbacks); err != nil {
+		return fmt.Errorf("cannot write rollback file %s: %w", fileName, err)
+	}
+	logger.Info("Saved rollback", "file", fileName, "realms", len(rollbacks))
+	return nil
+}
+
+// readRollbacks loads the rollbacks saved with -rollback-out
+func readRollbacks(fileName string) ([]mapper.Rollback, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read rollback file %s: %w", fileName, err)
+	}
+	var rollbacks []mapper.Rollback
+	if err := json.Unmarshal(content, &rollbacks); err != nil {
+		return nil, fmt.Errorf("cannot parse rollback file %s: %w", fileName, err)
+	}
+	return rollbacks, nil
+}
+
+// runRollbacks undoes the changes of the rollback file, realm by realm, after their confirmation
+func (r *runner) runRollbacks() error {
+	rollbacks, err := readRollbacks(rollbackIn)
+	if err != nil {
+		return err
+	}
+	for _, rollback := range rollbacks {
+		realmConfig := r.config
+		realmConfig.Realm = rollback.Realm
+		m := mapper.New(r.k, realmConfig)
+		if err := r.checkMasterRealm(rollback.Realm); err != nil {
+			return err
+		}
+		if err := m.LoadRollback(r.ctx, rollback); err != nil {
+			return err
+		}
+		m.PrintPlan(r.report)
+		if r.dryRunOnly || !m.PruneNeeded() {
+			m.AuditPlan()
+			continue
+		}
+		confirmed, err := r.confirm("Do you really want to undo these changes?", rollback.Realm)
+		if err != nil {
+			return err
+		}
+		if confirmed {
+			if err := m.Prune(r.ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
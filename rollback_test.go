@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+func TestRollbackOutAndIn(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	realm.AddGroup("/admins")
+	realm.AddGroup("/users", "users")
+	realm.AddRole("users")
+	props := serverProps(s, "test") + "auto.confirm=true\n"
+	file := writeTestFile(t, "undo.json", "")
+
+	setFlag(t, &rollbackOut, file)
+	if err := runTest(t, props); err != nil {
+		t.Fatal(err)
+	}
+	rollbacks, err := readRollbacks(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollbacks) != 1 || strings.Join(rollbacks[0].CreatedRoles, ",") != "admins" || len(rollbacks[0].CreatedMappings) != 1 {
+		t.Fatalf("expected the role and the mapping of /admins to be saved, got %+v", rollbacks)
+	}
+
+	rollbackOut = ""
+	setFlag(t, &rollbackIn, file)
+	if err := runTest(t, props); err != nil {
+		t.Fatal(err)
+	}
+	if roles := strings.Join(realm.RoleNames(), ","); roles != "users" {
+		t.Errorf("expected the role admins to be deleted, got %s", roles)
+	}
+	if len(realm.Group("/admins").RealmRoles) != 0 || strings.Join(realm.Group("/users").RealmRoles, ",") != "users" {
+		t.Errorf("expected only the mapping of /admins to be removed, got %v and %v",
+			realm.Group("/admins").RealmRoles, realm.Group("/users").RealmRoles)
+	}
+}
+
+func TestRollbackInDryRun(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	realm.AddGroup("/admins", "admins")
+	realm.AddRole("admins")
+	file := writeTestFile(t, "undo.json", `[{"realm":"test","createdRoles":["admins"],"createdMappings":[{"path":"/admins","role":"admins"}]}]`)
+	setFlag(t, &rollbackIn, file)
+	if err := runTest(t, serverProps(s, "test")+"dry.run.only=true\n"); err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range s.Requests() {
+		if strings.HasPrefix(request, "DELETE ") {
+			t.Errorf("expected nothing to be undone in a dry run, got %s", request)
+		}
+	}
+	if realm.Role("admins") == nil || len(realm.Group("/admins").RealmRoles) != 1 {
+		t.Error("expected the role and the mapping of /admins to be kept")
+	}
+}
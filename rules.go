@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadMappingRules reads the groupPath=roleName rules of the mapping file, one per line. Blank lines
+// and lines starting with # are ignored. A .json file holds a single object of the rules, as written
+// by -export-baseline
+func loadMappingRules(fileName string) (map[string]string, error) {
+	if strings.EqualFold(filepath.Ext(fileName), ".json") {
+		return loadJSONMappingRules(fileName)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open mapping file %s: %w", fileName, err)
+	}
+	defer f.Close()
+
+	rules := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		groupPath, roleName, found := strings.Cut(line, "=")
+		groupPath = strings.TrimSpace(groupPath)
+		roleName = strings.TrimSpace(roleName)
+		if !found || groupPath == "" || roleName == "" {
+			return nil, fmt.Errorf("malformed rule at %s:%d, expected groupPath=roleName: %s", fileName, lineNumber, line)
+		}
+		if !strings.HasPrefix(groupPath, "/") {
+			return nil, fmt.Errorf("malformed rule at %s:%d, the group path must start with /: %s", fileName, lineNumber, line)
+		}
+		if _, duplicate := rules[groupPath]; duplicate {
+			return nil, fmt.Errorf("duplicate rule for group %s at %s:%d", groupPath, fileName, lineNumber)
+		}
+		rules[groupPath] = roleName
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read mapping file %s: %w", fileName, err)
+	}
+	logger.Debug("Loaded mapping rules", "file", fileName, "count", len(rules))
+	return rules, nil
+}
+
+// loadJSONMappingRules reads the mapping file as a JSON object of the roles by group path
+func loadJSONMappingRules(fileName string) (map[string]string, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open mapping file %s: %w", fileName, err)
+	}
+	rules := map[string]string{}
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, fmt.Errorf("cannot parse mapping file %s: %w", fileName, err)
+	}
+	for groupPath, roleName := range rules {
+		if !strings.HasPrefix(groupPath, "/") || roleName == "" {
+			return nil, fmt.Errorf("malformed rule in %s, expected a group path starting with / and a role: %s", fileName, groupPath)
+		}
+	}
+	logger.Debug("Loaded mapping rules", "file", fileName, "count", len(rules))
+	return rules, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadMappingRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		expected map[string]string
+		err      string
+	}{
+		{"rules", "rules.properties", "# comment\n\n/admins = administrators\n/apps/web=web-user\n",
+			map[string]string{"/admins": "administrators", "/apps/web": "web-user"}, ""},
+		{"windows line endings", "rules.properties", "/admins=administrators\r\n",
+			map[string]string{"/admins": "administrators"}, ""},
+		{"json", "rules.json", `{"/admins": "administrators"}`, map[string]string{"/admins": "administrators"}, ""},
+		{"missing separator", "rules.properties", "/admins\n", nil, "malformed rule at"},
+		{"missing role", "rules.properties", "/admins=\n", nil, "expected groupPath=roleName"},
+		{"missing group", "rules.properties", "=administrators\n", nil, "expected groupPath=roleName"},
+		{"relative path", "rules.properties", "admins=administrators\n", nil, "must start with /"},
+		{"duplicate", "rules.properties", "/admins=a\n/admins=b\n", nil, "duplicate rule for group /admins"},
+		{"malformed json", "rules.json", `["/admins"]`, nil, "cannot parse mapping file"},
+		{"empty json role", "rules.json", `{"/admins": ""}`, nil, "malformed rule"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := loadMappingRules(writeTestFile(t, test.file, test.content))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != len(test.expected) {
+				t.Errorf("expected rules %v, got %v", test.expected, rules)
+			}
+			for groupPath, role := range test.expected {
+				if rules[groupPath] != role {
+					t.Errorf("expected group %s to be mapped to %s, got %q", groupPath, role, rules[groupPath])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadMappingRulesMissingFile(t *testing.T) {
+	if _, err := loadMappingRules(t.TempDir() + "/missing.properties"); err == nil ||
+		!strings.Contains(err.Error(), "cannot open mapping file") {
+		t.Errorf("expected the missing file to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+	"github.com/zemirco/keycloak"
+)
+
+// runner holds the state of a run: the settings read from the configuration, the Keycloak client and
+// the outputs. The flags are only read, the settings they combine with are copied here by initProps
+type runner struct {
+	ctx context.Context
+	k   *keycloak.Keycloak
+	// config is the configuration of the mappers, copied for each realm
+	config mapper.Config
+	// realms are the realms to process, ALL_REALMS for all the realms of the server
+	realms []string
+	// console receives the summary and the confirmation prompt, it's moved to stderr when stdout
+	// is reserved to the JSON report. Diagnostics go through logger
+	console io.Writer
+	// report receives the report in the selected format: stdout, or the file of -report-out
+	report         io.Writer
+	outputFormat   string
+	reportTemplate *template.Template
+	dryRunOnly     bool
+	autoConfirm    bool
+	// strict fails the run on the warnings of the plan
+	strict      bool
+	confirmMode string
+	// allowMaster allows mapping the groups of the master realm
+	allowMaster bool
+	// runTimeout bounds the whole run, 0 is unlimited
+	runTimeout time.Duration
+	// auditFile accumulates the changes of all the runs, metricsFile receives the metrics of the run
+	auditFile   string
+	metricsFile string
+	// checkpointOut appends the completed groups to the checkpoint file
+	checkpointOut *os.File
+	summary       RunSummary
+	driftDetected bool
+	// selectAll approves all the remaining changes of the run, selectQuit rejects them
+	selectAll  bool
+	selectQuit bool
+}
+
+// newRunner returns a runner printing to stdout, before the configuration is read
+func newRunner() *runner {
+	return &runner{ctx: context.Background(), console: os.Stdout, report: os.Stdout, outputFormat: outputFormat,
+		confirmMode: CONFIRM_SIMPLE}
+}
+
+// run checks the flags, reads the configuration and processes the realms
+func (r *runner) run() error {
+	if templateName != "" && r.outputFormat == OUTPUT_TEXT {
+		r.outputFormat = OUTPUT_TEMPLATE
+	}
+	if r.outputFormat == OUTPUT_TEMPLATE {
+		if listOnly {
+			return fmt.Errorf("-%s %s cannot be combined with -%s", FLAG_OUTPUT, OUTPUT_TEMPLATE, FLAG_LIST)
+		}
+		name := templateName
+		if name == "" {
+			name = TEMPLATE_MARKDOWN
+		}
+		tmpl, err := loadReportTemplate(name)
+		if err != nil {
+			return err
+		}
+		r.reportTemplate = tmpl
+	} else if templateName != "" {
+		return fmt.Errorf("-%s only applies to -%s %s", FLAG_TEMPLATE, FLAG_OUTPUT, OUTPUT_TEMPLATE)
+	}
+	if planOnly {
+		if r.outputFormat != OUTPUT_TEXT && r.outputFormat != OUTPUT_JSON {
+			return fmt.Errorf("-%s always prints JSON, it cannot be combined with -%s %s", FLAG_PLAN_ONLY, FLAG_OUTPUT, r.outputFormat)
+		}
+		if planIn != "" || rollbackIn != "" || checkOnly || listOnly {
+			return fmt.Errorf("-%s cannot be combined with -%s, -%s, -%s or -%s", FLAG_PLAN_ONLY, FLAG_PLAN_IN, FLAG_ROLLBACK_IN,
+				FLAG_CHECK, FLAG_LIST)
+		}
+		r.outputFormat = OUTPUT_JSON
+	}
+	switch r.outputFormat {
+	case OUTPUT_TEXT, OUTPUT_DIFF:
+	case OUTPUT_JSON, OUTPUT_CSV, OUTPUT_JSONL, OUTPUT_TEMPLATE:
+		if reportOut == "" {
+			r.console = os.Stderr
+		}
+	default:
+		return fmt.Errorf("unsupported output format %s", r.outputFormat)
+	}
+	if reportOut != "" {
+		f, err := os.Create(reportOut)
+		if err != nil {
+			return fmt.Errorf("cannot create report file %s: %w", reportOut, err)
+		}
+		defer f.Close()
+		r.report = f
+	} else if (r.outputFormat == OUTPUT_TEXT || r.outputFormat == OUTPUT_DIFF) && colorEnabled(os.Stdout) {
+		r.report = &colorWriter{w: os.Stdout}
+	}
+	if planIn != "" && planOut != "" {
+		return fmt.Errorf("-%s and -%s cannot be combined", FLAG_PLAN_IN, FLAG_PLAN_OUT)
+	}
+	if scopeGroupPath != "" && scopeGroupID != "" {
+		return fmt.Errorf("-%s and -%s cannot be combined", FLAG_GROUP, FLAG_GROUP_ID)
+	}
+	if checkOnly && (autoConfirm || planIn != "" || prune) {
+		return fmt.Errorf("-%s only checks the configuration, it cannot be combined with -%s, -%s or -%s", FLAG_CHECK,
+			FLAG_YES, FLAG_PLAN_IN, FLAG_PRUNE)
+	}
+	if interactiveSelect && (autoConfirm || checkOnly || listOnly || reconcile) {
+		return fmt.Errorf("-%s cannot be combined with -%s, -%s, -%s or -%s", FLAG_INTERACTIVE_SELECT, FLAG_YES, FLAG_CHECK, FLAG_LIST,
+			FLAG_RECONCILE)
+	}
+	if rollbackIn != "" && (rollbackOut != "" || planIn != "" || planOut != "" || fromExport != "" || checkOnly || listOnly || prune) {
+		return fmt.Errorf("-%s cannot be combined with -%s, -%s, -%s, -%s, -%s, -%s or -%s", FLAG_ROLLBACK_IN, FLAG_ROLLBACK_OUT,
+			FLAG_PLAN_IN, FLAG_PLAN_OUT, FLAG_FROM_EXPORT, FLAG_CHECK, FLAG_LIST, FLAG_PRUNE)
+	}
+	if checkpointFile != "" && (rollbackIn != "" || fromExport != "" || checkOnly || listOnly || prune) {
+		return fmt.Errorf("-%s cannot be combined with -%s, -%s, -%s, -%s or -%s", FLAG_CHECKPOINT, FLAG_ROLLBACK_IN,
+			FLAG_FROM_EXPORT, FLAG_CHECK, FLAG_LIST, FLAG_PRUNE)
+	}
+	if selfTest && (planIn != "" || planOut != "" || planOnly || rollbackIn != "" || fromExport != "" || checkOnly || listOnly) {
+		return fmt.Errorf("-%s cannot be combined with -%s, -%s, -%s, -%s, -%s, -%s or -%s", FLAG_SELFTEST, FLAG_PLAN_IN, FLAG_PLAN_OUT,
+			FLAG_PLAN_ONLY, FLAG_ROLLBACK_IN, FLAG_FROM_EXPORT, FLAG_CHECK, FLAG_LIST)
+	}
+	if exportBaselineFile != "" && (planIn != "" || planOut != "" || planOnly || rollbackIn != "" || checkOnly || listOnly) {
+		return fmt.Errorf("-%s cannot be combined with -%s, -%s, -%s, -%s, -%s or -%s", FLAG_EXPORT_BASELINE, FLAG_PLAN_IN,
+			FLAG_PLAN_OUT, FLAG_PLAN_ONLY, FLAG_ROLLBACK_IN, FLAG_CHECK, FLAG_LIST)
+	}
+	if watchInterval < 0 {
+		return fmt.Errorf("invalid -%s %v: must be positive", FLAG_WATCH, watchInterval)
+	}
+	if watchInterval > 0 && (planIn != "" || planOut != "" || planOnly || rollbackIn != "" || rollbackOut != "" || checkpointFile != "" ||
+		fromExport != "" || selfTest || exportBaselineFile != "" || interactiveSelect || detectDrift || checkOnly || listOnly) {
+		return fmt.Errorf("-%s only runs the plan and the apply, it cannot be combined with -%s, -%s, -%s, -%s, -%s, -%s, -%s, -%s, "+
+			"-%s, -%s, -%s, -%s or -%s", FLAG_WATCH, FLAG_PLAN_IN, FLAG_PLAN_OUT, FLAG_PLAN_ONLY, FLAG_ROLLBACK_IN, FLAG_ROLLBACK_OUT,
+			FLAG_CHECKPOINT, FLAG_FROM_EXPORT, FLAG_SELFTEST, FLAG_EXPORT_BASELINE, FLAG_INTERACTIVE_SELECT, FLAG_DETECT_DRIFT,
+			FLAG_CHECK, FLAG_LIST)
+	}
+	if watchInterval > 0 && r.outputFormat != OUTPUT_TEXT && r.outputFormat != OUTPUT_DIFF {
+		return fmt.Errorf("-%s prints the plan of every cycle, it cannot be combined with -%s %s", FLAG_WATCH, FLAG_OUTPUT, r.outputFormat)
+	}
+	if quiet && verbose {
+		return fmt.Errorf("-%s and -%s cannot be combined", FLAG_QUIET, FLAG_VERBOSE)
+	}
+	if fromExport != "" && (checkOnly || planIn != "") {
+		return fmt.Errorf("-%s cannot be combined with -%s or -%s", FLAG_FROM_EXPORT, FLAG_CHECK, FLAG_PLAN_IN)
+	}
+	if groupsFile != "" && (scopeGroupPath != "" || scopeGroupID != "") {
+		return fmt.Errorf("-%s cannot be combined with -%s or -%s", FLAG_GROUPS_FILE, FLAG_GROUP, FLAG_GROUP_ID)
+	}
+	if prune && (scopeGroupPath != "" || scopeGroupID != "" || groupsFile != "") {
+		return fmt.Errorf("-%s needs all the groups, it cannot be combined with -%s, -%s or -%s", FLAG_PRUNE, FLAG_GROUP,
+			FLAG_GROUP_ID, FLAG_GROUPS_FILE)
+	}
+
+	start := time.Now()
+	if err := r.initProps(); err != nil {
+		if checkOnly {
+			return fmt.Errorf("configuration check failed: %w", err)
+		}
+		return err
+	}
+	if watchInterval > 0 && !r.dryRunOnly && !r.autoConfirm {
+		return fmt.Errorf("-%s cannot ask for confirmation at every cycle: pass -%s or set %s=true", FLAG_WATCH, FLAG_YES, PROPS_AUTO_CONFIRM)
+	}
+	if interactiveSelect && !r.dryRunOnly && !stdinIsTerminal() {
+		return fmt.Errorf("cannot select the changes with -%s, stdin is not a terminal", FLAG_INTERACTIVE_SELECT)
+	}
+	if r.auditFile != "" {
+		f, err := os.OpenFile(r.auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("cannot open %s %s: %w", PROPS_AUDIT_FILE, r.auditFile, err)
+		}
+		defer f.Close()
+		r.config.Audit = f
+	}
+	if r.outputFormat == OUTPUT_JSONL {
+		r.config.Stream = r.report
+	}
+	if checkpointFile != "" && !r.dryRunOnly {
+		f, err := os.OpenFile(checkpointFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("cannot open checkpoint %s: %w", checkpointFile, err)
+		}
+		defer f.Close()
+		r.checkpointOut = f
+	}
+	var stop context.CancelFunc
+	r.ctx, stop = withSignals(context.Background())
+	defer stop()
+	if r.runTimeout > 0 {
+		var cancel context.CancelFunc
+		r.ctx, cancel = context.WithTimeout(r.ctx, r.runTimeout)
+		defer cancel()
+	}
+	if checkOnly {
+		return r.checkConfiguration()
+	}
+	var err error
+	var plans []mapper.Report
+	var realmNames []string
+	if fromExport != "" {
+		var realm string
+		if r.k, realm, err = mapper.ConnectExport(fromExport); err != nil {
+			return err
+		}
+		logger.Info("Planning offline from realm export", "file", fromExport, "realm", realm)
+		realmNames = []string{realm}
+	} else {
+		connectStart := time.Now()
+		if r.k, err = mapper.Connect(r.ctx, r.config); err != nil {
+			return r.explainLogin(err)
+		}
+		logger.Debug("Phase completed", "phase", "login", "elapsed", time.Since(connectStart))
+		if selfTest {
+			return r.runSelfTest()
+		}
+		if rollbackIn != "" {
+			return r.runRollbacks()
+		}
+		if planIn != "" {
+			if plans, err = readPlans(planIn); err != nil {
+				return err
+			}
+			for _, plan := range plans {
+				realmNames = append(realmNames, plan.Realm)
+			}
+		} else if realmNames, err = r.listRealms(); err != nil {
+			return err
+		}
+	}
+	if listOnly {
+		return r.listGroupRoles(realmNames)
+	}
+	if exportBaselineFile != "" {
+		return r.exportBaseline(realmNames)
+	}
+	if watchInterval > 0 {
+		return r.watch(realmNames)
+	}
+
+	reports := []mapper.Report{}
+	rollbacks := []mapper.Rollback{}
+	for i, realm := range realmNames {
+		var plan *mapper.Report
+		if plans != nil {
+			plan = &plans[i]
+		}
+		var m *mapper.Mapper
+		m, err = r.processRealm(realm, plan)
+		reports = append(reports, m.Report())
+		r.summary.Add(m.Summary())
+		r.driftDetected = r.driftDetected || m.ChangesNeeded() || m.PruneNeeded()
+		if !r.dryRunOnly {
+			rollbacks = append(rollbacks, m.Rollback())
+		}
+		if err == nil && r.config.ClientGroupsPath != "" {
+			var clientMappers []*mapper.Mapper
+			clientMappers, err = r.processClientGroups(realm)
+			for _, clientMapper := range clientMappers {
+				reports = append(reports, clientMapper.Report())
+				r.summary.Add(clientMapper.Summary())
+				r.driftDetected = r.driftDetected || clientMapper.ChangesNeeded() || clientMapper.PruneNeeded()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	// The checkpoint is only needed to resume a failed run
+	if err == nil && r.checkpointOut != nil {
+		if removeErr := os.Remove(checkpointFile); removeErr != nil {
+			logger.Warn("Cannot remove checkpoint", "file", checkpointFile, "error", removeErr)
+		}
+	}
+	// Also saved after a failure, to undo the changes applied before it
+	if rollbackOut != "" && !r.dryRunOnly {
+		if rollbackErr := writeRollbacks(rollbackOut, rollbacks); rollbackErr != nil && err == nil {
+			err = rollbackErr
+		}
+	}
+	r.printSummary()
+	if err == nil && r.dryRunOnly && fromExport == "" && !planOnly && !quiet {
+		fmt.Fprintf(r.console, "\nNote: Disable or remove the %v option in %v to create the missing roles and mappings", PROPS_DRYRUN, propsFile)
+	}
+	if err == nil && planOut != "" {
+		err = writePlans(planOut, reports)
+	}
+	if r.metricsFile != "" {
+		if metricsErr := r.writeMetrics(r.metricsFile, time.Since(start)); metricsErr != nil && err == nil {
+			err = metricsErr
+		}
+	}
+	var reportErr error
+	switch r.outputFormat {
+	case OUTPUT_JSON:
+		if planOnly {
+			reportErr = printJSONReport(r.report, planDocument{SchemaVersion: PLAN_SCHEMA_VERSION, Realms: reports})
+		} else {
+			reportErr = printJSONReport(r.report, reports)
+		}
+	case OUTPUT_CSV:
+		reportErr = printCSVReport(r.report, reports)
+	case OUTPUT_TEMPLATE:
+		reportErr = printTemplateReport(r.report, r.reportTemplate, reports)
+	}
+	if reportErr != nil && err == nil {
+		err = reportErr
+	}
+	return err
+}
+
+// processRealm runs the whole pipeline on the given realm with a new mapper. The plan saved by an earlier
+// run is applied if any, otherwise the plan is computed
+func (r *runner) processRealm(realm string, plan *mapper.Report) (*mapper.Mapper, error) {
+	realmConfig := r.config
+	realmConfig.Realm = realm
+	return r.processConfig(realmConfig, plan)
+}
+
+// processClientGroups runs the pipeline on the roles of every client named by a sub-group of
+// group.path.to.client.role, with a mapper of the sub-groups of each client group
+func (r *runner) processClientGroups(realm string) ([]*mapper.Mapper, error) {
+	realmConfig := r.config
+	realmConfig.Realm = realm
+	clients, err := mapper.New(r.k, realmConfig).ClientGroups(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	mappers := []*mapper.Mapper{}
+	for _, client := range clients {
+		clientConfig := realmConfig
+		clientConfig.TargetClient = client
+		clientConfig.RoleNameFrom = mapper.ROLE_NAME_FROM_NAME
+		// The group of the client itself is not mapped, only its sub-groups
+		clientConfig.GroupPath = path.Join(r.config.ClientGroupsPath, client)
+		clientConfig.GroupExclude = append(append([]string{}, r.config.GroupExclude...), clientConfig.GroupPath)
+		m, err := r.processConfig(clientConfig, nil)
+		mappers = append(mappers, m)
+		if err != nil {
+			return mappers, err
+		}
+	}
+	return mappers, nil
+}
+
+// processConfig runs the whole pipeline with a new mapper of the given configuration
+func (r *runner) processConfig(realmConfig mapper.Config, plan *mapper.Report) (*mapper.Mapper, error) {
+	realm := realmConfig.Realm
+	if !quiet {
+		realmConfig.Progress = newProgress()
+	}
+	if r.checkpointOut != nil {
+		if err := r.resumeCheckpoint(&realmConfig); err != nil {
+			return mapper.New(r.k, realmConfig), err
+		}
+	}
+	m := mapper.New(r.k, realmConfig)
+	if err := r.checkMasterRealm(realm); err != nil {
+		return m, err
+	}
+	if plan != nil {
+		if err := m.LoadPlan(r.ctx, *plan); err != nil {
+			return m, err
+		}
+	} else if err := m.Plan(r.ctx); err != nil {
+		return m, err
+	}
+	switch {
+	case quiet:
+	case r.outputFormat == OUTPUT_TEXT:
+		m.PrintPlan(r.report)
+	case r.outputFormat == OUTPUT_DIFF:
+		m.PrintDiff(r.report)
+	}
+	if warnings := m.Summary().Warnings; r.strict && warnings > 0 {
+		return m, fmt.Errorf("found %d warnings in realm %s, failing with -%s", warnings, realm, FLAG_STRICT)
+	}
+	if r.dryRunOnly {
+		m.AuditPlan()
+		return m, nil
+	}
+	if err := r.checkPermissions(m, realm); err != nil {
+		return m, err
+	}
+	if reconcile {
+		return m, r.reconcileRealm(m, realm)
+	}
+	if m.ChangesNeeded() {
+		confirmed := true
+		var err error
+		if interactiveSelect {
+			err = m.Select(r.selectChange)
+		} else {
+			confirmed, err = r.confirm("Do you really want to continue?", realm)
+		}
+		if err != nil {
+			return m, err
+		}
+		if confirmed {
+			if err := m.Apply(r.ctx); err != nil {
+				return m, err
+			}
+		}
+	}
+	// With mapping.mode=exact the extra mappings are removed like the orphaned ones
+	if (prune || r.config.MappingMode == mapper.MAPPING_MODE_EXACT) && m.PruneNeeded() {
+		confirmed, err := r.confirm("Do you really want to remove the orphaned mappings and roles?", realm)
+		if err != nil || !confirmed {
+			return m, err
+		}
+		return m, m.Prune(r.ctx)
+	}
+	return m, nil
+}
+
+// checkPermissions warns before any change when the login appears to lack the admin roles needed to
+// apply them. The check is best effort: the changes are attempted anyway, unless -strict is set
+func (r *runner) checkPermissions(m *mapper.Mapper, realm string) error {
+	if !m.ChangesNeeded() && !m.PruneNeeded() {
+		return nil
+	}
+	missing, err := m.MissingPermissions(r.ctx)
+	if err != nil {
+		logger.Warn("Cannot check the permissions before applying the changes", "realm", realm, "error", err)
+		return nil
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if r.strict {
+		return fmt.Errorf("the login lacks the admin roles %v of the realm-management client in realm %s, failing with -%s",
+			missing, realm, FLAG_STRICT)
+	}
+	logger.Warn("The login appears to lack the admin roles needed to apply the changes, they will likely fail",
+		"realm", realm, "missing", missing, "client", "realm-management")
+	return nil
+}
+
+// reconcileRealm applies the additions and the removals of the plan, after a single confirmation
+func (r *runner) reconcileRealm(m *mapper.Mapper, realm string) error {
+	if !m.ChangesNeeded() && !m.PruneNeeded() {
+		return nil
+	}
+	confirmed, err := r.confirm("Do you really want to apply these additions and removals?", realm)
+	if err != nil || !confirmed {
+		return err
+	}
+	if err := m.Apply(r.ctx); err != nil {
+		return err
+	}
+	return m.Prune(r.ctx)
+}
+
+// checkMasterRealm refuses to map the master realm, unless allow.master.realm is set
+func (r *runner) checkMasterRealm(realm string) error {
+	if realm != mapper.MASTER_REALM {
+		return nil
+	}
+	if !r.allowMaster {
+		return fmt.Errorf("refusing to map the groups of the %s realm, which holds the roles administering Keycloak: "+
+			"configure another realm or set %s=true if this is really intended", mapper.MASTER_REALM, PROPS_ALLOW_MASTER_REALM)
+	}
+	logger.Warn("Mapping the groups of the master realm", "reason", PROPS_ALLOW_MASTER_REALM+"=true")
+	return nil
+}
+
+// listRealms returns the configured realms, or all the realms of the server when keycloak.realms is *
+func (r *runner) listRealms() ([]string, error) {
+	if len(r.realms) != 1 || r.realms[0] != ALL_REALMS {
+		return r.realms, nil
+	}
+	all, err := mapper.ListRealms(r.ctx, r.k, r.config)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, realm := range all {
+		if realm == mapper.MASTER_REALM && !r.allowMaster {
+			logger.Info("Skipping the master realm", "override", PROPS_ALLOW_MASTER_REALM+"=true")
+			continue
+		}
+		if !r.config.RealmAllowed(realm) {
+			logger.Info("Skipping realm not allowed", "realm", realm, "allowlist", PROPS_REALM_ALLOWLIST)
+			continue
+		}
+		names = append(names, realm)
+	}
+	logger.Info("Found realms", "realms", names)
+	return names, nil
+}
+
+// confirm asks the user to confirm the changes of the realm, unless they are automatically confirmed.
+// With confirm.mode=type-realm the user must type the realm name instead of Y
+func (r *runner) confirm(question string, realm string) (bool, error) {
+	if r.autoConfirm {
+		return true, nil
+	}
+	if !stdinIsTerminal() {
+		return false, fmt.Errorf("cannot ask for confirmation, stdin is not a terminal: pass -%s or set %s=true to apply the changes",
+			FLAG_YES, PROPS_AUTO_CONFIRM)
+	}
+
+	if r.confirmMode == CONFIRM_TYPE_REALM {
+		fmt.Fprintf(r.console, "%s Type the name of the realm to confirm (%s): ", question, realm)
+	} else {
+		fmt.Fprintf(r.console, "%s (Y/N): ", question)
+	}
+	answer, err := r.readAnswer()
+	if r.ctx.Err() != nil {
+		return false, r.ctx.Err()
+	}
+	if err != nil && answer == "" {
+		// Closed stdin, e.g. Ctrl-D: nothing was confirmed
+		fmt.Fprintln(r.console)
+		logger.Warn("No answer to the confirmation prompt, the changes are not applied", "error", err)
+		return false, nil
+	}
+	// TrimSpace also removes the carriage return of Windows line endings
+	confirmed := strings.HasPrefix(strings.ToUpper(strings.TrimSpace(answer)), "Y")
+	if r.confirmMode == CONFIRM_TYPE_REALM {
+		confirmed = strings.TrimSpace(answer) == realm
+	}
+	if !confirmed {
+		logger.Info("Changes not confirmed, nothing is applied")
+	}
+	return confirmed, nil
+}
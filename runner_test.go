@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// serverProps are the properties connecting to the test server as its admin user
+func serverProps(s *keycloaktest.Server, realm string) string {
+	return fmt.Sprintf("keycloak.url=%s\nkeycloak.user=%s\nkeycloak.password=%s\nkeycloak.realm=%s\n", s.URL,
+		keycloaktest.ADMIN_USER, keycloaktest.ADMIN_PASSWORD, realm)
+}
+
+// runTest runs the tool with the configuration, discarding its output
+func runTest(t *testing.T, props string) error {
+	t.Helper()
+	setFlag(t, &propsFile, writeTestFile(t, PROPS_FILE_NAME, props))
+	r := newRunner()
+	r.console = io.Discard
+	r.report = io.Discard
+	return r.run()
+}
+
+func TestRunFailsOnFailedMapping(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	s.AddRealm("test").AddGroup("/admins")
+	s.Fail = func(method string, path string) int {
+		if method == "POST" && strings.HasSuffix(path, "/role-mappings/realm") {
+			return 500
+		}
+		return 0
+	}
+	err := runTest(t, serverProps(s, "test")+"auto.confirm=true\nmax.retries=0\n")
+	if err == nil || !strings.Contains(err.Error(), "cannot create 1 of the 1 mappings") {
+		t.Errorf("expected the run to fail with the mapping error, got %v", err)
+	}
+}
+
+func TestListAllRealmsAllowed(t *testing.T) {
+	tests := []struct {
+		props    string
+		expected string
+	}{
+		{"", "dev-eu,dev-us,prod"},
+		{"realm.allowlist=dev-*\n", "dev-eu,dev-us"},
+		{"realm.allowlist=dev-*\nallow.master.realm=true\n", "dev-eu,dev-us"},
+		{"allow.master.realm=true\n", "dev-eu,dev-us,master,prod"},
+	}
+	for _, test := range tests {
+		s := keycloaktest.NewServer(t)
+		for _, realm := range []string{"dev-eu", "dev-us", "prod"} {
+			s.AddRealm(realm)
+		}
+		r, err := loadTestProps(t, PROPS_FILE_NAME, serverProps(s, "test")+"keycloak.realms=*\n"+test.props)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.k, err = mapper.Connect(r.ctx, r.config); err != nil {
+			t.Fatal(err)
+		}
+		realms, err := r.listRealms()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(realms)
+		if strings.Join(realms, ",") != test.expected {
+			t.Errorf("%q: expected realms %s, got %v", test.props, test.expected, realms)
+		}
+	}
+}
+
+func TestRunFromExport(t *testing.T) {
+	setFlag(t, &fromExport, filepath.Join("mapper", "testdata", "realm-export.json"))
+	setFlag(t, &reportOut, filepath.Join(t.TempDir(), "report.json"))
+	r := newRunner()
+	r.outputFormat = OUTPUT_JSON
+	r.console = io.Discard
+	setFlag(t, &propsFile, writeTestFile(t, PROPS_FILE_NAME, TEST_PROPS))
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(reportOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reports []mapper.Report
+	if err := json.Unmarshal(content, &reports); err != nil {
+		t.Fatalf("invalid report %s: %v", content, err)
+	}
+	if len(reports) != 1 || reports[0].Realm != "acme" || strings.Join(reports[0].MissingRoles, ",") != "backend,eng,viewers" {
+		t.Errorf("expected the plan of the exported realm, got %+v", reports)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		props   string
+		group   string
+		warning bool
+	}{
+		{"warning", false, "", "/my team", false},
+		{"warning with -strict", true, "", "/my team", true},
+		{"warning with strict=true", false, "strict=true\n", "/my team", true},
+		{"no warning with -strict", true, "", "/team", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := keycloaktest.NewServer(t)
+			realm := s.AddRealm("test")
+			group := realm.AddGroup(test.group)
+			setFlag(t, &strict, test.strict)
+			err := runTest(t, serverProps(s, "test")+"auto.confirm=true\nrole.name.sanitize=replace\n"+test.props)
+			if test.warning {
+				if err == nil || !strings.Contains(err.Error(), "found 1 warnings in realm test") {
+					t.Fatalf("expected the warning to fail the run, got %v", err)
+				}
+				if len(group.RealmRoles) != 0 {
+					t.Errorf("expected no change after the warning, got roles %v", group.RealmRoles)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(group.RealmRoles) != 1 {
+				t.Errorf("expected group %s to be mapped, got roles %v", test.group, group.RealmRoles)
+			}
+		})
+	}
+}
+
+func TestRoleInheritParent(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	realm.AddGroup("/eng/backend")
+	realm.AddGroup("/eng/frontend")
+	if err := runTest(t, serverProps(s, "test")+"auto.confirm=true\nrole.inherit.parent=true\n"); err != nil {
+		t.Fatal(err)
+	}
+	if composites := strings.Join(realm.Composites("eng"), ","); composites != "backend,frontend" {
+		t.Errorf("expected role eng to be composed of the roles of its sub-groups, got %s", composites)
+	}
+}
+
+func TestRunWithoutPermissions(t *testing.T) {
+	tests := []struct {
+		props string
+		err   string
+	}{
+		// The changes are attempted anyway, and fail
+		{"", "cannot create"},
+		// The pre-flight check fails before any change
+		{"strict=true\n", "lacks the admin roles [manage-realm manage-users]"},
+	}
+	for _, test := range tests {
+		s := keycloaktest.NewServer(t)
+		realm := s.AddRealm("test")
+		realm.AddGroup("/admins")
+		realm.Permissions = nil
+		s.Fail = func(method string, path string) int {
+			if method != "GET" && strings.HasPrefix(path, "admin/") {
+				return 403
+			}
+			return 0
+		}
+		err := runTest(t, serverProps(s, "test")+"auto.confirm=true\nmax.retries=0\n"+test.props)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expected error %q, got %v", test.props, test.err, err)
+		}
+		if test.props != "" && s.Count("POST", "test", "roles") != 0 {
+			t.Errorf("%q: expected no change to be attempted, got requests %v", test.props, s.Requests())
+		}
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	tests := []struct {
+		name   string
+		quiet  bool
+		format string
+	}{
+		{"text", false, OUTPUT_TEXT},
+		{"quiet text", true, OUTPUT_TEXT},
+		{"quiet json", true, OUTPUT_JSON},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := keycloaktest.NewServer(t)
+			s.AddRealm("test").AddGroup("/admins")
+			var console, report, logs strings.Builder
+			setFlag(t, &logger, slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: logLevel})))
+			setFlag(t, &quiet, test.quiet)
+			setFlag(t, &outputFormat, test.format)
+			setFlag(t, &propsFile, writeTestFile(t, PROPS_FILE_NAME, serverProps(s, "test")+"auto.confirm=true\n"))
+			r := newRunner()
+			r.console = &console
+			r.report = &report
+			if err := r.run(); err != nil {
+				t.Fatal(err)
+			}
+			groupLines := strings.Contains(report.String(), "/admins") || strings.Contains(logs.String(), "/admins")
+			switch {
+			case test.format == OUTPUT_JSON:
+				// The reports are not affected by -quiet
+				if !strings.Contains(report.String(), `"path": "/admins"`) {
+					t.Errorf("expected the JSON report, got %s", report.String())
+				}
+			case test.quiet && groupLines:
+				t.Errorf("expected no per-group line, got report %q and logs %q", report.String(), logs.String())
+			case !test.quiet && !groupLines:
+				t.Errorf("expected the per-group lines, got report %q", report.String())
+			}
+			if test.quiet && test.format == OUTPUT_TEXT && (logs.Len() != 0 || strings.Count(console.String(), "\n") != 1 || !strings.Contains(console.String(), "Created 1 roles")) {
+				t.Errorf("expected only the summary, got console %q and logs %q", console.String(), logs.String())
+			}
+		})
+	}
+}
+
+func TestClientRolesByConvention(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	realm.AddClient("app1")
+	realm.AddGroup("/clients/app1/admin")
+	realm.AddGroup("/clients/app1/viewer")
+	realm.AddGroup("/clients/ghost/admin")
+	realm.AddGroup("/ops")
+	if err := runTest(t, serverProps(s, "test")+"auto.confirm=true\ngroup.path.to.client.role=/clients\n"); err != nil {
+		t.Fatal(err)
+	}
+	for groupPath, role := range map[string]string{"/clients/app1/admin": "admin", "/clients/app1/viewer": "viewer"} {
+		group := realm.Group(groupPath)
+		if roles := strings.Join(group.ClientRoles["app1"], ","); roles != role || len(group.RealmRoles) != 0 {
+			t.Errorf("expected group %s to be mapped to the role %s of client app1, got %v and %v", groupPath, role,
+				group.ClientRoles, group.RealmRoles)
+		}
+	}
+	// The groups out of the convention keep the realm roles, the groups of unknown clients are skipped
+	if roles := strings.Join(realm.Group("/ops").RealmRoles, ","); roles != "ops" {
+		t.Errorf("expected group /ops to be mapped to realm role ops, got %s", roles)
+	}
+	for _, groupPath := range []string{"/clients", "/clients/app1", "/clients/ghost", "/clients/ghost/admin"} {
+		if group := realm.Group(groupPath); len(group.RealmRoles) != 0 || len(group.ClientRoles) != 0 {
+			t.Errorf("expected group %s not to be mapped, got %v and %v", groupPath, group.RealmRoles, group.ClientRoles)
+		}
+	}
+	if roles := strings.Join(realm.RoleNames(), ","); roles != "ops" {
+		t.Errorf("expected the realm role ops only, got %s", roles)
+	}
+}
+
+func TestDriftOfClientRoles(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	client := realm.AddClient("app1")
+	client.AddRole("admin")
+	client.AddRole("legacy")
+	realm.AddGroup("/clients/app1/admin").ClientRoles = map[string][]string{"app1": {"admin", "legacy"}}
+	setFlag(t, &detectDrift, true)
+	setFlag(t, &propsFile, writeTestFile(t, PROPS_FILE_NAME, serverProps(s, "test")+
+		"group.path.to.client.role=/clients\nmapping.mode=exact\n"))
+	r := newRunner()
+	r.console = io.Discard
+	r.report = io.Discard
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+	// The only change is the removal of the extra client role
+	if !r.driftDetected {
+		t.Error("expected the extra mapping of the client role legacy to be a drift")
+	}
+}
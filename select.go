@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const SELECT_YES = "y"
+const SELECT_NO = "n"
+const SELECT_ALL = "a"
+const SELECT_QUIT = "q"
+
+// selectChange asks whether to apply the given change, for -interactive-select
+func (r *runner) selectChange(change string) (bool, error) {
+	if r.selectAll {
+		return true, nil
+	}
+	if r.selectQuit {
+		return false, nil
+	}
+	for {
+		fmt.Fprintf(r.console, "%s: apply? (%s/%s/%s=all/%s=quit): ", change, SELECT_YES, SELECT_NO, SELECT_ALL, SELECT_QUIT)
+		answer, err := r.readAnswer()
+		if r.ctx.Err() != nil {
+			return false, r.ctx.Err()
+		}
+		if err != nil && answer == "" {
+			// Closed stdin, e.g. Ctrl-D: same as quit
+			fmt.Fprintln(r.console)
+			logger.Warn("No answer to the selection prompt, the remaining changes are not applied", "error", err)
+			r.selectQuit = true
+			return false, nil
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case SELECT_YES:
+			return true, nil
+		case SELECT_NO:
+			return false, nil
+		case SELECT_ALL:
+			r.selectAll = true
+			return true, nil
+		case SELECT_QUIT:
+			logger.Info("Selection aborted, the remaining changes are not applied")
+			r.selectQuit = true
+			return false, nil
+		}
+	}
+}
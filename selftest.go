@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+	"github.com/zemirco/keycloak"
+)
+
+// SELFTEST_REALM_PREFIX names the throwaway realms of -selftest, followed by a timestamp
+const SELFTEST_REALM_PREFIX = "group2role-selftest-"
+
+// selfTestGroups are the groups created in the throwaway realm
+var selfTestGroups = []string{"selftest-admins", "selftest-developers"}
+
+// runSelfTest maps the groups of a throwaway realm, checks that nothing is left to map and deletes the
+// realm, also after a failure. Existing realms are never touched
+func (r *runner) runSelfTest() error {
+	realm := fmt.Sprintf("%s%d", SELFTEST_REALM_PREFIX, time.Now().UnixNano())
+	_, res, err := r.k.Realms.Get(r.ctx, realm)
+	if err == nil || res == nil || res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("cannot run the self-test: realm %s already exists or cannot be checked: %v", realm, err)
+	}
+	logger.Info("Creating self-test realm", "realm", realm)
+	enabled := true
+	if _, err := r.k.Realms.Create(r.ctx, &keycloak.Realm{Realm: &realm, Enabled: &enabled}); err != nil {
+		return fmt.Errorf("cannot create self-test realm %s: %w", realm, err)
+	}
+	defer func() {
+		// The run context may be cancelled already, the realm is deleted anyway
+		if _, err := r.k.Realms.Delete(context.Background(), realm); err != nil {
+			logger.Error("Cannot delete self-test realm, delete it by hand", "realm", realm, "error", err)
+			return
+		}
+		logger.Info("Deleted self-test realm", "realm", realm)
+	}()
+	for _, name := range selfTestGroups {
+		groupName := name
+		if _, err := r.k.Groups.Create(r.ctx, realm, &keycloak.Group{Name: &groupName}); err != nil {
+			return fmt.Errorf("cannot create self-test group %s: %w", name, err)
+		}
+	}
+
+	// Only the settings that apply to any realm are kept: the filters, the overrides and the target
+	// client of the configuration don't match the throwaway realm
+	testConfig := mapper.Config{Realm: realm, Logger: logger, MaxRetries: r.config.MaxRetries, RolePrefix: r.config.RolePrefix,
+		RoleSuffix: r.config.RoleSuffix, ManagedAttribute: r.config.ManagedAttribute, RoleDescription: r.config.RoleDescription}
+	m := mapper.New(r.k, testConfig)
+	if err := m.Plan(r.ctx); err != nil {
+		return fmt.Errorf("self-test plan failed: %w", err)
+	}
+	if planned := m.Summary(); planned.PlannedRoles != len(selfTestGroups) || planned.PlannedMappings != len(selfTestGroups) {
+		return fmt.Errorf("self-test plan failed: %d roles and %d mappings planned, expected %d of each", planned.PlannedRoles,
+			planned.PlannedMappings, len(selfTestGroups))
+	}
+	if err := m.Apply(r.ctx); err != nil {
+		return fmt.Errorf("self-test apply failed: %w", err)
+	}
+	// A new plan finds the created roles and mappings
+	check := mapper.New(r.k, testConfig)
+	if err := check.Plan(r.ctx); err != nil {
+		return fmt.Errorf("self-test check failed: %w", err)
+	}
+	if check.ChangesNeeded() {
+		return fmt.Errorf("self-test check failed: the created roles or mappings are missing in realm %s", realm)
+	}
+	fmt.Fprintf(r.console, "Self-test passed: created %d roles and %d mappings in realm %s\n", m.Summary().RolesCreated,
+		m.Summary().MappingsCreated, realm)
+	return nil
+}
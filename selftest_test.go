@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+func TestSelfTest(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(method string, path string) int
+		err  string
+	}{
+		{"passed", nil, ""},
+		{"failed mapping", func(method string, path string) int {
+			if method == http.MethodPost && strings.HasSuffix(path, "/role-mappings/realm") {
+				return http.StatusForbidden
+			}
+			return 0
+		}, "self-test apply failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := keycloaktest.NewServer(t)
+			realm := s.AddRealm("test")
+			realm.AddGroup("/admins")
+			s.Fail = test.fail
+			setFlag(t, &selfTest, true)
+			err := runTest(t, serverProps(s, "test")+"max.retries=0\n")
+			if test.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+			// The throwaway realm is deleted, even after a failure, and the existing realms are left alone
+			if realms := strings.Join(s.RealmNames(), ","); realms != "master,test" {
+				t.Errorf("expected the self-test realm to be deleted, got realms %s", realms)
+			}
+			if s.Count(http.MethodDelete, "", "admin/realms/"+SELFTEST_REALM_PREFIX+"*") != 1 {
+				t.Errorf("expected the self-test realm to be deleted, got requests %v", s.Requests())
+			}
+			if len(realm.RoleNames()) != 0 || len(realm.Group("/admins").RealmRoles) != 0 {
+				t.Errorf("expected realm test to be left alone, got roles %v", realm.RoleNames())
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// withSignals cancels the context on SIGINT or SIGTERM: the run stops before its next change, then prints
+// the summary of the changes done so far. A second signal kills the process
+func withSignals(parent context.Context) (context.Context, context.CancelFunc) {
+	signalCtx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	log := logger
+	go func() {
+		<-signalCtx.Done()
+		if parent.Err() == nil {
+			log.Warn("Interrupted, stopping the run")
+		}
+		stop()
+	}()
+	return signalCtx, stop
+}
+
+// readAnswer reads a line typed on stdin, unless the run is interrupted while waiting for it
+func (r *runner) readAnswer() (string, error) {
+	type answer struct {
+		line string
+		err  error
+	}
+	answers := make(chan answer, 1)
+	reader := stdin
+	go func() {
+		line, err := reader.ReadString('\n')
+		answers <- answer{line: line, err: err}
+	}()
+	select {
+	case a := <-answers:
+		return a.line, a.err
+	case <-r.ctx.Done():
+		fmt.Fprintln(r.console)
+		return "", r.ctx.Err()
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWithSignals(t *testing.T) {
+	for _, signal := range []syscall.Signal{syscall.SIGINT, syscall.SIGTERM} {
+		ctx, stop := withSignals(context.Background())
+		if err := syscall.Kill(syscall.Getpid(), signal); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Errorf("expected %v to cancel the context", signal)
+		}
+		stop()
+	}
+}
+
+// eofReader closes eof once its reader fails, like a prompt reading a closed stdin
+type eofReader struct {
+	io.Reader
+	eof  chan struct{}
+	once sync.Once
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil {
+		r.once.Do(func() { close(r.eof) })
+	}
+	return n, err
+}
+
+func TestReadAnswerInterrupted(t *testing.T) {
+	// The answer never comes, the run is interrupted while waiting for it
+	reader, writer := io.Pipe()
+	read := &eofReader{Reader: reader, eof: make(chan struct{})}
+	setFlag(t, &stdin, bufio.NewReader(read))
+	// The cleanups run in reverse order: the pending read ends before stdin is put back
+	t.Cleanup(func() {
+		writer.Close()
+		<-read.eof
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newRunner()
+	r.ctx = ctx
+	r.console = io.Discard
+	cancel()
+	if _, err := r.readAnswer(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the prompt to be interrupted, got %v", err)
+	}
+}
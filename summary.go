@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// RunSummary counts the changes of the run, across all the realms
+type RunSummary struct {
+	mapper.Summary
+	// DryRun prints the planned changes instead of the applied ones
+	DryRun bool
+}
+
+func (s RunSummary) String() string {
+	line := fmt.Sprintf("Created %d roles, %d mappings; skipped %d existing; %d errors",
+		s.RolesCreated, s.MappingsCreated, s.SkippedExisting, s.Errors)
+	if s.DryRun {
+		line = fmt.Sprintf("Would create %d roles, %d mappings; skipped %d existing",
+			s.PlannedRoles, s.PlannedMappings, s.SkippedExisting)
+	}
+	if s.Warnings > 0 {
+		line += fmt.Sprintf("; %d warnings", s.Warnings)
+	}
+	return line
+}
+
+func (r *runner) printSummary() {
+	r.summary.DryRun = r.dryRunOnly
+	line := fmt.Sprintf("*** %v ***", r.summary)
+	if f, ok := r.console.(*os.File); ok {
+		color := ANSI_GREEN
+		if r.summary.Errors > 0 {
+			color = ANSI_RED
+		}
+		line = colorize(f, color, line)
+	}
+	fmt.Fprintln(r.console, line)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// TEMPLATE_MARKDOWN is the built-in template of -output template, printing Markdown tables e.g. for pull requests
+const TEMPLATE_MARKDOWN = "markdown"
+
+// TEMPLATE_SUMMARY is the built-in template printing one line per realm
+const TEMPLATE_SUMMARY = "summary"
+
+// builtinTemplates are the templates selected by name with -template
+var builtinTemplates = map[string]string{
+	TEMPLATE_MARKDOWN: `{{range .}}## Realm {{.Realm}}{{if .Client}}, client {{.Client}}{{end}}
+{{if .MissingRoles}}
+| Role to create |
+| --- |
+{{range .MissingRoles}}| {{.}} |
+{{end}}{{end}}{{if .Mappings}}
+| Group | Role |
+| --- | --- |
+{{range .Mappings}}| {{.Path}} | {{.Role}} |
+{{end}}{{end}}{{if .OrphanedMappings}}
+| Group | Role to remove |
+| --- | --- |
+{{range .OrphanedMappings}}| {{.Path}} | {{.Role}} |
+{{end}}{{end}}{{if .OrphanedRoles}}
+| Role to delete |
+| --- |
+{{range .OrphanedRoles}}| {{.}} |
+{{end}}{{end}}{{if not (or .MissingRoles .Mappings .OrphanedMappings .OrphanedRoles)}}
+No changes needed
+{{end}}
+{{end}}`,
+	TEMPLATE_SUMMARY: `{{range .}}{{.Realm}}: {{len .MissingRoles}} roles and {{len .Mappings}} mappings to create{{if .Applied}}, applied{{end}}
+{{end}}`,
+}
+
+// templateFuncs are the functions available to the templates, besides the text/template builtins
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// loadReportTemplate parses the built-in template of the given name, or else the template file
+func loadReportTemplate(name string) (*template.Template, error) {
+	if text, found := builtinTemplates[name]; found {
+		return template.New(name).Funcs(templateFuncs).Parse(text)
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read template %s: %w", name, err)
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse template %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// printTemplateReport renders the reports of all the processed realms with the template
+func printTemplateReport(w io.Writer, tmpl *template.Template, reports []mapper.Report) error {
+	if err := tmpl.Execute(w, reports); err != nil {
+		return fmt.Errorf("cannot render template %s: %w", tmpl.Name(), err)
+	}
+	return nil
+}
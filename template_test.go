@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// testPlan is a known plan of two realms, the second one without changes
+var testPlan = []mapper.Report{
+	{Realm: "test", MissingRoles: []string{"admins"}, Mappings: []mapper.GroupRoleMapping{
+		{Path: "/admins", Role: "admins"}, {Path: "/users", Role: "users"}}},
+	{Realm: "other", MissingRoles: []string{}, Mappings: []mapper.GroupRoleMapping{}},
+}
+
+func TestPrintTemplateReport(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"file", writeTestFile(t, "plan.tmpl", `{{range .}}{{.Realm}}:{{range .Mappings}} {{.Path}}={{.Role}}{{end}}
+{{end}}`), "test: /admins=admins /users=users\nother:\n"},
+		{"join", writeTestFile(t, "join.tmpl", `{{range .}}{{join .MissingRoles "+"}};{{end}}`), "admins;;"},
+		{TEMPLATE_SUMMARY, TEMPLATE_SUMMARY, "test: 1 roles and 2 mappings to create\nother: 0 roles and 0 mappings to create\n"},
+		{TEMPLATE_MARKDOWN, TEMPLATE_MARKDOWN, `## Realm test
+
+| Role to create |
+| --- |
+| admins |
+
+| Group | Role |
+| --- | --- |
+| /admins | admins |
+| /users | users |
+
+## Realm other
+
+No changes needed
+
+`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := loadReportTemplate(test.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out strings.Builder
+			if err := printTemplateReport(&out, tmpl, testPlan); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestInvalidTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		err      string
+	}{
+		{t.TempDir() + "/missing.tmpl", "cannot read template"},
+		{writeTestFile(t, "syntax.tmpl", "{{range .}}"), "cannot parse template"},
+	}
+	for _, test := range tests {
+		if _, err := loadReportTemplate(test.template); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.template, test.err, err)
+		}
+	}
+	tmpl, err := loadReportTemplate(writeTestFile(t, "field.tmpl", "{{range .}}{{.Unknown}}{{end}}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := printTemplateReport(io.Discard, tmpl, testPlan); err == nil || !strings.Contains(err.Error(), "cannot render template") {
+		t.Errorf("expected a render error, got %v", err)
+	}
+}
+
+func TestRunWithTemplate(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	s.AddRealm("test").AddGroup("/admins")
+	setFlag(t, &templateName, TEMPLATE_SUMMARY)
+	setFlag(t, &propsFile, writeTestFile(t, PROPS_FILE_NAME, serverProps(s, "test")+"dry.run.only=true\n"))
+	var out strings.Builder
+	r := newRunner()
+	r.console = io.Discard
+	r.report = &out
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "test: 1 roles and 1 mappings to create\n" {
+		t.Errorf("expected the summary template, got %q", out.String())
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// explainTimeout tells which timeout expired, if any
+func (r *runner) explainTimeout(err error) error {
+	if r.ctx != nil && errors.Is(r.ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("run timed out after %v (%s): %w", r.runTimeout, PROPS_RUN_TIMEOUT, err)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("Keycloak did not answer within %v (%s): %w", r.config.RequestTimeout, PROPS_REQUEST_TIMEOUT, err)
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dmartinol/keycloak-group2role/mapper"
+)
+
+// watchInterval is the delay between two cycles of -watch, 0 runs once
+var watchInterval time.Duration
+
+// watchNow and watchAfter are the clock of the watch cycles
+var watchNow = time.Now
+var watchAfter = time.After
+
+// watch plans and applies the changes of the realms every watchInterval, until the run is interrupted.
+// A failed cycle is logged and the next one runs as planned
+func (r *runner) watch(realmNames []string) error {
+	for cycle := 1; ; cycle++ {
+		start := watchNow()
+		cycleSummary := mapper.Summary{}
+		failed := 0
+		for _, realm := range realmNames {
+			m, err := r.processRealm(realm, nil)
+			cycleSummary.Add(m.Summary())
+			if r.ctx.Err() != nil {
+				return r.watchStopped(cycle)
+			}
+			if err != nil {
+				failed++
+				logger.Error("Watch cycle failed", "cycle", cycle, "realm", realm, "error", err)
+			}
+		}
+		logger.Info("Watch cycle completed", "cycle", cycle, "elapsed", watchNow().Sub(start), "failedRealms", failed,
+			"rolesCreated", cycleSummary.RolesCreated, "mappingsCreated", cycleSummary.MappingsCreated,
+			"plannedRoles", cycleSummary.PlannedRoles, "plannedMappings", cycleSummary.PlannedMappings,
+			"errors", cycleSummary.Errors, "next", start.Add(watchInterval).Format(time.RFC3339))
+		select {
+		case <-r.ctx.Done():
+			return r.watchStopped(cycle)
+		case <-watchAfter(start.Add(watchInterval).Sub(watchNow())):
+		}
+	}
+}
+
+// watchStopped ends the watch: an interruption is its normal end, while the expiry of run.timeout fails the run
+func (r *runner) watchStopped(cycles int) error {
+	logger.Info("Watch stopped", "cycles", cycles)
+	if errors.Is(r.ctx.Err(), context.DeadlineExceeded) {
+		return r.ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dmartinol/keycloak-group2role/internal/keycloaktest"
+)
+
+// fakeClock is a clock advancing by a second on each reading, and by the full delay of each wait
+type fakeClock struct {
+	now    time.Time
+	delays []time.Duration
+	// wait is called on each wait with its number, starting from 1, and returns the channel to wait on
+	wait func(n int) <-chan time.Time
+}
+
+// install replaces the clock of the watch for the duration of the test
+func (c *fakeClock) install(t *testing.T) {
+	setFlag(t, &watchNow, func() time.Time {
+		c.now = c.now.Add(time.Second)
+		return c.now
+	})
+	setFlag(t, &watchAfter, func(d time.Duration) <-chan time.Time {
+		c.delays = append(c.delays, d)
+		return c.wait(len(c.delays))
+	})
+}
+
+// elapsed returns a channel on which the wait is already over
+func elapsed() <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+func TestWatchCycles(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	realm := s.AddRealm("test")
+	realm.AddGroup("/admins")
+	clock := &fakeClock{now: time.Now()}
+	clock.wait = func(n int) <-chan time.Time {
+		switch n {
+		case 1:
+			// A group created between two cycles is mapped by the next one
+			realm.AddGroup("/users")
+			return elapsed()
+		case 2:
+			return elapsed()
+		default:
+			if err := syscall.Kill(syscall.Getpid(), syscall.SIGINT); err != nil {
+				t.Fatal(err)
+			}
+			return nil
+		}
+	}
+	clock.install(t)
+	setFlag(t, &watchInterval, 5*time.Minute)
+	if err := runTest(t, serverProps(s, "test")+"auto.confirm=true\n"); err != nil {
+		t.Fatalf("expected the interruption to end the watch without error, got %v", err)
+	}
+	if len(clock.delays) != 3 {
+		t.Errorf("expected 3 cycles, got %d", len(clock.delays))
+	}
+	for _, groupPath := range []string{"/admins", "/users"} {
+		if roles := strings.Join(realm.Group(groupPath).RealmRoles, ","); roles != strings.TrimPrefix(groupPath, "/") {
+			t.Errorf("expected group %s to be mapped, got %s", groupPath, roles)
+		}
+	}
+	// Each cycle starts an interval after the previous one, the 2 seconds read by the cycle are deducted
+	for _, delay := range clock.delays {
+		if delay != 5*time.Minute-2*time.Second {
+			t.Errorf("expected to wait for the rest of the interval, got %v", clock.delays)
+		}
+	}
+}
+
+func TestWatchTimeout(t *testing.T) {
+	s := keycloaktest.NewServer(t)
+	s.AddRealm("test").AddGroup("/admins")
+	clock := &fakeClock{now: time.Now()}
+	clock.wait = func(n int) <-chan time.Time {
+		if n == 1 {
+			return elapsed()
+		}
+		// The next cycle never comes, run.timeout expires first
+		return nil
+	}
+	clock.install(t)
+	setFlag(t, &watchInterval, time.Minute)
+	err := runTest(t, serverProps(s, "test")+"auto.confirm=true\nrun.timeout=200ms\n")
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the watch to fail with the timeout, got %v", err)
+	}
+	if len(clock.delays) != 2 {
+		t.Errorf("expected the timeout to expire during the second wait, got %d waits", len(clock.delays))
+	}
+}